@@ -29,6 +29,7 @@ const programName = "gpt2json"
 
 type Config struct {
 	showVersion bool
+	probe       bool
 	devices     []string
 }
 
@@ -42,6 +43,7 @@ func Configure(args []string, silent bool) (*Config, error) {
 
 	opt := flag.NewFlagSet(programName, flag.ContinueOnError)
 	opt.BoolVar(&ret.showVersion, "V", false, "show Version")
+	opt.BoolVar(&ret.probe, "p", false, "probe filesystems of partitions")
 
 	if silent {
 		opt.SetOutput(ioutil.Discard)
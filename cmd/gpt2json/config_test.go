@@ -32,6 +32,7 @@ func TestConfigure(t *testing.T) {
 		{"no args", []string{}, ConfigNoArgs},
 		{"help", []string{"-h"}, flag.ErrHelp},
 		{"version", []string{"-V"}, nil},
+		{"probe", []string{"-p", "disk.img"}, nil},
 		{"unknown opt", []string{"unknown"}, nil},
 	}
 
@@ -21,11 +21,12 @@ import (
 	"flag"
 	"fmt"
 	"github.com/nokute78/go-gpt/pkg/gpt"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
 	"io"
 	"os"
 )
 
-const version string = "0.0.2"
+const version string = "0.0.3"
 
 // Exit status
 const (
@@ -34,6 +35,18 @@ const (
 	ExitCmdError
 )
 
+// Table is an element of the output array.
+// Scheme tells the type of Table.
+//
+//	"GPT", "Hybrid MBR": gpt.RGpt
+//	"MBR": gpt.RMbrTable
+//	"APM": gpt.RApm
+//	"BSD disklabel": gpt.RDisklabel
+type Table struct {
+	Scheme string
+	Table  interface{}
+}
+
 // CLI has In/Out/Err streams.
 type CLI struct {
 	OutStream io.Writer
@@ -62,26 +75,75 @@ func (cli *CLI) Run(args []string) (ret int) {
 		return ExitArgError
 	}
 
-	gpts := []gpt.RGpt{}
+	tables := []Table{}
 
 	for _, v := range cnf.devices {
-		f, err := os.Open(v)
+		f, err := vdisk.Open(v)
 		if err != nil {
-			fmt.Fprintf(cli.ErrStream, "os.Open err:%s\n", err)
+			fmt.Fprintf(cli.ErrStream, "vdisk.Open err:%s\n", err)
 			continue
 		}
 		defer f.Close()
-		g, err := gpt.ReadGpt(f)
+
+		s, err := gpt.DetectScheme(f)
+		if err != nil {
+			fmt.Fprintf(cli.ErrStream, "DetectScheme err:%s\n", err)
+			continue
+		}
+		switch s {
+		case gpt.SchemeNone:
+			fmt.Fprintf(cli.ErrStream, "%s: %s\n", v, gpt.ErrNoPartitionTable)
+			continue
+		case gpt.SchemeApm:
+			a, err := gpt.ReadApm(f)
+			if err != nil {
+				fmt.Fprintf(cli.ErrStream, "ReadApm err:%s\n", err)
+				continue
+			}
+			tables = append(tables, Table{Scheme: s.String(), Table: *gpt.NewRApm(*a)})
+			continue
+		case gpt.SchemeMbr:
+			t, err := gpt.ReadMbrTable(f)
+			if err != nil {
+				fmt.Fprintf(cli.ErrStream, "ReadMbrTable err:%s\n", err)
+				continue
+			}
+			jt := gpt.NewRMbrTable(*t)
+			if cnf.probe {
+				jt.ProbeFilesystems(f)
+			}
+			tables = append(tables, Table{Scheme: s.String(), Table: *jt})
+			continue
+		case gpt.SchemeBsd:
+			d, err := gpt.ReadDisklabelWithSectorSize(f, 0, 0, f.SectorSize())
+			if err != nil {
+				fmt.Fprintf(cli.ErrStream, "ReadDisklabel err:%s\n", err)
+				continue
+			}
+			tables = append(tables, Table{Scheme: s.String(), Table: *gpt.NewRDisklabel(*d)})
+			continue
+		}
+
+		g, err := gpt.ReadGptWithSectorSize(f, f.SectorSize())
+		if err != nil && f.SectorSize() == gpt.DefaultSectorSize {
+			// raw image doesn't tell the sector size. try 4Kn.
+			if g4k, err4k := gpt.ReadGptWithSectorSize(f, 4096); err4k == nil {
+				g, err = g4k, nil
+			}
+		}
 		if err != nil {
 			fmt.Fprintf(cli.ErrStream, "ReadGpt err:%s\n", err)
 			continue
 		}
 		jg := gpt.NewRGpt(*g)
-		gpts = append(gpts, *jg)
+		if cnf.probe {
+			jg.ProbeFilesystems(f)
+		}
+		tables = append(tables, Table{Scheme: s.String(), Table: *jg})
 	}
 
 	enc := json.NewEncoder(cli.OutStream)
-	err = enc.Encode(gpts)
+	err = enc.Encode(tables)
 	if err != nil {
 		fmt.Fprintf(cli.ErrStream, "Encode err:%s\n", err)
 		return ExitCmdError
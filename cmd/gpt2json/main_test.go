@@ -18,10 +18,22 @@ package main
 
 import (
 	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
+const testdir = "../../pkg/gpt/testdata"
+const vdiskTestdir = "../../pkg/vdisk/testdata"
+
 func TestCliRun(t *testing.T) {
 	type testcase struct {
 		name   string
@@ -63,3 +75,317 @@ func TestShowVersion(t *testing.T) {
 		t.Errorf("not version string: %s", string(buf.Bytes()))
 	}
 }
+
+func TestCliRunDevices(t *testing.T) {
+	type testcase struct {
+		name   string
+		device string
+		expect string
+	}
+
+	cases := []testcase{
+		{"gpt", filepath.Join(testdir, "gpt_sample.bin"), "EFI System"},
+		{"apm", filepath.Join(testdir, "apm.bin"), "Apple_HFS"},
+		{"qcow2", filepath.Join(vdiskTestdir, "gpt_sample.qcow2"), "EFI System"},
+		{"qcow2 backing", filepath.Join(vdiskTestdir, "overlay.qcow2"), "EFI System"},
+		{"vhd", filepath.Join(vdiskTestdir, "gpt_sample_dynamic.vhd"), "EFI System"},
+		{"vmdk", filepath.Join(vdiskTestdir, "gpt_sample_stream.vmdk"), "EFI System"},
+		{"vdi", filepath.Join(vdiskTestdir, "gpt_sample.vdi"), "EFI System"},
+		{"android sparse", filepath.Join(vdiskTestdir, "gpt_sample.simg"), "EFI System"},
+		{"gzip", filepath.Join(vdiskTestdir, "gpt_sample.img.gz"), "EFI System"},
+		{"ewf", filepath.Join(vdiskTestdir, "gpt_sample.E01"), "EFI System"},
+	}
+
+	for _, v := range cases {
+		buf := bytes.NewBuffer([]byte{})
+		errbuf := bytes.NewBuffer([]byte{})
+
+		cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+		ret := cli.Run([]string{"program-name", v.device})
+		if ret != ExitOK {
+			t.Errorf("%s:ret is not ExitOK, ret=%d", v.name, ret)
+		}
+		if errbuf.Len() > 0 {
+			t.Errorf("%s:error: %s", v.name, errbuf.String())
+		}
+		if !strings.Contains(buf.String(), v.expect) {
+			t.Errorf("%s:%s is not found: %s", v.name, v.expect, buf.String())
+		}
+	}
+}
+
+func TestCliRunScheme(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: ioutil.Discard, quiet: true}
+	if ret := cli.Run([]string{"program-name", filepath.Join(testdir, "gpt_sample.bin"), filepath.Join(testdir, "apm.bin")}); ret != ExitOK {
+		t.Fatalf("ret is not ExitOK, ret=%d", ret)
+	}
+
+	var tables []struct {
+		Scheme string
+		Table  json.RawMessage
+	}
+	if err := json.Unmarshal(buf.Bytes(), &tables); err != nil {
+		t.Fatalf("Unmarshal err:%s", err)
+	}
+	expect := []string{"GPT", "APM"}
+	if len(tables) != len(expect) {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(tables), len(expect))
+	}
+	for i, v := range expect {
+		if tables[i].Scheme != v || len(tables[i].Table) == 0 {
+			t.Errorf("%d:scheme mismatch\n given :%s\n expect:%s", i, tables[i].Scheme, v)
+		}
+	}
+}
+
+func TestCliRunVhdx4Kn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpt2json")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the image is mostly zero and stored compressed.
+	f, err := os.Open(filepath.Join(vdiskTestdir, "gpt4k.vhdx.gz"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader err:%s", err)
+	}
+	b, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("ReadAll err:%s", err)
+	}
+	device := filepath.Join(dir, "gpt4k.vhdx")
+	if err := ioutil.WriteFile(device, b, 0644); err != nil {
+		t.Fatalf("WriteFile err:%s", err)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	errbuf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+	if ret := cli.Run([]string{"program-name", device}); ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("error: %s", errbuf.String())
+	}
+	if !strings.Contains(buf.String(), "root") {
+		t.Errorf("root is not found: %s", buf.String())
+	}
+}
+
+func TestCliRunSplit(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "gpt_sample.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	dir, err := ioutil.TempDir("", "gpt2json")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	for i, v := range []string{"disk.001", "disk.002", "disk.003"} {
+		n := len(b) / 3
+		if i == 2 {
+			n = len(b)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, v), b[:n], 0644); err != nil {
+			t.Fatalf("WriteFile err:%s", err)
+		}
+		b = b[n:]
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	errbuf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+	if ret := cli.Run([]string{"program-name", filepath.Join(dir, "disk.001")}); ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("error: %s", errbuf.String())
+	}
+
+	// the backup GPT is read from the last segment.
+	tables := []struct {
+		Table struct {
+			BackupHeader struct{ CurrentLBA uint64 }
+		}
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &tables); err != nil {
+		t.Fatalf("Unmarshal err:%s", err)
+	}
+	if len(tables) != 1 || tables[0].Table.BackupHeader.CurrentLBA != 255 {
+		t.Errorf("backup header mismatch: %s", buf.String())
+	}
+}
+
+func TestCliRunUrl(t *testing.T) {
+	srv := httptest.NewServer(http.FileServer(http.Dir(vdiskTestdir)))
+	defer srv.Close()
+
+	buf := bytes.NewBuffer([]byte{})
+	errbuf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+	if ret := cli.Run([]string{"program-name", srv.URL + "/gpt_sample.qcow2"}); ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("error: %s", errbuf.String())
+	}
+	if !strings.Contains(buf.String(), "EFI System") {
+		t.Errorf("EFI System is not found: %s", buf.String())
+	}
+}
+
+// writeImage writes b to the file name in dir and returns the path.
+func writeImage(t *testing.T, dir string, name string, b []byte) string {
+	t.Helper()
+	ret := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(ret, b, 0644); err != nil {
+		t.Fatalf("WriteFile err:%s", err)
+	}
+	return ret
+}
+
+func TestCliRunDisklabel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpt2json")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// bare disklabel at the second sector of the disk
+	parts := []gpt.DisklabelPartition{{Size: 2048, Offset: 16, FsType: 7}, {Size: 1024, Offset: 2064, FsType: 1}}
+	h := gpt.DisklabelHeader{Magic: gpt.DisklabelMagic, Magic2: gpt.DisklabelMagic, SecSize: 512, NPartitions: uint16(len(parts))}
+	label := bytes.NewBuffer([]byte{})
+	binary.Write(label, binary.LittleEndian, &h)
+	binary.Write(label, binary.LittleEndian, parts)
+	lb := label.Bytes()
+	sum := uint16(0)
+	for i := 0; i < len(lb); i += 2 {
+		sum ^= binary.LittleEndian.Uint16(lb[i:])
+	}
+	binary.LittleEndian.PutUint16(lb[binary.Size(h)-12:], sum)
+	img := make([]byte, 4096*512)
+	copy(img[512:], lb)
+
+	buf := bytes.NewBuffer([]byte{})
+	errbuf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+	if ret := cli.Run([]string{"program-name", writeImage(t, dir, "bsd.img", img)}); ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("error: %s", errbuf.String())
+	}
+
+	tables := []struct {
+		Scheme string
+		Table  gpt.RDisklabel
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &tables); err != nil {
+		t.Fatalf("Unmarshal err:%s", err)
+	}
+	if len(tables) != 1 || tables[0].Scheme != gpt.SchemeBsd.String() {
+		t.Fatalf("table mismatch: %s", buf.String())
+	}
+	if p := tables[0].Table.Entries["b"]; p.FsType != "swap" || p.FirstLBA != 2064 {
+		t.Errorf("partition mismatch: %+v", p)
+	}
+}
+
+func TestCliRunProbeMbr(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpt2json")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// MBR with a Linux partition which has ext2 at LBA 2048
+	img := make([]byte, 4096*512)
+	m := gpt.Mbr{Signature: 0xaa55}
+	m.Entries[0] = gpt.MbrEntry{Id: 0x83, FirstLBA: 2048, AllLBA: 2048}
+	mb := bytes.NewBuffer([]byte{})
+	if err := binary.Write(mb, binary.LittleEndian, &m); err != nil {
+		t.Fatalf("binary.Write err:%s", err)
+	}
+	copy(img, mb.Bytes())
+	sb := img[2048*512+1024:]
+	binary.LittleEndian.PutUint32(sb[4:], 1024)
+	binary.LittleEndian.PutUint16(sb[56:], 0xef53)
+	copy(sb[120:], "mbrfs")
+
+	buf := bytes.NewBuffer([]byte{})
+	errbuf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+	if ret := cli.Run([]string{"program-name", "-p", writeImage(t, dir, "mbr.img", img)}); ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("error: %s", errbuf.String())
+	}
+
+	tables := []struct {
+		Scheme string
+		Table  gpt.RMbrTable
+	}{}
+	if err := json.Unmarshal(buf.Bytes(), &tables); err != nil {
+		t.Fatalf("Unmarshal err:%s", err)
+	}
+	if len(tables) != 1 || tables[0].Scheme != gpt.SchemeMbr.String() {
+		t.Fatalf("table mismatch: %s", buf.String())
+	}
+	if fs := tables[0].Table.Mbr.Entries[0].Filesystem; fs == nil || fs.Label != "mbrfs" {
+		t.Errorf("filesystem mismatch: %+v", fs)
+	}
+}
+
+func TestCliRunRaw4Kn(t *testing.T) {
+	const sectorSize = 4096
+	const sectors = 4096
+
+	dir, err := ioutil.TempDir("", "gpt2json")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	g, err := gpt.NewGpt(sectors)
+	if err != nil {
+		t.Fatalf("NewGpt err:%s", err)
+	}
+	g.SectorSize = sectorSize
+	g.Entries[0] = gpt.Entry{TypeGuid: *gpt.LinuxFilesystemGuid, UniqueGuid: gpt.Guid{1}, FirstLBA: 256, LastLBA: 511}
+	g.Entries[0].WriteName("root4k")
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	device := writeImage(t, dir, "gpt4k.img", make([]byte, sectors*sectorSize))
+	f, err := os.OpenFile(device, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("OpenFile err:%s", err)
+	}
+	err = gpt.WriteGpt(f, g)
+	f.Close()
+	if err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	errbuf := bytes.NewBuffer([]byte{})
+	cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+	if ret := cli.Run([]string{"program-name", device}); ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+	if errbuf.Len() > 0 {
+		t.Errorf("error: %s", errbuf.String())
+	}
+	if !strings.Contains(buf.String(), "root4k") {
+		t.Errorf("root4k is not found: %s", buf.String())
+	}
+}
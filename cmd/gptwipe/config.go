@@ -0,0 +1,69 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+)
+
+// ConfigArgsMissing represents no Args error
+var ConfigNoArgs error = errors.New("No Args")
+
+// ConfigNegativeZeroMiB represents negative -m error
+var ConfigNegativeZeroMiB error = errors.New("-m must not be negative")
+
+const programName = "gptwipe"
+
+type Config struct {
+	showVersion bool
+	dryRun      bool
+	sectorSize  int64
+	zeroMiB     int64
+	devices     []string
+}
+
+// Pass os.Args[1:]
+// silent is to suppress help message for testing.
+func Configure(args []string, silent bool) (*Config, error) {
+	ret := &Config{}
+	if len(args) < 1 {
+		return nil, ConfigNoArgs
+	}
+
+	opt := flag.NewFlagSet(programName, flag.ContinueOnError)
+	opt.BoolVar(&ret.showVersion, "V", false, "show Version")
+	opt.BoolVar(&ret.dryRun, "n", false, "dry-run. show byte ranges to be zeroed")
+	opt.Int64Var(&ret.sectorSize, "s", 0, "sector size. 0 means auto")
+	opt.Int64Var(&ret.zeroMiB, "m", 0, "zero the first and last N MiB")
+
+	if silent {
+		opt.SetOutput(ioutil.Discard)
+	}
+
+	err := opt.Parse(args)
+	if err != nil {
+		return ret, err
+	}
+	if ret.zeroMiB < 0 {
+		return ret, ConfigNegativeZeroMiB
+	}
+	ret.devices = opt.Args()
+
+	return ret, nil
+}
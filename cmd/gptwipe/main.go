@@ -0,0 +1,121 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io"
+	"os"
+)
+
+const version string = "0.0.1"
+
+// Exit status
+const (
+	ExitOK int = iota
+	ExitArgError
+	ExitCmdError
+)
+
+// CLI has In/Out/Err streams.
+type CLI struct {
+	OutStream io.Writer
+	InStream  io.Reader
+	ErrStream io.Writer
+	quiet     bool // for testing to suppress output
+}
+
+// wipe zero-fills the partition table of the device v.
+func (cli *CLI) wipe(v string, cnf *Config) error {
+	mode := os.O_RDWR
+	if cnf.dryRun {
+		mode = os.O_RDONLY
+	}
+	f, err := os.OpenFile(v, mode, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	rs, err := gpt.WipeRanges(f, size, gpt.WipeOptions{SectorSize: cnf.sectorSize, ZeroMiB: cnf.zeroMiB})
+	if err != nil {
+		return err
+	}
+
+	if !cnf.dryRun {
+		if err := gpt.ZeroRanges(f, rs); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+	for _, r := range rs {
+		if cnf.dryRun {
+			fmt.Fprintf(cli.OutStream, "%s: %d bytes would be zeroed at offset 0x%08x\n", v, r.Length, r.Offset)
+		} else {
+			fmt.Fprintf(cli.OutStream, "%s: %d bytes were zeroed at offset 0x%08x\n", v, r.Length, r.Offset)
+		}
+	}
+	return nil
+}
+
+// Run executes real main function.
+func (cli *CLI) Run(args []string) (ret int) {
+	cnf, err := Configure(args[1:], cli.quiet)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return ExitOK
+		}
+		fmt.Fprintf(cli.ErrStream, "%s\n", err)
+		return ExitArgError
+	}
+
+	if cnf.showVersion {
+		fmt.Fprintf(cli.OutStream, "Ver: %s\n", version)
+		return ExitOK
+	}
+	if len(cnf.devices) == 0 {
+		fmt.Fprintf(cli.ErrStream, "no input\n")
+		return ExitArgError
+	}
+	if cnf.sectorSize != 0 && cnf.sectorSize != 512 && cnf.sectorSize != 4096 {
+		fmt.Fprintf(cli.ErrStream, "invalid sector size %d\n", cnf.sectorSize)
+		return ExitArgError
+	}
+
+	ret = ExitOK
+	for _, v := range cnf.devices {
+		if err := cli.wipe(v, cnf); err != nil {
+			fmt.Fprintf(cli.ErrStream, "%s: %s\n", v, err)
+			ret = ExitCmdError
+		}
+	}
+	return ret
+}
+
+func main() {
+	cli := &CLI{OutStream: os.Stdout, InStream: os.Stdin, ErrStream: os.Stderr}
+
+	os.Exit(cli.Run(os.Args))
+}
@@ -0,0 +1,115 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testdir = "../../pkg/gpt/testdata"
+
+func TestCliRun(t *testing.T) {
+	type testcase struct {
+		name   string
+		input  []string
+		expect int
+	}
+
+	cases := []testcase{
+		{"no args", []string{}, ExitArgError},
+		{"show Version", []string{"-V"}, ExitOK},
+		{"help", []string{"-h"}, ExitOK},
+		{"invalid sector size", []string{"-s", "1024", "disk.img"}, ExitArgError},
+		{"no such file", []string{filepath.Join(testdir, "no_such_file")}, ExitCmdError},
+	}
+
+	nullbuf := bytes.NewBuffer([]byte{})
+
+	for _, v := range cases {
+		args := []string{"program-name"}
+		args = append(args, v.input...)
+
+		cli := &CLI{OutStream: nullbuf, ErrStream: nullbuf, quiet: true}
+		ret := cli.Run(args)
+		if ret != v.expect {
+			t.Errorf("%s:given %d expect %d", v.name, ret, v.expect)
+		}
+	}
+}
+
+func TestShowVersion(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{})
+
+	cli := &CLI{OutStream: buf, quiet: true}
+
+	ret := cli.Run([]string{"showVer", "-V"})
+	if ret != ExitOK {
+		t.Errorf("ret is not ExitOK, ret=%d", ret)
+	}
+
+	if !strings.Contains(string(buf.Bytes()), "Ver:") {
+		t.Errorf("not version string: %s", string(buf.Bytes()))
+	}
+}
+
+func TestCliRunWipe(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "gpt_sample.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("WriteFile err:%s", err)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		args := []string{"program-name", path}
+		expect := "were zeroed"
+		if dryRun {
+			args = []string{"program-name", "-n", path}
+			expect = "would be zeroed"
+		}
+
+		buf := bytes.NewBuffer([]byte{})
+		errbuf := bytes.NewBuffer([]byte{})
+		cli := &CLI{OutStream: buf, ErrStream: errbuf, quiet: true}
+		if ret := cli.Run(args); ret != ExitOK {
+			t.Errorf("dry-run=%v:ret is not ExitOK, ret=%d %s", dryRun, ret, errbuf.String())
+		}
+		if !strings.Contains(buf.String(), expect) {
+			t.Errorf("dry-run=%v:%s is not found: %s", dryRun, expect, buf.String())
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Open err:%s", err)
+		}
+		_, err = gpt.ReadGpt(f)
+		f.Close()
+		if dryRun && err != nil {
+			t.Errorf("dry-run should not modify the disk. ReadGpt err:%s", err)
+		} else if !dryRun && err == nil {
+			t.Errorf("GPT should be wiped")
+		}
+	}
+}
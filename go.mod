@@ -1,3 +1,3 @@
 module github.com/nokute78/go-gpt
 
-go 1.15
+go 1.16
@@ -0,0 +1,642 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package fat implements FAT12/16/32 filesystem as io/fs.FS.
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ErrNotFat means that the boot sector is not a valid FAT BPB.
+var ErrNotFat = errors.New("not FAT")
+
+// Type represents the type of FAT.
+type Type int
+
+const (
+	FAT12 Type = 12
+	FAT16 Type = 16
+	FAT32 Type = 32
+)
+
+// String implements fmt.Stringer interface
+func (t Type) String() string {
+	return fmt.Sprintf("FAT%d", int(t))
+}
+
+// BootSector represents the boot sector and BIOS parameter block of FAT.
+// Fields from FatSize32 are valid only on FAT32.
+// ref: https://en.wikipedia.org/wiki/BIOS_parameter_block
+type BootSector struct {
+	Jump           [3]byte
+	OemName        [8]byte
+	BytesPerSector uint16
+	SecPerCluster  uint8
+	ReservedSec    uint16
+	NumFats        uint8
+	RootEntries    uint16
+	TotalSectors16 uint16
+	Media          uint8
+	FatSize16      uint16
+	SecPerTrack    uint16
+	NumHeads       uint16
+	HiddenSectors  uint32
+	TotalSectors32 uint32
+	FatSize32      uint32
+	ExtFlags       uint16
+	FsVersion      uint16
+	RootCluster    uint32
+	FsInfo         uint16
+	BackupBootSec  uint16
+	Reserved       [12]byte
+}
+
+// DirEntry represents the 32 bytes directory entry of FAT.
+type DirEntry struct {
+	Name         [11]byte
+	Attr         uint8
+	NTRes        uint8
+	CrtTimeTenth uint8
+	CrtTime      uint16
+	CrtDate      uint16
+	LstAccDate   uint16
+	FstClusHI    uint16
+	WrtTime      uint16
+	WrtDate      uint16
+	FstClusLO    uint16
+	FileSize     uint32
+}
+
+// Attributes of DirEntry.
+const (
+	AttrReadOnly  = 0x01
+	AttrHidden    = 0x02
+	AttrSystem    = 0x04
+	AttrVolumeId  = 0x08
+	AttrDirectory = 0x10
+	AttrArchive   = 0x20
+	AttrLongName  = 0x0f
+)
+
+// flags of NTRes
+const (
+	lowerBase = 0x08
+	lowerExt  = 0x10
+)
+
+// Cluster returns the first cluster of e.
+func (e DirEntry) Cluster() uint32 {
+	return uint32(e.FstClusHI)<<16 | uint32(e.FstClusLO)
+}
+
+// ShortName returns 8.3 name. e.g. "BOOTX64.EFI".
+func (e DirEntry) ShortName() string {
+	n := e.Name
+	if n[0] == 0x05 {
+		n[0] = 0xe5
+	}
+	base := strings.TrimRight(string(n[:8]), " ")
+	ext := strings.TrimRight(string(n[8:]), " ")
+	if e.NTRes&lowerBase != 0 {
+		base = strings.ToLower(base)
+	}
+	if e.NTRes&lowerExt != 0 {
+		ext = strings.ToLower(ext)
+	}
+	if ext == "" {
+		return base
+	}
+	return base + "." + ext
+}
+
+// ModTime returns the last modified time of e.
+func (e DirEntry) ModTime() time.Time {
+	d := e.WrtDate
+	t := e.WrtTime
+	return time.Date(1980+int(d>>9), time.Month((d>>5)&0xf), int(d&0x1f), int(t>>11), int((t>>5)&0x3f), int(t&0x1f)*2, 0, time.UTC)
+}
+
+// checksum returns the checksum of short name which is stored in long name entries.
+func checksum(name [11]byte) byte {
+	s := byte(0)
+	for _, b := range name {
+		s = (s>>1 | s<<7) + b
+	}
+	return s
+}
+
+// FS is a read-only FAT filesystem.
+type FS struct {
+	r            io.ReaderAt
+	bs           BootSector
+	typ          Type
+	fat          []byte
+	clusterSize  int64
+	dataOffset   int64
+	rootOffset   int64 // offset of root directory on FAT12/16.
+	rootSize     int64
+	clusterCount uint32
+	label        string
+}
+
+// New returns FS which reads FAT filesystem from r.
+// It returns ErrNotFat if r is not FAT.
+func New(r io.ReaderAt) (*FS, error) {
+	f := &FS{r: r}
+
+	b := make([]byte, 512)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("New:%w", err)
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &f.bs); err != nil {
+		return nil, fmt.Errorf("New:%w", err)
+	}
+
+	bs := f.bs
+	bps := int64(bs.BytesPerSector)
+	if bps < 512 || bps > 4096 || bps&(bps-1) != 0 || bs.SecPerCluster == 0 || bs.SecPerCluster&(bs.SecPerCluster-1) != 0 || bs.ReservedSec == 0 || bs.NumFats == 0 {
+		return nil, fmt.Errorf("New:%w", ErrNotFat)
+	}
+
+	fatSize := int64(bs.FatSize16)
+	if fatSize == 0 {
+		fatSize = int64(bs.FatSize32)
+	}
+	total := int64(bs.TotalSectors16)
+	if total == 0 {
+		total = int64(bs.TotalSectors32)
+	}
+	rootSectors := (int64(bs.RootEntries)*32 + bps - 1) / bps
+	fatOffset := int64(bs.ReservedSec) * bps
+	f.rootOffset = fatOffset + int64(bs.NumFats)*fatSize*bps
+	f.rootSize = rootSectors * bps
+	f.dataOffset = f.rootOffset + f.rootSize
+	f.clusterSize = int64(bs.SecPerCluster) * bps
+	if fatSize == 0 || int64(bs.NumFats)*fatSize > total || total*bps <= f.dataOffset {
+		return nil, fmt.Errorf("New:%w", ErrNotFat)
+	}
+
+	f.clusterCount = uint32((total*bps - f.dataOffset) / f.clusterSize)
+	switch {
+	case f.clusterCount < 4085:
+		f.typ = FAT12
+	case f.clusterCount < 65525:
+		f.typ = FAT16
+	default:
+		f.typ = FAT32
+	}
+
+	// only the entries of existing clusters are used.
+	fatLen := int64(f.clusterCount+2) * 4
+	switch f.typ {
+	case FAT12:
+		fatLen = (int64(f.clusterCount+2)*3 + 1) / 2
+	case FAT16:
+		fatLen = int64(f.clusterCount+2) * 2
+	}
+	if fatLen > fatSize*bps {
+		fatLen = fatSize * bps
+	}
+	tbl, err := readFat(r, fatOffset, fatLen)
+	if err != nil {
+		return nil, fmt.Errorf("New:%w", err)
+	}
+	f.fat = tbl
+
+	// volume label is in the boot sector and the root directory.
+	off := 43
+	if f.typ == FAT32 {
+		off = 71
+	}
+	if b[off-5] == 0x29 {
+		f.label = strings.TrimRight(string(b[off:off+11]), " ")
+	}
+	root, err := f.readRawDir(nil)
+	if err != nil {
+		return nil, fmt.Errorf("New:%w", err)
+	}
+	for _, e := range root {
+		if e.Attr&AttrLongName != AttrLongName && e.Attr&AttrVolumeId != 0 {
+			f.label = strings.TrimRight(string(e.Name[:]), " ")
+			break
+		}
+	}
+	if f.label == "NO NAME" {
+		f.label = ""
+	}
+
+	return f, nil
+}
+
+// readFat reads size bytes of FAT at off.
+// It reads by chunk so that a broken BPB can't make a huge buffer for a short input.
+func readFat(r io.ReaderAt, off int64, size int64) ([]byte, error) {
+	const chunk = 1024 * 1024
+	ret := []byte{}
+	for int64(len(ret)) < size {
+		n := size - int64(len(ret))
+		if n > chunk {
+			n = chunk
+		}
+		b := make([]byte, n)
+		if _, err := r.ReadAt(b, off+int64(len(ret))); err != nil {
+			return nil, err
+		}
+		ret = append(ret, b...)
+	}
+	return ret, nil
+}
+
+// Type returns the type of FAT.
+func (f *FS) Type() Type {
+	return f.typ
+}
+
+// Label returns the volume label.
+func (f *FS) Label() string {
+	return f.label
+}
+
+// BootSector returns the boot sector.
+func (f *FS) BootSector() BootSector {
+	return f.bs
+}
+
+// ClusterSize returns the size of cluster in bytes.
+func (f *FS) ClusterSize() int64 {
+	return f.clusterSize
+}
+
+// next returns the next cluster of c and whether c is the last cluster.
+func (f *FS) next(c uint32) (uint32, bool) {
+	var v uint32
+	switch f.typ {
+	case FAT12:
+		o := c * 3 / 2
+		if int(o)+1 >= len(f.fat) {
+			return 0, true
+		}
+		v = uint32(binary.LittleEndian.Uint16(f.fat[o:]))
+		if c&1 != 0 {
+			v >>= 4
+		}
+		v &= 0xfff
+		return v, v >= 0xff7 || v < 2
+	case FAT16:
+		o := c * 2
+		if int(o)+1 >= len(f.fat) {
+			return 0, true
+		}
+		v = uint32(binary.LittleEndian.Uint16(f.fat[o:]))
+		return v, v >= 0xfff7 || v < 2
+	}
+	o := c * 4
+	if int(o)+3 >= len(f.fat) {
+		return 0, true
+	}
+	v = binary.LittleEndian.Uint32(f.fat[o:]) & 0x0fffffff
+	return v, v >= 0x0ffffff7 || v < 2
+}
+
+// chain returns the cluster chain which starts from c.
+func (f *FS) chain(c uint32) ([]uint32, error) {
+	ret := []uint32{}
+	for c >= 2 {
+		if c >= f.clusterCount+2 || len(ret) > int(f.clusterCount) {
+			return nil, fmt.Errorf("broken cluster chain at %d", c)
+		}
+		ret = append(ret, c)
+		n, last := f.next(c)
+		if last {
+			break
+		}
+		c = n
+	}
+	return ret, nil
+}
+
+// clusterOffset returns the offset of cluster c.
+func (f *FS) clusterOffset(c uint32) int64 {
+	return f.dataOffset + int64(c-2)*f.clusterSize
+}
+
+// chainReader reads the data stored in the cluster chain.
+type chainReader struct {
+	f        *FS
+	clusters []uint32
+}
+
+// ReadAt implements io.ReaderAt interface.
+func (c *chainReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		i := (off + int64(n)) / c.f.clusterSize
+		if i >= int64(len(c.clusters)) {
+			return n, io.EOF
+		}
+		o := (off + int64(n)) % c.f.clusterSize
+		l := c.f.clusterSize - o
+		if l > int64(len(p)-n) {
+			l = int64(len(p) - n)
+		}
+		m, err := c.f.r.ReadAt(p[n:n+int(l)], c.f.clusterOffset(c.clusters[i])+o)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// readRawDir reads directory entries of dir. nil means the root directory.
+func (f *FS) readRawDir(dir *DirEntry) ([]DirEntry, error) {
+	var r io.Reader
+	if dir == nil && f.typ != FAT32 {
+		r = io.NewSectionReader(f.r, f.rootOffset, f.rootSize)
+	} else {
+		c := f.bs.RootCluster
+		if dir != nil {
+			c = dir.Cluster()
+		}
+		cl, err := f.chain(c)
+		if err != nil {
+			return nil, err
+		}
+		r = io.NewSectionReader(&chainReader{f: f, clusters: cl}, 0, int64(len(cl))*f.clusterSize)
+	}
+
+	ret := []DirEntry{}
+	for {
+		e := DirEntry{}
+		if err := binary.Read(r, binary.LittleEndian, &e); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return ret, nil
+			}
+			return nil, err
+		}
+		if e.Name[0] == 0 {
+			return ret, nil
+		}
+		ret = append(ret, e)
+	}
+}
+
+// file is a file or directory in FS.
+type file struct {
+	name  string
+	entry DirEntry
+	root  bool
+}
+
+// readDir returns files in dir. The long name is used if it exists.
+func (f *FS) readDir(dir *DirEntry) ([]file, error) {
+	raw, err := f.readRawDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []file{}
+	lfn := []uint16{}
+	sum := -1
+	for _, e := range raw {
+		if e.Name[0] == 0xe5 {
+			lfn = lfn[:0]
+			continue
+		}
+		if e.Attr&AttrLongName == AttrLongName {
+			b := make([]byte, 32)
+			buf := bytes.NewBuffer(b[:0])
+			binary.Write(buf, binary.LittleEndian, &e)
+			if b[0]&0x40 != 0 {
+				lfn = lfn[:0]
+				sum = int(b[13])
+			}
+			part := []uint16{}
+			for _, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				part = append(part, binary.LittleEndian.Uint16(b[o:]))
+			}
+			// entries are stored in reverse order
+			lfn = append(part, lfn...)
+			continue
+		}
+		if e.Attr&AttrVolumeId != 0 {
+			lfn = lfn[:0]
+			continue
+		}
+
+		name := e.ShortName()
+		if len(lfn) > 0 && sum == int(checksum(e.Name)) {
+			for i, c := range lfn {
+				if c == 0 {
+					lfn = lfn[:i]
+					break
+				}
+			}
+			name = string(utf16.Decode(lfn))
+		}
+		lfn = lfn[:0]
+		sum = -1
+
+		if name == "." || name == ".." {
+			continue
+		}
+		ret = append(ret, file{name: name, entry: e})
+	}
+	return ret, nil
+}
+
+// lookup returns the file of name.
+// The comparison of name is case-insensitive as FAT.
+func (f *FS) lookup(op string, name string) (*file, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	cur := &file{name: ".", root: true, entry: DirEntry{Attr: AttrDirectory}}
+	if name == "." {
+		return cur, nil
+	}
+
+	for _, elem := range strings.Split(name, "/") {
+		if cur.entry.Attr&AttrDirectory == 0 {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		var dir *DirEntry
+		if !cur.root {
+			dir = &cur.entry
+		}
+		files, err := f.readDir(dir)
+		if err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		var found *file
+		for i := range files {
+			if strings.EqualFold(files[i].name, elem) {
+				found = &files[i]
+				break
+			}
+		}
+		if found == nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		cur = found
+	}
+	return cur, nil
+}
+
+// Open implements fs.FS interface.
+func (f *FS) Open(name string) (fs.File, error) {
+	fl, err := f.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := fileInfo{f: *fl}
+
+	if fl.entry.Attr&AttrDirectory != 0 {
+		var dir *DirEntry
+		if !fl.root {
+			dir = &fl.entry
+		}
+		files, err := f.readDir(dir)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &openDir{info: info, files: files}, nil
+	}
+
+	cl, err := f.chain(fl.entry.Cluster())
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	size := int64(fl.entry.FileSize)
+	if max := int64(len(cl)) * f.clusterSize; size > max {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("file size %d exceeds cluster chain %d", size, max)}
+	}
+	return &openFile{SectionReader: io.NewSectionReader(&chainReader{f: f, clusters: cl}, 0, size), info: info}, nil
+}
+
+// ReadDir implements fs.ReadDirFS interface.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	d, ok := file.(*openDir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fmt.Errorf("not a directory")}
+	}
+	ret, err := d.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name() < ret[j].Name() })
+	return ret, nil
+}
+
+// Stat implements fs.StatFS interface.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	fl, err := f.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfo{f: *fl}, nil
+}
+
+// fileInfo implements fs.FileInfo and fs.DirEntry.
+// Sys() returns DirEntry.
+type fileInfo struct {
+	f file
+}
+
+func (i fileInfo) Name() string { return i.f.name }
+func (i fileInfo) Size() int64 {
+	if i.IsDir() {
+		return 0
+	}
+	return int64(i.f.entry.FileSize)
+}
+func (i fileInfo) Mode() fs.FileMode {
+	m := fs.FileMode(0444)
+	if i.f.entry.Attr&AttrReadOnly == 0 {
+		m |= 0200
+	}
+	if i.IsDir() {
+		m |= fs.ModeDir | 0111
+	}
+	return m
+}
+func (i fileInfo) ModTime() time.Time {
+	if i.f.root {
+		return time.Time{}
+	}
+	return i.f.entry.ModTime()
+}
+func (i fileInfo) IsDir() bool                { return i.f.entry.Attr&AttrDirectory != 0 }
+func (i fileInfo) Sys() interface{}           { return i.f.entry }
+func (i fileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i fileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// openFile is an opened regular file.
+// It also implements io.Seeker and io.ReaderAt.
+type openFile struct {
+	*io.SectionReader
+	info fileInfo
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *openFile) Close() error               { return nil }
+
+// openDir is an opened directory.
+type openDir struct {
+	info  fileInfo
+	files []file
+	off   int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile interface.
+func (d *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.files[d.off:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.off += len(rest)
+
+	ret := make([]fs.DirEntry, len(rest))
+	for i, v := range rest {
+		ret[i] = fileInfo{f: v}
+	}
+	return ret, nil
+}
@@ -0,0 +1,150 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fat_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/fat"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const testdir = "testdata"
+
+func newFat12(t *testing.T) *fat.FS {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "fat12.img"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	f, err := fat.New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("New err:%s", err)
+	}
+	return f
+}
+
+func TestNew(t *testing.T) {
+	f := newFat12(t)
+	if f.Type() != fat.FAT12 {
+		t.Errorf("type mismatch\n given :%s\n expect:%s", f.Type(), fat.FAT12)
+	}
+	if f.Label() != "TESTESP" {
+		t.Errorf("label mismatch\n given :%s\n expect:%s", f.Label(), "TESTESP")
+	}
+
+	if _, err := fat.New(bytes.NewReader(make([]byte, 4096))); err == nil {
+		t.Errorf("It should be error")
+	}
+}
+
+func TestNewBrokenBpb(t *testing.T) {
+	type testcase struct {
+		name string
+		bs   fat.BootSector
+	}
+
+	cases := []testcase{
+		{"FAT is larger than volume", fat.BootSector{BytesPerSector: 512, SecPerCluster: 1, ReservedSec: 1, NumFats: 2, TotalSectors32: 0x1000, FatSize32: 0x1000}},
+		{"huge volume", fat.BootSector{BytesPerSector: 4096, SecPerCluster: 1, ReservedSec: 1, NumFats: 1, TotalSectors32: 0xffffffff, FatSize32: 0x10000000}},
+	}
+
+	for _, v := range cases {
+		buf := bytes.NewBuffer([]byte{})
+		if err := binary.Write(buf, binary.LittleEndian, &v.bs); err != nil {
+			t.Fatalf("%s:binary.Write err:%s", v.name, err)
+		}
+		b := make([]byte, 64*1024)
+		copy(b, buf.Bytes())
+		if _, err := fat.New(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s:It should be error", v.name)
+		}
+	}
+}
+
+func TestFS(t *testing.T) {
+	f := newFat12(t)
+	err := fstest.TestFS(f, "EFI/BOOT/BOOTX64.EFI", "loader/loader entry with a long name.conf", "readme.txt", "EMPTY")
+	if err != nil {
+		t.Errorf("TestFS err:%s", err)
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	f := newFat12(t)
+
+	type testcase struct {
+		name   string
+		expect string
+	}
+	cases := []testcase{
+		{"readme.txt", "hello fat\n"},
+		{"README.TXT", "hello fat\n"},
+		{"loader/loader entry with a long name.conf", "title Test\nlinux /vmlinuz\n"},
+		{"efi/boot/bootx64.efi", "MZBOOTX64"},
+		{"EMPTY", ""},
+	}
+
+	for _, v := range cases {
+		b, err := fs.ReadFile(f, v.name)
+		if err != nil {
+			t.Errorf("%s:ReadFile err:%s", v.name, err)
+			continue
+		}
+		if !strings.HasPrefix(string(b), v.expect) || (v.expect == "" && len(b) != 0) {
+			t.Errorf("%s:data mismatch\n given :%q\n expect:%q", v.name, b, v.expect)
+		}
+	}
+
+	// fragmented file
+	b, err := fs.ReadFile(f, "EFI/BOOT/BOOTX64.EFI")
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	expect := ("MZ" + strings.Repeat("BOOTX64", 300))[:2000]
+	if string(b) != expect {
+		t.Errorf("data mismatch\n given :%d bytes\n expect:%d bytes", len(b), len(expect))
+	}
+
+	if _, err := fs.ReadFile(f, "EFI/BOOT/GRUBX64.EFI"); err == nil {
+		t.Errorf("It should be error")
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	f := newFat12(t)
+	es, err := fs.ReadDir(f, ".")
+	if err != nil {
+		t.Fatalf("ReadDir err:%s", err)
+	}
+	names := []string{}
+	for _, e := range es {
+		names = append(names, e.Name())
+	}
+	expect := "EFI,EMPTY,loader,readme.txt"
+	if strings.Join(names, ",") != expect {
+		t.Errorf("names mismatch\n given :%s\n expect:%s", strings.Join(names, ","), expect)
+	}
+	if !es[2].IsDir() || es[3].IsDir() {
+		t.Errorf("IsDir mismatch")
+	}
+}
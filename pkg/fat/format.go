@@ -0,0 +1,501 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ErrTooSmall is returned if the volume is too small for FAT32.
+var ErrTooSmall = errors.New("volume is too small for FAT32")
+
+// FormatOptions represents options of Format.
+type FormatOptions struct {
+	Label         string // volume label. Up to 11 characters.
+	VolumeId      uint32 // 0 means generated from the current time.
+	ClusterSize   int64  // in bytes. 0 means the default size from the volume size.
+	HiddenSectors uint32 // LBA of the partition.
+	SectorSize    int64  // logical sector size in bytes. 0 means 512.
+}
+
+const (
+	reservedSectors = 32
+	numFats         = 2
+	fsInfoSector    = 1
+	backupBootSec   = 6
+	eoc             = 0x0fffffff
+	minFat32Cluster = 65525
+)
+
+// defaultClusterSize returns the cluster size recommended by Microsoft.
+func defaultClusterSize(size int64) int64 {
+	switch {
+	case size <= 260*1024*1024:
+		return 512
+	case size <= 8*1024*1024*1024:
+		return 4096
+	case size <= 16*1024*1024*1024:
+		return 8192
+	case size <= 32*1024*1024*1024:
+		return 16384
+	}
+	return 32768
+}
+
+// node is a file or directory to be written.
+type node struct {
+	name     string
+	short    [11]byte
+	lfn      bool
+	dir      bool
+	size     int64
+	modTime  time.Time
+	path     string // path in the source fs.FS
+	children []*node
+	cluster  uint32
+	clusters uint32
+}
+
+// formatter has the state of Format.
+type formatter struct {
+	w           io.WriterAt
+	src         fs.FS
+	clusterSize int64
+	dataOffset  int64
+	fat         []uint32
+	next        uint32
+}
+
+// Format formats FAT32 on w whose size is size and copies files of src to it.
+// src can be nil to make an empty filesystem.
+// It returns ErrTooSmall if size is too small for FAT32.
+func Format(w io.WriterAt, size int64, opt FormatOptions, src fs.FS) error {
+	sectorSize := opt.SectorSize
+	if sectorSize == 0 {
+		sectorSize = 512
+	}
+	if sectorSize < 512 || sectorSize > 4096 || sectorSize&(sectorSize-1) != 0 {
+		return fmt.Errorf("Format:invalid sector size %d", sectorSize)
+	}
+	cs := opt.ClusterSize
+	if cs == 0 {
+		cs = defaultClusterSize(size)
+		if cs < sectorSize {
+			cs = sectorSize
+		}
+	}
+	if cs < sectorSize || cs > 32768 || cs&(cs-1) != 0 {
+		return fmt.Errorf("Format:invalid cluster size %d", cs)
+	}
+	spc := cs / sectorSize
+	total := size / sectorSize
+	if total > 0xffffffff {
+		return fmt.Errorf("Format:volume is too large. %d sectors", total)
+	}
+
+	// FAT size depends on the number of clusters and vice versa.
+	fatSize := int64(1)
+	clusters := int64(0)
+	for {
+		clusters = (total - reservedSectors - numFats*fatSize) / spc
+		n := ((clusters+2)*4 + sectorSize - 1) / sectorSize
+		if n <= fatSize {
+			break
+		}
+		fatSize = n
+	}
+	if clusters < minFat32Cluster {
+		return fmt.Errorf("Format:%w. %d clusters", ErrTooSmall, clusters)
+	}
+	if len(opt.Label) > 11 {
+		return fmt.Errorf("Format:label is too long. %q", opt.Label)
+	}
+
+	f := &formatter{w: w, src: src, clusterSize: cs, next: 2}
+	f.dataOffset = (reservedSectors + numFats*fatSize) * sectorSize
+	f.fat = make([]uint32, clusters+2)
+	f.fat[0] = 0x0ffffff8
+	f.fat[1] = eoc
+
+	root := &node{dir: true}
+	if src != nil {
+		if err := f.build(root, "."); err != nil {
+			return fmt.Errorf("Format:%w", err)
+		}
+	}
+	if err := f.allocate(root, opt.Label != ""); err != nil {
+		return fmt.Errorf("Format:%w", err)
+	}
+	if err := f.writeTree(root, root, opt.Label); err != nil {
+		return fmt.Errorf("Format:%w", err)
+	}
+
+	// FATs
+	fb := make([]byte, fatSize*sectorSize)
+	for i, v := range f.fat {
+		binary.LittleEndian.PutUint32(fb[i*4:], v)
+	}
+	for i := int64(0); i < numFats; i++ {
+		if _, err := w.WriteAt(fb, (reservedSectors+i*fatSize)*sectorSize); err != nil {
+			return fmt.Errorf("Format:%w", err)
+		}
+	}
+
+	// boot sector and FSInfo
+	id := opt.VolumeId
+	if id == 0 {
+		id = uint32(time.Now().UnixNano())
+	}
+	bs := BootSector{Jump: [3]byte{0xeb, 0x58, 0x90}, BytesPerSector: uint16(sectorSize), SecPerCluster: uint8(spc),
+		ReservedSec: reservedSectors, NumFats: numFats, Media: 0xf8, SecPerTrack: 63, NumHeads: 255,
+		HiddenSectors: opt.HiddenSectors, TotalSectors32: uint32(total), FatSize32: uint32(fatSize),
+		RootCluster: root.cluster, FsInfo: fsInfoSector, BackupBootSec: backupBootSec}
+	copy(bs.OemName[:], "go-gpt  ")
+	buf := bytes.NewBuffer([]byte{})
+	if err := binary.Write(buf, binary.LittleEndian, &bs); err != nil {
+		return fmt.Errorf("Format:%w", err)
+	}
+	b := make([]byte, reservedSectors*sectorSize)
+	copy(b, buf.Bytes())
+	b[64] = 0x80 // drive number
+	b[66] = 0x29 // extended boot signature
+	binary.LittleEndian.PutUint32(b[67:], id)
+	label := opt.Label
+	if label == "" {
+		label = "NO NAME"
+	}
+	copy(b[71:82], fmt.Sprintf("%-11s", strings.ToUpper(label)))
+	copy(b[82:90], "FAT32   ")
+	b[510] = 0x55
+	b[511] = 0xaa
+
+	fi := b[fsInfoSector*sectorSize:]
+	binary.LittleEndian.PutUint32(fi[0:], 0x41615252)
+	binary.LittleEndian.PutUint32(fi[484:], 0x61417272)
+	binary.LittleEndian.PutUint32(fi[488:], uint32(len(f.fat))-f.next)
+	binary.LittleEndian.PutUint32(fi[492:], f.next)
+	binary.LittleEndian.PutUint32(fi[508:], 0xaa550000)
+
+	copy(b[backupBootSec*sectorSize:], b[:2*sectorSize])
+	if _, err := w.WriteAt(b, 0); err != nil {
+		return fmt.Errorf("Format:%w", err)
+	}
+	return nil
+}
+
+// build reads the directory p of src and adds nodes to n.
+func (f *formatter) build(n *node, p string) error {
+	es, err := fs.ReadDir(f.src, p)
+	if err != nil {
+		return err
+	}
+	for _, e := range es {
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if !e.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		c := &node{name: e.Name(), dir: e.IsDir(), size: info.Size(), modTime: info.ModTime(), path: path.Join(p, e.Name())}
+		if !c.dir && c.size > 0xffffffff {
+			return fmt.Errorf("%s is too large", c.path)
+		}
+		if c.dir {
+			if err := f.build(c, c.path); err != nil {
+				return err
+			}
+		}
+		n.children = append(n.children, c)
+	}
+	return setShortNames(n.children)
+}
+
+// shortNameChars are characters which can be used in short name.
+const shortNameChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789$%'-_@~`!(){}^#&"
+
+// toShortName converts s to the characters which can be used in short name.
+func toShortName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(shortNameChars, r) {
+			return r
+		}
+		if r == ' ' || r == '.' {
+			return -1
+		}
+		return '_'
+	}, strings.ToUpper(s))
+}
+
+// setShortNames generates unique 8.3 names of nodes.
+// Long name entries are used if the name isn't a valid upper case 8.3 name.
+func setShortNames(nodes []*node) error {
+	used := map[string]bool{}
+	for _, n := range nodes {
+		base, ext := n.name, ""
+		if i := strings.LastIndex(n.name, "."); i > 0 {
+			base, ext = n.name[:i], n.name[i+1:]
+		}
+		sb, se := toShortName(base), toShortName(ext)
+		if len(se) > 3 {
+			se = se[:3]
+		}
+
+		if sb == base && se == ext && len(sb) > 0 && len(sb) <= 8 && !used[sb+"."+se] {
+			used[sb+"."+se] = true
+			copy(n.short[:], fmt.Sprintf("%-8s%-3s", sb, se))
+			continue
+		}
+
+		n.lfn = true
+		if sb == "" {
+			sb = "_"
+		}
+		found := false
+		for i := 1; i < 1000000 && !found; i++ {
+			tail := fmt.Sprintf("~%d", i)
+			b := sb
+			if len(b)+len(tail) > 8 {
+				b = b[:8-len(tail)]
+			}
+			b += tail
+			if !used[b+"."+se] {
+				used[b+"."+se] = true
+				copy(n.short[:], fmt.Sprintf("%-8s%-3s", b, se))
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("too many files like %s", n.name)
+		}
+	}
+	return nil
+}
+
+// lfnCount returns the number of long name entries of n.
+func (n *node) lfnCount() int {
+	if !n.lfn {
+		return 0
+	}
+	return (len(utf16.Encode([]rune(n.name))) + 12) / 13
+}
+
+// dirSize returns the size of directory entries of n in bytes.
+func (n *node) dirSize(root bool, label bool) int64 {
+	c := 0
+	if !root {
+		c += 2 // "." and ".."
+	} else if label {
+		c++
+	}
+	for _, v := range n.children {
+		c += v.lfnCount() + 1
+	}
+	return int64(c) * 32
+}
+
+// allocate assigns contiguous clusters to n and its children.
+func (f *formatter) allocate(n *node, label bool) error {
+	size := n.size
+	if n.dir {
+		size = n.dirSize(n.name == "" && n.path == "", label)
+		if size == 0 {
+			size = 1
+		}
+	}
+	if size > 0 {
+		c := uint32((size + f.clusterSize - 1) / f.clusterSize)
+		if int(f.next)+int(c) > len(f.fat) {
+			return fmt.Errorf("no space left for %s", n.path)
+		}
+		n.cluster = f.next
+		n.clusters = c
+		for i := uint32(0); i < c; i++ {
+			f.fat[f.next+i] = f.next + i + 1
+		}
+		f.fat[f.next+c-1] = eoc
+		f.next += c
+	}
+	for _, v := range n.children {
+		if err := f.allocate(v, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterOffset returns the offset of cluster c.
+func (f *formatter) clusterOffset(c uint32) int64 {
+	return f.dataOffset + int64(c-2)*f.clusterSize
+}
+
+// dosTime returns the date and time of FAT.
+func dosTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		return 1<<5 | 1, 0
+	}
+	if t.Year() > 2107 {
+		t = time.Date(2107, 12, 31, 23, 59, 58, 0, time.UTC)
+	}
+	d := uint16(t.Year()-1980)<<9 | uint16(t.Month())<<5 | uint16(t.Day())
+	tm := uint16(t.Hour())<<11 | uint16(t.Minute())<<5 | uint16(t.Second()/2)
+	return d, tm
+}
+
+// dirEntries returns long name entries and the short name entry of n.
+func (n *node) dirEntries() []DirEntry {
+	ret := []DirEntry{}
+	if n.lfn {
+		u := utf16.Encode([]rune(n.name))
+		cnt := n.lfnCount()
+		chars := make([]uint16, cnt*13)
+		for i := range chars {
+			switch {
+			case i < len(u):
+				chars[i] = u[i]
+			case i == len(u):
+				chars[i] = 0
+			default:
+				chars[i] = 0xffff
+			}
+		}
+		sum := checksum(n.short)
+		for i := cnt - 1; i >= 0; i-- {
+			b := make([]byte, 32)
+			b[0] = byte(i + 1)
+			if i == cnt-1 {
+				b[0] |= 0x40
+			}
+			b[11] = AttrLongName
+			b[13] = sum
+			for j, o := range []int{1, 3, 5, 7, 9, 14, 16, 18, 20, 22, 24, 28, 30} {
+				binary.LittleEndian.PutUint16(b[o:], chars[i*13+j])
+			}
+			e := DirEntry{}
+			binary.Read(bytes.NewReader(b), binary.LittleEndian, &e)
+			ret = append(ret, e)
+		}
+	}
+
+	e := DirEntry{Name: n.short, Attr: AttrArchive, FstClusHI: uint16(n.cluster >> 16), FstClusLO: uint16(n.cluster)}
+	if n.dir {
+		e.Attr = AttrDirectory
+	} else {
+		e.FileSize = uint32(n.size)
+	}
+	e.WrtDate, e.WrtTime = dosTime(n.modTime)
+	e.CrtDate, e.CrtTime = e.WrtDate, e.WrtTime
+	e.LstAccDate = e.WrtDate
+	return append(ret, e)
+}
+
+// writeTree writes directory entries and file data of n.
+func (f *formatter) writeTree(root *node, n *node, label string) error {
+	if !n.dir {
+		if n.size == 0 {
+			return nil
+		}
+		r, err := f.src.Open(n.path)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		w := &offsetWriter{w: f.w, off: f.clusterOffset(n.cluster)}
+		if _, err := io.CopyN(w, r, n.size); err != nil {
+			return fmt.Errorf("%s:%w", n.path, err)
+		}
+		return nil
+	}
+
+	entries := []DirEntry{}
+	if n == root {
+		if label != "" {
+			e := DirEntry{Attr: AttrVolumeId}
+			copy(e.Name[:], fmt.Sprintf("%-11s", strings.ToUpper(label)))
+			entries = append(entries, e)
+		}
+	} else {
+		dot := DirEntry{Attr: AttrDirectory, FstClusHI: uint16(n.cluster >> 16), FstClusLO: uint16(n.cluster)}
+		copy(dot.Name[:], ".          ")
+		dotdot := DirEntry{Attr: AttrDirectory}
+		copy(dotdot.Name[:], "..         ")
+		entries = append(entries, dot, dotdot)
+	}
+	for _, v := range n.children {
+		entries = append(entries, v.dirEntries()...)
+	}
+
+	// ".." of the sub directory of root points cluster 0.
+	for _, v := range n.children {
+		if v.dir {
+			if err := f.writeTree(root, v, label); err != nil {
+				return err
+			}
+			if n != root {
+				if err := f.setParent(v, n.cluster); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := f.writeTree(root, v, label); err != nil {
+			return err
+		}
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, int64(n.clusters)*f.clusterSize))
+	if err := binary.Write(buf, binary.LittleEndian, entries); err != nil {
+		return err
+	}
+	b := make([]byte, int64(n.clusters)*f.clusterSize)
+	copy(b, buf.Bytes())
+	_, err := f.w.WriteAt(b, f.clusterOffset(n.cluster))
+	return err
+}
+
+// setParent writes the cluster of ".." of directory n.
+func (f *formatter) setParent(n *node, parent uint32) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint16(b[0:], uint16(parent>>16))
+	if _, err := f.w.WriteAt(b[:2], f.clusterOffset(n.cluster)+32+20); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint16(b[2:], uint16(parent))
+	_, err := f.w.WriteAt(b[2:], f.clusterOffset(n.cluster)+32+26)
+	return err
+}
+
+// offsetWriter is an io.Writer which writes to io.WriterAt sequentially.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(b []byte) (int, error) {
+	n, err := o.w.WriteAt(b, o.off)
+	o.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package fat_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/fat"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type memImage []byte
+
+func (m memImage) WriteAt(p []byte, off int64) (int, error) {
+	return copy(m[off:], p), nil
+}
+
+const testFat32Size = 40 * 1024 * 1024
+
+func TestFormat(t *testing.T) {
+	mtime := time.Date(2021, 4, 1, 12, 34, 56, 0, time.UTC)
+	loader := []byte(strings.Repeat("MZ BOOTX64 ", 200))
+	src := fstest.MapFS{
+		"EFI/BOOT/BOOTX64.EFI":             {Data: loader, ModTime: mtime},
+		"loader/loader.conf":               {Data: []byte("default arch\n"), ModTime: mtime},
+		"loader/entries/arch-linux.conf":   {Data: []byte("title Arch Linux\n"), ModTime: mtime},
+		"loader/entries/arch-linux-lts.co": {Data: []byte("title Arch Linux LTS\n"), ModTime: mtime},
+		"vmlinuz-linux":                    {Data: bytes.Repeat([]byte{0xaa}, 5000), ModTime: mtime},
+		"EMPTY":                            {Data: []byte{}, ModTime: mtime},
+		"Long File Name With Spaces.txt":   {Data: []byte("hello\n"), ModTime: mtime},
+	}
+
+	img := make(memImage, testFat32Size)
+	if err := fat.Format(img, int64(len(img)), fat.FormatOptions{Label: "ESP", VolumeId: 0x1234}, src); err != nil {
+		t.Fatalf("Format err:%s", err)
+	}
+
+	f, err := fat.New(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("New err:%s", err)
+	}
+	if f.Type() != fat.FAT32 {
+		t.Errorf("type mismatch\n given :%s\n expect:%s", f.Type(), fat.FAT32)
+	}
+	if f.Label() != "ESP" {
+		t.Errorf("label mismatch\n given :%s\n expect:%s", f.Label(), "ESP")
+	}
+
+	if err := fstest.TestFS(f, "EFI/BOOT/BOOTX64.EFI", "loader/entries/arch-linux.conf", "Long File Name With Spaces.txt"); err != nil {
+		t.Errorf("TestFS err:%s", err)
+	}
+
+	for name, v := range src {
+		b, err := fs.ReadFile(f, name)
+		if err != nil {
+			t.Errorf("%s: ReadFile err:%s", name, err)
+			continue
+		}
+		if !bytes.Equal(b, v.Data) {
+			t.Errorf("%s: data mismatch", name)
+		}
+		info, err := fs.Stat(f, name)
+		if err != nil {
+			t.Errorf("%s: Stat err:%s", name, err)
+			continue
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s: mtime mismatch\n given :%s\n expect:%s", name, info.ModTime(), mtime)
+		}
+	}
+}
+
+func TestFormatEmpty(t *testing.T) {
+	img := make(memImage, testFat32Size)
+	if err := fat.Format(img, int64(len(img)), fat.FormatOptions{}, nil); err != nil {
+		t.Fatalf("Format err:%s", err)
+	}
+	f, err := fat.New(bytes.NewReader(img))
+	if err != nil {
+		t.Fatalf("New err:%s", err)
+	}
+	es, err := fs.ReadDir(f, ".")
+	if err != nil {
+		t.Fatalf("ReadDir err:%s", err)
+	}
+	if len(es) != 0 {
+		t.Errorf("root should be empty. %d entries", len(es))
+	}
+}
+
+func TestFormat4Kn(t *testing.T) {
+	// FAT32 of 4KiB sectors needs 256MiB at least. Use sparse file.
+	const size = 300 * 1024 * 1024
+	img, err := ioutil.TempFile("", "fat")
+	if err != nil {
+		t.Fatalf("TempFile err:%s", err)
+	}
+	defer os.Remove(img.Name())
+	defer img.Close()
+	if err := img.Truncate(size); err != nil {
+		t.Fatalf("Truncate err:%s", err)
+	}
+
+	src := fstest.MapFS{"EFI/BOOT/BOOTX64.EFI": {Data: []byte("MZ loader")}}
+	if err := fat.Format(img, size, fat.FormatOptions{SectorSize: 4096}, src); err != nil {
+		t.Fatalf("Format err:%s", err)
+	}
+	b := make([]byte, 2)
+	if _, err := img.ReadAt(b, 11); err != nil {
+		t.Fatalf("ReadAt err:%s", err)
+	}
+	if bps := binary.LittleEndian.Uint16(b); bps != 4096 {
+		t.Errorf("bytes per sector mismatch\n given :%d\n expect:%d", bps, 4096)
+	}
+
+	f, err := fat.New(img)
+	if err != nil {
+		t.Fatalf("New err:%s", err)
+	}
+	if d, err := fs.ReadFile(f, "EFI/BOOT/BOOTX64.EFI"); err != nil || string(d) != "MZ loader" {
+		t.Errorf("ReadFile mismatch. %q err:%v", d, err)
+	}
+
+	if err := fat.Format(img, size, fat.FormatOptions{SectorSize: 1000}, nil); err == nil {
+		t.Errorf("invalid sector size:it should be error")
+	}
+}
+
+func TestFormatTooSmall(t *testing.T) {
+	img := make(memImage, 8*1024*1024)
+	err := fat.Format(img, int64(len(img)), fat.FormatOptions{}, nil)
+	if !errors.Is(err, fat.ErrTooSmall) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, fat.ErrTooSmall)
+	}
+}
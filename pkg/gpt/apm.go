@@ -0,0 +1,192 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Signatures of Apple Partition Map. "ER" and "PM".
+const (
+	DdmSignature      = 0x4552
+	ApmEntrySignature = 0x504d
+)
+
+// maxApmEntries limits the number of APM entries to avoid reading too many blocks.
+const maxApmEntries = 1024
+
+// ApmDriver represents the driver descriptor in Ddm.
+type ApmDriver struct {
+	Block uint32
+	Size  uint16
+	Type  uint16
+}
+
+// Ddm represents the driver descriptor map at block 0 of Apple Partition Map.
+// ref: https://en.wikipedia.org/wiki/Apple_Partition_Map
+type Ddm struct {
+	Signature   uint16
+	BlockSize   uint16
+	BlockCount  uint32
+	DevType     uint16
+	DevId       uint16
+	Data        uint32
+	DriverCount uint16
+	Drivers     [8]ApmDriver
+	Reserved    [430]byte
+}
+
+// IsValid reports whether d has "ER" signature.
+func (d Ddm) IsValid() bool {
+	return d.Signature == DdmSignature
+}
+
+// ApmEntry represents the partition entry of Apple Partition Map.
+type ApmEntry struct {
+	Signature     uint16
+	SignaturePad  uint16
+	MapBlockCount uint32
+	PhysStart     uint32
+	BlockCount    uint32
+	Name          [32]byte
+	Type          [32]byte
+	LogDataStart  uint32
+	DataCount     uint32
+	Status        uint32
+	LogBootStart  uint32
+	BootSize      uint32
+	BootAddr      uint32
+	BootAddr2     uint32
+	BootEntry     uint32
+	BootEntry2    uint32
+	BootChecksum  uint32
+	Processor     [16]byte
+	Reserved      [376]byte
+}
+
+// IsValid reports whether e has "PM" signature.
+func (e ApmEntry) IsValid() bool {
+	return e.Signature == ApmEntrySignature
+}
+
+// cString returns the string before null char.
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// ReadName returns partition name string.
+func (e ApmEntry) ReadName() string {
+	return cString(e.Name[:])
+}
+
+// ReadType returns partition type string. e.g. "Apple_HFS".
+func (e ApmEntry) ReadType() string {
+	return cString(e.Type[:])
+}
+
+// Apm represents Apple Partition Map.
+type Apm struct {
+	Ddm       Ddm
+	BlockSize uint32 // size of block which PhysStart and BlockCount use.
+	Entries   []ApmEntry
+}
+
+// readApmEntry reads ApmEntry at off.
+func readApmEntry(rs io.ReadSeeker, off int64) (*ApmEntry, error) {
+	rs.Seek(off, io.SeekStart)
+	e := &ApmEntry{}
+	err := binary.Read(rs, binary.BigEndian, e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// ReadApm reads Apple Partition Map from rs.
+// Entries are placed at the interval of block size in Ddm.
+// It also tries 512 byte interval for hybrid images.
+func ReadApm(rs io.ReadSeeker) (*Apm, error) {
+	a := &Apm{}
+
+	rs.Seek(0, io.SeekStart)
+	err := binary.Read(rs, binary.BigEndian, &a.Ddm)
+	if err != nil {
+		return nil, fmt.Errorf("ReadApm:%w", err)
+	}
+	if !a.Ddm.IsValid() {
+		return nil, fmt.Errorf("Not APM")
+	}
+
+	a.BlockSize = uint32(a.Ddm.BlockSize)
+	if a.BlockSize == 0 {
+		a.BlockSize = 512
+	}
+	e, err := readApmEntry(rs, int64(a.BlockSize))
+	if (err != nil || !e.IsValid()) && a.BlockSize != 512 {
+		a.BlockSize = 512
+		e, err = readApmEntry(rs, int64(a.BlockSize))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ReadApm:%w", err)
+	}
+	if !e.IsValid() {
+		return nil, fmt.Errorf("Not APM")
+	}
+
+	n := e.MapBlockCount
+	if n > maxApmEntries {
+		return nil, fmt.Errorf("ReadApm:too many entries. %d", n)
+	}
+	a.Entries = append(a.Entries, *e)
+	for i := uint32(2); i <= n; i++ {
+		e, err := readApmEntry(rs, int64(a.BlockSize)*int64(i))
+		if err != nil {
+			return nil, fmt.Errorf("ReadApm:%w", err)
+		}
+		if !e.IsValid() {
+			return nil, fmt.Errorf("ReadApm:invalid entry at block %d", i)
+		}
+		a.Entries = append(a.Entries, *e)
+	}
+
+	return a, nil
+}
+
+// Scheme returns SchemeApm.
+func (a Apm) Scheme() Scheme {
+	return SchemeApm
+}
+
+// Partitions returns entries as Partition.
+// Details is ApmEntry.
+func (a Apm) Partitions() []Partition {
+	bs := uint64(a.BlockSize)
+
+	ret := []Partition{}
+	for i, e := range a.Entries {
+		p := Partition{Index: i + 1, Start: uint64(e.PhysStart) * bs, Size: uint64(e.BlockCount) * bs,
+			Type: e.ReadType(), Name: e.ReadName(), Details: e}
+		ret = append(ret, p)
+	}
+	return ret
+}
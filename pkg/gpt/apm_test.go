@@ -0,0 +1,93 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readApm(t *testing.T) (*gpt.Apm, error) {
+	t.Helper()
+	f, err := os.Open(filepath.Join(testdir, "apm.bin"))
+	if err != nil {
+		t.Fatalf("os.Open err:%s", err)
+	}
+	defer f.Close()
+	return gpt.ReadApm(f)
+}
+
+func TestReadApm(t *testing.T) {
+	a, err := readApm(t)
+	if err != nil {
+		t.Fatalf("ReadApm err:%s", err)
+	}
+	if a.BlockSize != 512 {
+		t.Errorf("BlockSize mismatch\n given :%d\n expect:%d", a.BlockSize, 512)
+	}
+	if len(a.Entries) != 3 {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(a.Entries), 3)
+	}
+
+	e := a.Entries[1]
+	if e.ReadName() != "Macintosh HD" {
+		t.Errorf("Name mismatch\n given :%s\n expect:%s", e.ReadName(), "Macintosh HD")
+	}
+	if e.ReadType() != "Apple_HFS" {
+		t.Errorf("Type mismatch\n given :%s\n expect:%s", e.ReadType(), "Apple_HFS")
+	}
+	if e.PhysStart != 64 || e.BlockCount != 48 {
+		t.Errorf("block mismatch\n given :%d+%d\n expect:%d+%d", e.PhysStart, e.BlockCount, 64, 48)
+	}
+}
+
+func TestReadApmInvalid(t *testing.T) {
+	f, err := os.Open(filepath.Join(testdir, "gpt_sample.bin"))
+	if err != nil {
+		t.Fatalf("os.Open err:%s", err)
+	}
+	defer f.Close()
+	_, err = gpt.ReadApm(f)
+	if err == nil {
+		t.Errorf("It should be error")
+	}
+}
+
+func TestApmPartitions(t *testing.T) {
+	f, err := os.Open(filepath.Join(testdir, "apm.bin"))
+	if err != nil {
+		t.Fatalf("os.Open err:%s", err)
+	}
+	defer f.Close()
+
+	pt, err := gpt.ReadPartitionTable(f)
+	if err != nil {
+		t.Fatalf("ReadPartitionTable err:%s", err)
+	}
+	if pt.Scheme() != gpt.SchemeApm {
+		t.Errorf("scheme mismatch\n given :%s\n expect:%s", pt.Scheme(), gpt.SchemeApm)
+	}
+	ps := pt.Partitions()
+	if len(ps) != 3 {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(ps), 3)
+	}
+	if ps[1].Start != 64*512 || ps[1].Size != 48*512 || ps[1].Name != "Macintosh HD" {
+		t.Errorf("partition mismatch: %+v", ps[1])
+	}
+}
@@ -0,0 +1,259 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// DisklabelMagic is the magic number of BSD disklabel.
+const DisklabelMagic = 0x82564557
+
+// MaxDisklabelPartitions is the number of sub-partitions. 'a' to 'p'.
+const MaxDisklabelPartitions = 16
+
+// rawPartition is the index of 'c' which covers the whole slice.
+const rawPartition = 2
+
+// DisklabelHeader represents the header of BSD disklabel.
+// ref: https://github.com/freebsd/freebsd-src/blob/main/sys/sys/disklabel.h
+type DisklabelHeader struct {
+	Magic          uint32
+	Type           uint16
+	SubType        uint16
+	TypeName       [16]byte
+	PackName       [16]byte
+	SecSize        uint32
+	NSectors       uint32
+	NTracks        uint32
+	NCylinders     uint32
+	SecPerCyl      uint32
+	SecPerUnit     uint32
+	SparesPerTrack uint16
+	SparesPerCyl   uint16
+	ACylinders     uint32
+	Rpm            uint16
+	Interleave     uint16
+	TrackSkew      uint16
+	CylSkew        uint16
+	HeadSwitch     uint32
+	TrkSeek        uint32
+	Flags          uint32
+	DriveData      [5]uint32
+	Spare          [5]uint32
+	Magic2         uint32
+	Checksum       uint16
+	NPartitions    uint16
+	BbSize         uint32
+	SbSize         uint32
+}
+
+// DisklabelPartition represents the sub-partition of BSD disklabel.
+type DisklabelPartition struct {
+	Size     uint32 // number of sectors
+	Offset   uint32 // starting sector
+	FragSize uint32
+	FsType   byte
+	Frag     byte
+	Cpg      uint16
+}
+
+// IsBlank reports whether p is not used.
+func (p DisklabelPartition) IsBlank() bool {
+	return p.Size == 0
+}
+
+// disklabelFsTypeNames maps fstype to the names.
+var disklabelFsTypeNames = []string{
+	"unused", "swap", "Version 6", "Version 7", "System V", "4.1BSD", "Eighth Edition", "4.2BSD",
+	"MSDOS", "4.4LFS", "unknown", "HPFS", "ISO9660", "boot", "vinum", "raid",
+	"Filecore", "EXT2FS", "NTFS", "?", "ccd", "jfs", "HAMMER", "HAMMER2",
+	"UDF", "?", "EFS", "ZFS",
+}
+
+// FsTypeString returns the name of fstype. e.g. "4.2BSD".
+func (p DisklabelPartition) FsTypeString() string {
+	if int(p.FsType) < len(disklabelFsTypeNames) {
+		return disklabelFsTypeNames[p.FsType]
+	}
+	return "Unknown"
+}
+
+// Disklabel represents BSD disklabel.
+type Disklabel struct {
+	Header     DisklabelHeader
+	Entries    []DisklabelPartition
+	SliceLBA   uint64 // LBA of the slice which contains the disklabel.
+	SliceSize  uint64 // number of sectors of the slice. 0 means unknown.
+	SectorSize int64  // logical sector size in bytes. 0 means 512.
+}
+
+// sectorSize returns the logical sector size of d.
+func (d Disklabel) sectorSize() int64 {
+	if d.SectorSize == 0 {
+		return DefaultSectorSize
+	}
+	return d.SectorSize
+}
+
+// ReadDisklabel reads BSD disklabel from the slice which starts at lba.
+// The disklabel is placed at the second sector of the slice.
+// sectors is the size of the slice. 0 means unknown.
+// The logical sector size of rs is 512.
+func ReadDisklabel(rs io.ReadSeeker, lba uint64, sectors uint64) (*Disklabel, error) {
+	return ReadDisklabelWithSectorSize(rs, lba, sectors, DefaultSectorSize)
+}
+
+// ReadDisklabelWithSectorSize reads BSD disklabel from the slice which starts at lba.
+// sectorSize is the logical sector size of rs.
+func ReadDisklabelWithSectorSize(rs io.ReadSeeker, lba uint64, sectors uint64, sectorSize int64) (*Disklabel, error) {
+	b, err := readSectorWithSize(rs, int64(lba)+1, sectorSize)
+	if err != nil {
+		return nil, fmt.Errorf("ReadDisklabel:%w", err)
+	}
+
+	var order binary.ByteOrder = binary.LittleEndian
+	if binary.LittleEndian.Uint32(b) != DisklabelMagic {
+		if binary.BigEndian.Uint32(b) != DisklabelMagic {
+			return nil, fmt.Errorf("Not disklabel")
+		}
+		order = binary.BigEndian
+	}
+
+	d := &Disklabel{SliceLBA: lba, SliceSize: sectors, SectorSize: sectorSize}
+	r := bytes.NewReader(b)
+	if err := binary.Read(r, order, &d.Header); err != nil {
+		return nil, fmt.Errorf("ReadDisklabel:%w", err)
+	}
+	if d.Header.Magic2 != DisklabelMagic {
+		return nil, fmt.Errorf("ReadDisklabel:invalid magic2 0x%x", d.Header.Magic2)
+	}
+
+	hsize := binary.Size(d.Header)
+	psize := binary.Size(DisklabelPartition{})
+	n := int(d.Header.NPartitions)
+	if hsize+n*psize > int(sectorSize) {
+		return nil, fmt.Errorf("ReadDisklabel:too many partitions. %d", n)
+	}
+
+	// checksum is xor of 16 bit words of header and partitions
+	sum := uint16(0)
+	for i := 0; i < hsize+n*psize; i += 2 {
+		sum ^= order.Uint16(b[i:])
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("ReadDisklabel:checksum mismatch")
+	}
+
+	if n > MaxDisklabelPartitions {
+		n = MaxDisklabelPartitions
+	}
+	d.Entries = make([]DisklabelPartition, n)
+	if err := binary.Read(r, order, d.Entries); err != nil {
+		return nil, fmt.Errorf("ReadDisklabel:%w", err)
+	}
+
+	return d, nil
+}
+
+// ReadDisklabel reads BSD disklabel in the slice of m.
+// It returns error if m is not FreeBSD, OpenBSD or NetBSD slice.
+func (m MbrEntry) ReadDisklabel(rs io.ReadSeeker) (*Disklabel, error) {
+	switch m.Id {
+	case 0xa5, 0xa6, 0xa9:
+		return ReadDisklabel(rs, uint64(m.FirstLBA), uint64(m.AllLBA))
+	}
+	return nil, fmt.Errorf("ReadDisklabel:not BSD slice. id=0x%02x", m.Id)
+}
+
+// ReadDisklabel reads BSD disklabel in the partition of e.
+// It returns error if e is not FreeBSD or OpenBSD partition.
+// The logical sector size of rs is 512.
+func (e Entry) ReadDisklabel(rs io.ReadSeeker) (*Disklabel, error) {
+	return e.ReadDisklabelWithSectorSize(rs, DefaultSectorSize)
+}
+
+// ReadDisklabelWithSectorSize reads BSD disklabel in the partition of e.
+// sectorSize is the logical sector size of rs.
+func (e Entry) ReadDisklabelWithSectorSize(rs io.ReadSeeker, sectorSize int64) (*Disklabel, error) {
+	for _, g := range []*Guid{FreeBSDDataGuid, FreeBSDBootGuid, OpenBSDDataGuid} {
+		if e.TypeGuid.Equal(*g) {
+			return ReadDisklabelWithSectorSize(rs, e.FirstLBA, e.LastLBA-e.FirstLBA+1, sectorSize)
+		}
+	}
+	return nil, fmt.Errorf("ReadDisklabel:not BSD partition. type=%s", e.TypeGuid)
+}
+
+// isAbsolute reports whether offsets of partitions are relative to the beginning of the disk.
+// Some BSDs use absolute offsets and others use offsets relative to the slice.
+func (d Disklabel) isAbsolute() bool {
+	if d.SliceLBA == 0 {
+		return true
+	}
+	if len(d.Entries) > rawPartition && uint64(d.Entries[rawPartition].Offset) == d.SliceLBA {
+		return true
+	}
+	for i, p := range d.Entries {
+		if i == rawPartition || p.IsBlank() {
+			continue
+		}
+		if uint64(p.Offset) < d.SliceLBA {
+			return false
+		}
+		if d.SliceSize > 0 && uint64(p.Offset)+uint64(p.Size) > d.SliceLBA+d.SliceSize {
+			return false
+		}
+	}
+	return true
+}
+
+// PartitionLBA returns the first LBA of sub-partition i relative to the beginning of the disk.
+func (d Disklabel) PartitionLBA(i int) uint64 {
+	p := d.Entries[i]
+	if d.isAbsolute() {
+		return uint64(p.Offset)
+	}
+	raw := uint64(0)
+	if len(d.Entries) > rawPartition {
+		raw = uint64(d.Entries[rawPartition].Offset)
+	}
+	return d.SliceLBA + uint64(p.Offset) - raw
+}
+
+// Scheme returns SchemeBsd.
+func (d Disklabel) Scheme() Scheme {
+	return SchemeBsd
+}
+
+// Partitions returns sub-partitions as Partition.
+// Name is the letter of sub-partition. e.g. "a". Details is DisklabelPartition.
+func (d Disklabel) Partitions() []Partition {
+	sectorSize := uint64(d.sectorSize())
+
+	ret := []Partition{}
+	for i, p := range d.Entries {
+		if p.IsBlank() {
+			continue
+		}
+		ret = append(ret, Partition{Index: i + 1, Start: d.PartitionLBA(i) * sectorSize, Size: uint64(p.Size) * sectorSize,
+			Type: p.FsTypeString(), Name: string(rune('a' + i)), Details: p})
+	}
+	return ret
+}
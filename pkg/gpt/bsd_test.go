@@ -0,0 +1,199 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"testing"
+)
+
+// putDisklabel writes disklabel to the second sector of the slice at lba.
+func (d memDisk) putDisklabel(t *testing.T, lba int64, parts []gpt.DisklabelPartition) {
+	t.Helper()
+	h := gpt.DisklabelHeader{Magic: gpt.DisklabelMagic, Magic2: gpt.DisklabelMagic, SecSize: 512, NPartitions: uint16(len(parts))}
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := binary.Write(buf, binary.LittleEndian, &h); err != nil {
+		t.Fatalf("binary.Write err:%s", err)
+	}
+	if err := binary.Write(buf, binary.LittleEndian, parts); err != nil {
+		t.Fatalf("binary.Write err:%s", err)
+	}
+	b := buf.Bytes()
+	sum := uint16(0)
+	for i := 0; i < len(b); i += 2 {
+		sum ^= binary.LittleEndian.Uint16(b[i:])
+	}
+	binary.LittleEndian.PutUint16(b[binary.Size(h)-12:], sum)
+	copy(d[(lba+1)*512:], b)
+}
+
+func newBsdDisk(t *testing.T, parts []gpt.DisklabelPartition) memDisk {
+	t.Helper()
+	d := make(memDisk, testDiskSectors*512)
+	m := &gpt.Mbr{Signature: 0xaa55}
+	m.Entries[0] = gpt.MbrEntry{Id: 0xa5, FirstLBA: 2048, AllLBA: 4096}
+	d.putMbr(t, 0, m)
+	d.putDisklabel(t, 2048, parts)
+	return d
+}
+
+func TestReadDisklabel(t *testing.T) {
+	type testcase struct {
+		name   string
+		parts  []gpt.DisklabelPartition
+		expect uint64 // LBA of 'a'
+	}
+
+	cases := []testcase{
+		{"relative", []gpt.DisklabelPartition{
+			{Size: 2048, Offset: 16, FsType: 7},
+			{Size: 1024, Offset: 2064, FsType: 1},
+			{Size: 4096, Offset: 0},
+		}, 2048 + 16},
+		{"absolute", []gpt.DisklabelPartition{
+			{Size: 2048, Offset: 2048 + 16, FsType: 7},
+			{Size: 1024, Offset: 2048 + 2064, FsType: 1},
+			{Size: 4096, Offset: 2048},
+		}, 2048 + 16},
+	}
+
+	for _, v := range cases {
+		d := newBsdDisk(t, v.parts)
+		r := bytes.NewReader(d)
+		m, err := gpt.ReadMbr(r)
+		if err != nil {
+			t.Fatalf("%s:ReadMbr err:%s", v.name, err)
+		}
+		l, err := m.Entries[0].ReadDisklabel(r)
+		if err != nil {
+			t.Errorf("%s:ReadDisklabel err:%s", v.name, err)
+			continue
+		}
+		if len(l.Entries) != 3 {
+			t.Errorf("%s:length mismatch\n given :%d\n expect:%d", v.name, len(l.Entries), 3)
+			continue
+		}
+		if lba := l.PartitionLBA(0); lba != v.expect {
+			t.Errorf("%s:LBA mismatch\n given :%d\n expect:%d", v.name, lba, v.expect)
+		}
+
+		ps := l.Partitions()
+		if len(ps) != 3 {
+			t.Fatalf("%s:length mismatch\n given :%d\n expect:%d", v.name, len(ps), 3)
+		}
+		if ps[1].Name != "b" || ps[1].Type != "swap" || ps[1].Start != (2048+2064)*512 {
+			t.Errorf("%s:partition mismatch: %+v", v.name, ps[1])
+		}
+		if ps[0].Type != "4.2BSD" {
+			t.Errorf("%s:fstype mismatch\n given :%s\n expect:%s", v.name, ps[0].Type, "4.2BSD")
+		}
+	}
+}
+
+func TestReadDisklabelInvalid(t *testing.T) {
+	d := newBsdDisk(t, []gpt.DisklabelPartition{{Size: 2048, Offset: 16, FsType: 7}})
+	d[2049*512+100] ^= 0xff // break checksum
+
+	r := bytes.NewReader(d)
+	m, err := gpt.ReadMbr(r)
+	if err != nil {
+		t.Fatalf("ReadMbr err:%s", err)
+	}
+	if _, err := m.Entries[0].ReadDisklabel(r); err == nil {
+		t.Errorf("checksum mismatch:it should be error")
+	}
+
+	m.Entries[0].Id = 0x83
+	if _, err := m.Entries[0].ReadDisklabel(r); err == nil {
+		t.Errorf("not BSD slice:it should be error")
+	}
+}
+
+func TestReadDisklabelFromGpt(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 1)
+	g.Entries[0].TypeGuid = *gpt.FreeBSDDataGuid
+	d.putDisklabel(t, int64(g.Entries[0].FirstLBA), []gpt.DisklabelPartition{{Size: 128, Offset: 16, FsType: 27}})
+
+	l, err := g.Entries[0].ReadDisklabel(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadDisklabel err:%s", err)
+	}
+	if l.Entries[0].FsTypeString() != "ZFS" {
+		t.Errorf("fstype mismatch\n given :%s\n expect:%s", l.Entries[0].FsTypeString(), "ZFS")
+	}
+}
+
+func TestReadGptDisklabels(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 2)
+	g.Entries[1].TypeGuid = *gpt.FreeBSDDataGuid
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	if err := gpt.WriteGpt(d, g); err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+	d.putDisklabel(t, int64(g.Entries[1].FirstLBA), []gpt.DisklabelPartition{{Size: 128, Offset: 16, FsType: 7}})
+
+	rg, err := gpt.ReadGpt(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	if len(rg.Disklabels) != 1 || rg.Disklabels[1] == nil {
+		t.Fatalf("disklabel mismatch: %+v", rg.Disklabels)
+	}
+
+	ps := rg.Partitions()
+	if ps[0].Disklabel != nil || ps[1].Disklabel == nil {
+		t.Errorf("partition disklabel mismatch: %+v", ps)
+	}
+
+	e := gpt.NewRGpt(*rg).Entries[1]
+	if e.Disklabel == nil {
+		t.Fatalf("REntry disklabel is nil")
+	}
+	if p := e.Disklabel.Entries["a"]; p.FsType != "4.2BSD" || p.FirstLBA != g.Entries[1].FirstLBA+16 {
+		t.Errorf("REntry disklabel mismatch: %+v", p)
+	}
+}
+
+func TestReadMbrTableDisklabels(t *testing.T) {
+	d := newBsdDisk(t, []gpt.DisklabelPartition{{Size: 2048, Offset: 16, FsType: 7}})
+
+	tb, err := gpt.ReadMbrTable(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadMbrTable err:%s", err)
+	}
+	if len(tb.Disklabels) != 1 || tb.Disklabels[0] == nil {
+		t.Fatalf("disklabel mismatch: %+v", tb.Disklabels)
+	}
+	if ps := tb.Partitions(); len(ps) != 1 || ps[0].Disklabel == nil {
+		t.Errorf("partition disklabel mismatch: %+v", ps)
+	}
+
+	e := gpt.NewRMbrTable(*tb).Mbr.Entries[0]
+	if e.Disklabel == nil {
+		t.Fatalf("RMbrEntry disklabel is nil")
+	}
+	if p := e.Disklabel.Entries["a"]; p.FsType != "4.2BSD" || p.FirstLBA != 2048+16 {
+		t.Errorf("RMbrEntry disklabel mismatch: %+v", p)
+	}
+}
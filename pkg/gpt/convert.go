@@ -0,0 +1,289 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// MbrIdToTypeGuid returns the partition type GUID which corresponds to MBR partition id.
+// It returns nil if there is no corresponding GUID.
+func MbrIdToTypeGuid(id byte) *Guid {
+	switch id {
+	case 0x01, 0x04, 0x06, 0x07, 0x0b, 0x0c, 0x0e,
+		0x11, 0x14, 0x16, 0x17, 0x1b, 0x1c, 0x1e:
+		return MicrosoftBasicDataGuid
+	case 0x27:
+		return WindowsRecoveryGuid
+	case 0x82:
+		return LinuxSwapGuid
+	case 0x83:
+		return LinuxFilesystemGuid
+	case 0x8e:
+		return LinuxLvmGuid
+	case 0xfd:
+		return LinuxRaidGuid
+	case 0xa5:
+		return FreeBSDDataGuid
+	case 0xa6:
+		return OpenBSDDataGuid
+	case 0xa9:
+		return NetBSDFfsGuid
+	case 0xa8:
+		return AppleUfsGuid
+	case 0xaf:
+		return AppleHfsGuid
+	case 0xbe:
+		return SolarisBootGuid
+	case 0xbf:
+		return SolarisUsrGuid
+	case 0xef:
+		return EspGuid
+	case 0xfb:
+		return VMwareVmfsGuid
+	}
+	return nil
+}
+
+// NewGptFromMbr converts m and logical partitions to Gpt.
+// sectors is the number of sectors of the disk.
+// Primary partitions keep their number and logical partitions are numbered from 5.
+// It returns error if a partition overlaps with the primary or backup entries.
+func NewGptFromMbr(m Mbr, logicals []MbrEntry, sectors uint64) (*Gpt, error) {
+	if !m.IsValid() {
+		return nil, fmt.Errorf("NewGptFromMbr:invalid MBR")
+	}
+
+	g, err := NewGpt(sectors)
+	if err != nil {
+		return nil, fmt.Errorf("NewGptFromMbr:%w", err)
+	}
+	// keep boot code to boot legacy BIOS
+	g.Mbr.BootCode = m.BootCode
+	g.Mbr.DiskSignature = m.DiskSignature
+
+	convert := func(i int, e MbrEntry) error {
+		first := uint64(e.FirstLBA)
+		last := first + uint64(e.AllLBA) - 1
+		if first < g.Header.FirstUsableLBA {
+			return fmt.Errorf("NewGptFromMbr:partition %d overlaps the primary entries. FirstLBA=%d < %d", i+1, first, g.Header.FirstUsableLBA)
+		}
+		if last > g.Header.LastUsableLBA {
+			return fmt.Errorf("NewGptFromMbr:partition %d overlaps the backup entries. LastLBA=%d > %d", i+1, last, g.Header.LastUsableLBA)
+		}
+
+		t := MbrIdToTypeGuid(e.Id)
+		if t == nil {
+			return fmt.Errorf("NewGptFromMbr:partition %d has unsupported id 0x%02x", i+1, e.Id)
+		}
+		u, err := NewRandomGuid()
+		if err != nil {
+			return fmt.Errorf("NewGptFromMbr:%w", err)
+		}
+
+		ge := Entry{TypeGuid: *t, UniqueGuid: *u, FirstLBA: first, LastLBA: last}
+		if e.BootFlag == 0x80 {
+			ge.AttrFlags |= AttrLegacyBIOSBootable
+		}
+		if err := ge.WriteName(e.IdString()); err != nil {
+			return fmt.Errorf("NewGptFromMbr:%w", err)
+		}
+		g.Entries[i] = ge
+		return nil
+	}
+
+	for i, e := range m.Entries {
+		if e.Id == 0xee {
+			return nil, fmt.Errorf("NewGptFromMbr:MBR has GPT protective partition")
+		}
+		if e.IsBlank() || e.IsExtended() {
+			continue
+		}
+		if err := convert(i, e); err != nil {
+			return nil, err
+		}
+	}
+	if len(logicals) > len(g.Entries)-len(m.Entries) {
+		return nil, fmt.Errorf("NewGptFromMbr:too many logical partitions. %d", len(logicals))
+	}
+	for i, e := range logicals {
+		if err := convert(len(m.Entries)+i, e); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.UpdateCrc32(); err != nil {
+		return nil, fmt.Errorf("NewGptFromMbr:%w", err)
+	}
+	return g, nil
+}
+
+// ConvertMbrToGpt reads MBR and logical partitions from rs and writes equivalent GPT to w.
+// sectors is the number of sectors of the disk.
+// It writes only MBR, headers and entries, so the data of partitions are kept.
+func ConvertMbrToGpt(rs io.ReadSeeker, w io.WriterAt, sectors uint64) (*Gpt, error) {
+	rs.Seek(0, io.SeekStart)
+	m, err := ReadMbr(rs)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertMbrToGpt:%w", err)
+	}
+	logicals, err := ReadLogicalEntries(rs, *m)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertMbrToGpt:%w", err)
+	}
+	g, err := NewGptFromMbr(*m, logicals, sectors)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertMbrToGpt:%w", err)
+	}
+	if err := WriteGpt(w, g); err != nil {
+		return nil, fmt.Errorf("ConvertMbrToGpt:%w", err)
+	}
+	return g, nil
+}
+
+// ErrMbrNotFit is returned if the layout of GPT doesn't fit MBR.
+var ErrMbrNotFit = errors.New("layout does not fit MBR")
+
+// TypeGuidToMbrId returns MBR partition id which corresponds to the partition type GUID.
+// It returns false if there is no corresponding id.
+func TypeGuidToMbrId(g Guid) (byte, bool) {
+	ids := []struct {
+		guid *Guid
+		id   byte
+	}{
+		{MicrosoftBasicDataGuid, 0x07},
+		{WindowsRecoveryGuid, 0x27},
+		{LinuxSwapGuid, 0x82},
+		{LinuxFilesystemGuid, 0x83},
+		{LinuxLvmGuid, 0x8e},
+		{LinuxRaidGuid, 0xfd},
+		{FreeBSDDataGuid, 0xa5},
+		{OpenBSDDataGuid, 0xa6},
+		{NetBSDFfsGuid, 0xa9},
+		{AppleUfsGuid, 0xa8},
+		{AppleHfsGuid, 0xaf},
+		{SolarisBootGuid, 0xbe},
+		{SolarisUsrGuid, 0xbf},
+		{EspGuid, 0xef},
+		{VMwareVmfsGuid, 0xfb},
+	}
+	for _, v := range ids {
+		if g.Equal(*v.guid) {
+			return v.id, true
+		}
+	}
+	return 0, false
+}
+
+// NewMbrFromGpt converts g to Mbr and logical partitions.
+// If g has more than 4 partitions, the first 3 partitions are primary
+// and the others become logical partitions in an extended partition.
+// FirstLBA of logical partitions is relative to the beginning of the disk.
+// It returns an error wrapping ErrMbrNotFit if g doesn't fit MBR.
+func NewMbrFromGpt(g Gpt) (*Mbr, []MbrEntry, error) {
+	entries := []MbrEntry{}
+	for i, e := range g.Entries {
+		if e.IsBlank() {
+			continue
+		}
+		if e.LastLBA > 0xffffffff {
+			return nil, nil, fmt.Errorf("NewMbrFromGpt:%w. partition %d ends at LBA %d over 2TiB", ErrMbrNotFit, i+1, e.LastLBA)
+		}
+		id, ok := TypeGuidToMbrId(e.TypeGuid)
+		if !ok {
+			return nil, nil, fmt.Errorf("NewMbrFromGpt:%w. partition %d has unsupported type %s", ErrMbrNotFit, i+1, e.TypeGuid)
+		}
+		me := MbrEntry{FirstChs: NewChsFromLBA(e.FirstLBA), Id: id, LastChs: NewChsFromLBA(e.LastLBA), FirstLBA: uint32(e.FirstLBA), AllLBA: uint32(e.LastLBA - e.FirstLBA + 1)}
+		if e.AttrFlags&AttrLegacyBIOSBootable != 0 {
+			me.BootFlag = 0x80
+		}
+		entries = append(entries, me)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].FirstLBA < entries[j].FirstLBA })
+
+	m := &Mbr{BootCode: g.Mbr.BootCode, DiskSignature: g.Mbr.DiskSignature, Signature: 0xaa55}
+	if len(entries) <= len(m.Entries) {
+		copy(m.Entries[:], entries)
+		return m, nil, nil
+	}
+
+	primaries := len(m.Entries) - 1
+	copy(m.Entries[:], entries[:primaries])
+	logicals := entries[primaries:]
+
+	// Each logical partition needs a free sector for EBR before it.
+	prevLast := uint64(m.Entries[primaries-1].FirstLBA) + uint64(m.Entries[primaries-1].AllLBA) - 1
+	for _, e := range logicals {
+		if uint64(e.FirstLBA)-1 <= prevLast {
+			return nil, nil, fmt.Errorf("NewMbrFromGpt:%w. no space for EBR before LBA %d", ErrMbrNotFit, e.FirstLBA)
+		}
+		prevLast = uint64(e.FirstLBA) + uint64(e.AllLBA) - 1
+	}
+
+	first := uint64(logicals[0].FirstLBA) - 1
+	m.Entries[primaries] = MbrEntry{FirstChs: NewChsFromLBA(first), Id: 0x0f, LastChs: NewChsFromLBA(prevLast), FirstLBA: uint32(first), AllLBA: uint32(prevLast - first + 1)}
+
+	return m, logicals, nil
+}
+
+// zeroAt writes n zero bytes to w at off.
+func zeroAt(w io.WriterAt, off int64, n int64) error {
+	_, err := w.WriteAt(make([]byte, n), off)
+	return err
+}
+
+// wipeGpt zero-fills headers and entries of g.
+func wipeGpt(w io.WriterAt, g *Gpt) error {
+	sectorSize := g.sectorSize()
+	for _, h := range []Header{g.Header, g.BackupHeader} {
+		if err := zeroAt(w, sectorSize*int64(h.CurrentLBA), sectorSize); err != nil {
+			return err
+		}
+		n := int64(entriesSectors(h.NumOfEntries, h.SizeOfEntry, sectorSize))
+		if err := zeroAt(w, sectorSize*int64(h.StartingLBA), sectorSize*n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConvertGptToMbr converts g to MBR and writes it to w.
+// The boot code of protective MBR is kept and both primary and backup GPT are wiped.
+// It returns an error wrapping ErrMbrNotFit without writing if g doesn't fit MBR.
+func ConvertGptToMbr(w io.WriterAt, g *Gpt) (*Mbr, error) {
+	m, logicals, err := NewMbrFromGpt(*g)
+	if err != nil {
+		return nil, fmt.Errorf("ConvertGptToMbr:%w", err)
+	}
+
+	// MBR is written first so that the disk keeps GPT if writing MBR fails.
+	if err := writeAt(w, 0, m); err != nil {
+		return nil, fmt.Errorf("ConvertGptToMbr:%w", err)
+	}
+	if len(logicals) > 0 {
+		if err := WriteLogicalEntries(w, m.Entries[len(m.Entries)-1], logicals); err != nil {
+			return nil, fmt.Errorf("ConvertGptToMbr:%w", err)
+		}
+	}
+	if err := wipeGpt(w, g); err != nil {
+		return nil, fmt.Errorf("ConvertGptToMbr:%w", err)
+	}
+	return m, nil
+}
@@ -0,0 +1,309 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"testing"
+)
+
+// memDisk is an in-memory disk for testing.
+type memDisk []byte
+
+func (d memDisk) ReadAt(p []byte, off int64) (int, error) {
+	return bytes.NewReader(d).ReadAt(p, off)
+}
+
+func (d memDisk) WriteAt(p []byte, off int64) (int, error) {
+	return copy(d[off:], p), nil
+}
+
+func (d memDisk) putMbr(t *testing.T, lba int64, m *gpt.Mbr) {
+	t.Helper()
+	buf := bytes.NewBuffer([]byte{})
+	if err := binary.Write(buf, binary.LittleEndian, m); err != nil {
+		t.Fatalf("binary.Write err:%s", err)
+	}
+	copy(d[lba*512:], buf.Bytes())
+}
+
+const testDiskSectors = 8192
+
+// newMbrDisk returns a disk which has one primary and two logical partitions.
+func newMbrDisk(t *testing.T) memDisk {
+	t.Helper()
+	d := make(memDisk, testDiskSectors*512)
+
+	m := &gpt.Mbr{Signature: 0xaa55, DiskSignature: 0x12345678}
+	m.BootCode[0] = 0xeb
+	m.Entries[0] = gpt.MbrEntry{BootFlag: 0x80, Id: 0x83, FirstLBA: 2048, AllLBA: 2048}
+	m.Entries[1] = gpt.MbrEntry{Id: 0x05, FirstLBA: 4096, AllLBA: 3072}
+	d.putMbr(t, 0, m)
+
+	ebr := &gpt.Mbr{Signature: 0xaa55}
+	ebr.Entries[0] = gpt.MbrEntry{Id: 0x07, FirstLBA: 64, AllLBA: 960}
+	ebr.Entries[1] = gpt.MbrEntry{Id: 0x05, FirstLBA: 1024, AllLBA: 2048}
+	d.putMbr(t, 4096, ebr)
+
+	ebr = &gpt.Mbr{Signature: 0xaa55}
+	ebr.Entries[0] = gpt.MbrEntry{Id: 0x82, FirstLBA: 64, AllLBA: 1984}
+	d.putMbr(t, 4096+1024, ebr)
+
+	return d
+}
+
+func TestReadLogicalEntries(t *testing.T) {
+	d := newMbrDisk(t)
+	r := bytes.NewReader(d)
+	m, err := gpt.ReadMbr(r)
+	if err != nil {
+		t.Fatalf("ReadMbr err:%s", err)
+	}
+	l, err := gpt.ReadLogicalEntries(r, *m)
+	if err != nil {
+		t.Fatalf("ReadLogicalEntries err:%s", err)
+	}
+	if len(l) != 2 {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(l), 2)
+	}
+	if l[1].FirstLBA != 4096+1024+64 {
+		t.Errorf("FirstLBA mismatch\n given :%d\n expect:%d", l[1].FirstLBA, 4096+1024+64)
+	}
+}
+
+func TestConvertMbrToGpt(t *testing.T) {
+	d := newMbrDisk(t)
+	orig := append([]byte{}, d...)
+
+	_, err := gpt.ConvertMbrToGpt(bytes.NewReader(d), d, testDiskSectors)
+	if err != nil {
+		t.Fatalf("ConvertMbrToGpt err:%s", err)
+	}
+
+	g, err := gpt.ReadGpt(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	if g.Mbr.Entries[0].Id != 0xee {
+		t.Errorf("not protective MBR. Id=0x%x", g.Mbr.Entries[0].Id)
+	}
+	if g.Mbr.DiskSignature != 0x12345678 || g.Mbr.BootCode[0] != 0xeb {
+		t.Errorf("boot code is not kept")
+	}
+
+	type testcase struct {
+		index    int
+		typeGuid *gpt.Guid
+		first    uint64
+		last     uint64
+	}
+	cases := []testcase{
+		{0, gpt.LinuxFilesystemGuid, 2048, 4095},
+		{4, gpt.MicrosoftBasicDataGuid, 4096 + 64, 4096 + 1023},
+		{5, gpt.LinuxSwapGuid, 4096 + 1024 + 64, 4096 + 3071},
+	}
+	for _, v := range cases {
+		e := g.Entries[v.index]
+		if !e.TypeGuid.Equal(*v.typeGuid) {
+			t.Errorf("%d:TypeGuid mismatch\n given :%s\n expect:%s", v.index, e.TypeGuid, v.typeGuid)
+		}
+		if e.FirstLBA != v.first || e.LastLBA != v.last {
+			t.Errorf("%d:LBA mismatch\n given :%d-%d\n expect:%d-%d", v.index, e.FirstLBA, e.LastLBA, v.first, v.last)
+		}
+	}
+	if g.Entries[0].AttrFlags&gpt.AttrLegacyBIOSBootable == 0 {
+		t.Errorf("boot flag is not converted")
+	}
+	if g.Entries[0].UniqueGuid.Equal(g.Entries[4].UniqueGuid) {
+		t.Errorf("UniqueGuid is not unique")
+	}
+	if !g.Entries[1].IsBlank() {
+		t.Errorf("extended partition should not be converted")
+	}
+	usable := func(b []byte) []byte { return b[34*512 : (testDiskSectors-33)*512] }
+	if !bytes.Equal(usable(orig), usable(d)) {
+		t.Errorf("data of partitions are modified")
+	}
+}
+
+func TestConvertMbrToGptNoRoom(t *testing.T) {
+	type testcase struct {
+		name  string
+		entry gpt.MbrEntry
+	}
+	cases := []testcase{
+		{"primary", gpt.MbrEntry{Id: 0x83, FirstLBA: 1, AllLBA: 100}},
+		{"backup", gpt.MbrEntry{Id: 0x83, FirstLBA: 2048, AllLBA: testDiskSectors - 2048}},
+		{"unknown id", gpt.MbrEntry{Id: 0x5f, FirstLBA: 2048, AllLBA: 2048}},
+	}
+
+	for _, v := range cases {
+		d := make(memDisk, testDiskSectors*512)
+		m := &gpt.Mbr{Signature: 0xaa55}
+		m.Entries[0] = v.entry
+		d.putMbr(t, 0, m)
+		orig := append([]byte{}, d...)
+
+		_, err := gpt.ConvertMbrToGpt(bytes.NewReader(d), d, testDiskSectors)
+		if err == nil {
+			t.Errorf("%s:it should be error", v.name)
+		}
+		if !bytes.Equal(orig, d) {
+			t.Errorf("%s:disk is modified", v.name)
+		}
+	}
+}
+
+func TestConvertGptToMbr(t *testing.T) {
+	d := newMbrDisk(t)
+	_, err := gpt.ConvertMbrToGpt(bytes.NewReader(d), d, testDiskSectors)
+	if err != nil {
+		t.Fatalf("ConvertMbrToGpt err:%s", err)
+	}
+	g, err := gpt.ReadGpt(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+
+	_, err = gpt.ConvertGptToMbr(d, g)
+	if err != nil {
+		t.Fatalf("ConvertGptToMbr err:%s", err)
+	}
+	if _, err := gpt.ReadGpt(bytes.NewReader(d)); err == nil {
+		t.Errorf("GPT is not wiped")
+	}
+	if !bytes.Equal(d[(testDiskSectors-1)*512:], make([]byte, 512)) {
+		t.Errorf("backup header is not wiped")
+	}
+
+	r := bytes.NewReader(d)
+	m, err := gpt.ReadMbr(r)
+	if err != nil {
+		t.Fatalf("ReadMbr err:%s", err)
+	}
+	if m.DiskSignature != 0x12345678 || m.BootCode[0] != 0xeb {
+		t.Errorf("boot code is not kept")
+	}
+	if m.Entries[0].Id != 0x83 || m.Entries[0].BootFlag != 0x80 || m.Entries[0].FirstLBA != 2048 || m.Entries[0].AllLBA != 2048 {
+		t.Errorf("primary partition mismatch: %+v", m.Entries[0])
+	}
+
+	l, err := gpt.ReadLogicalEntries(r, *m)
+	if err != nil {
+		t.Fatalf("ReadLogicalEntries err:%s", err)
+	}
+	if len(l) != 0 {
+		t.Errorf("3 partitions should be primary. logicals=%d", len(l))
+	}
+	if m.Entries[2].Id != 0x82 || m.Entries[2].FirstLBA != 4096+1024+64 {
+		t.Errorf("partition mismatch: %+v", m.Entries[2])
+	}
+}
+
+// failWriter fails to write at off.
+type failWriter struct {
+	memDisk
+	off int64
+}
+
+func (w failWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off == w.off {
+		return 0, errors.New("write error")
+	}
+	return w.memDisk.WriteAt(p, off)
+}
+
+func TestConvertGptToMbrWriteError(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 2)
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	if err := gpt.WriteGpt(d, g); err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+
+	if _, err := gpt.ConvertGptToMbr(failWriter{d, 0}, g); err == nil {
+		t.Fatalf("it should be error")
+	}
+	if _, err := gpt.ReadGpt(bytes.NewReader(d)); err != nil {
+		t.Errorf("GPT should be kept. ReadGpt err:%s", err)
+	}
+}
+
+func newTestGpt(t *testing.T, n int) *gpt.Gpt {
+	t.Helper()
+	g, err := gpt.NewGpt(testDiskSectors)
+	if err != nil {
+		t.Fatalf("NewGpt err:%s", err)
+	}
+	for i := 0; i < n; i++ {
+		g.Entries[i] = gpt.Entry{TypeGuid: *gpt.LinuxFilesystemGuid, UniqueGuid: gpt.Guid{byte(i + 1)}, FirstLBA: uint64(2048 + 512*i), LastLBA: uint64(2048 + 512*i + 255)}
+	}
+	return g
+}
+
+func TestConvertGptToMbrLogical(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 6)
+
+	m, err := gpt.ConvertGptToMbr(d, g)
+	if err != nil {
+		t.Fatalf("ConvertGptToMbr err:%s", err)
+	}
+	if !m.Entries[3].IsExtended() {
+		t.Errorf("4th partition should be extended. Id=0x%x", m.Entries[3].Id)
+	}
+
+	l, err := gpt.ReadLogicalEntries(bytes.NewReader(d), *m)
+	if err != nil {
+		t.Fatalf("ReadLogicalEntries err:%s", err)
+	}
+	if len(l) != 3 {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(l), 3)
+	}
+	for i, e := range l {
+		ge := g.Entries[3+i]
+		if uint64(e.FirstLBA) != ge.FirstLBA || uint64(e.FirstLBA+e.AllLBA-1) != ge.LastLBA {
+			t.Errorf("%d:LBA mismatch\n given :%d-%d\n expect:%d-%d", i, e.FirstLBA, e.FirstLBA+e.AllLBA-1, ge.FirstLBA, ge.LastLBA)
+		}
+	}
+}
+
+func TestNewMbrFromGptNotFit(t *testing.T) {
+	type testcase struct {
+		name   string
+		modify func(g *gpt.Gpt)
+	}
+	cases := []testcase{
+		{"over 2TiB", func(g *gpt.Gpt) { g.Entries[0].LastLBA = 0x100000000 }},
+		{"unknown type", func(g *gpt.Gpt) { g.Entries[1].TypeGuid = gpt.Guid{0xff} }},
+		{"no space for EBR", func(g *gpt.Gpt) { g.Entries[4].FirstLBA = g.Entries[3].LastLBA + 1 }},
+	}
+
+	for _, v := range cases {
+		g := newTestGpt(t, 5)
+		v.modify(g)
+		_, _, err := gpt.NewMbrFromGpt(*g)
+		if !errors.Is(err, gpt.ErrMbrNotFit) {
+			t.Errorf("%s:given %v expect %s", v.name, err, gpt.ErrMbrNotFit)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/nokute78/go-gpt/pkg/fat"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Problems reported by ValidateEsp.
+var (
+	ErrEspNotFound         = errors.New("ESP not found")
+	ErrEspMultiple         = errors.New("multiple ESPs")
+	ErrEspNotFat           = errors.New("ESP is not FAT")
+	ErrEspTooSmall         = errors.New("ESP is too small")
+	ErrEspNoLoader         = errors.New("fallback loader not found")
+	ErrEspPlatformRequired = errors.New("platform required attribute is not set")
+)
+
+// EspFallbackLoaders maps architectures to the path of the fallback loader in ESP.
+// ref: UEFI specification 3.5.1.1 Removable Media Boot Behavior
+var EspFallbackLoaders = map[string]string{
+	"ia32":        "EFI/BOOT/BOOTIA32.EFI",
+	"x64":         "EFI/BOOT/BOOTX64.EFI",
+	"ia64":        "EFI/BOOT/BOOTIA64.EFI",
+	"arm":         "EFI/BOOT/BOOTARM.EFI",
+	"aa64":        "EFI/BOOT/BOOTAA64.EFI",
+	"riscv64":     "EFI/BOOT/BOOTRISCV64.EFI",
+	"loongarch64": "EFI/BOOT/BOOTLOONGARCH64.EFI",
+}
+
+// EspPolicy represents the requirements of ValidateEsp.
+type EspPolicy struct {
+	MinSize                 uint64   // minimum size in bytes. 0 means no limit.
+	Archs                   []string // keys of EspFallbackLoaders which must exist. If empty, at least one loader must exist.
+	RequirePlatformRequired bool     // AttrPlatformRequired must be set.
+}
+
+// EspResult represents the result of validation of an ESP.
+type EspResult struct {
+	Index    int    // index of Entries. It starts from 0.
+	Size     uint64 // in bytes
+	FatType  string // e.g. "FAT32". It is empty if ESP is not FAT.
+	Loaders  []string
+	Problems []error
+}
+
+// EspReport represents the result of ValidateEsp.
+type EspReport struct {
+	Esps     []EspResult
+	Problems []error // problems of the disk. e.g. ErrEspMultiple.
+}
+
+// IsValid reports whether there is no problem.
+func (r EspReport) IsValid() bool {
+	if len(r.Problems) > 0 {
+		return false
+	}
+	for _, v := range r.Esps {
+		if len(v.Problems) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateEsp validates the ESP at index i of g.Entries.
+func validateEsp(r io.ReaderAt, g *Gpt, i int, p EspPolicy) (*EspResult, error) {
+	e := g.Entries[i]
+	sr, err := g.OpenPartition(r, i)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &EspResult{Index: i, Size: uint64(sr.Size()), Loaders: []string{}}
+	if p.MinSize > 0 && ret.Size < p.MinSize {
+		ret.Problems = append(ret.Problems, fmt.Errorf("%w. %d < %d bytes", ErrEspTooSmall, ret.Size, p.MinSize))
+	}
+	if p.RequirePlatformRequired && e.AttrFlags&AttrPlatformRequired == 0 {
+		ret.Problems = append(ret.Problems, ErrEspPlatformRequired)
+	}
+
+	f, err := fat.New(sr)
+	if errors.Is(err, fat.ErrNotFat) {
+		ret.Problems = append(ret.Problems, ErrEspNotFat)
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	ret.FatType = f.Type().String()
+
+	archs := []string{}
+	for k, v := range EspFallbackLoaders {
+		if fi, err := fs.Stat(f, v); err == nil && !fi.IsDir() {
+			ret.Loaders = append(ret.Loaders, v)
+			archs = append(archs, k)
+		}
+	}
+	sort.Strings(ret.Loaders)
+
+	if len(p.Archs) == 0 && len(ret.Loaders) == 0 {
+		ret.Problems = append(ret.Problems, ErrEspNoLoader)
+	}
+	for _, a := range p.Archs {
+		found := false
+		for _, v := range archs {
+			if v == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret.Problems = append(ret.Problems, fmt.Errorf("%w. arch=%s path=%s", ErrEspNoLoader, a, EspFallbackLoaders[a]))
+		}
+	}
+	return ret, nil
+}
+
+// ValidateEsp checks whether ESPs of g look bootable.
+// It checks the filesystem, fallback loaders, size and attributes of each ESP
+// and the number of ESPs of the disk.
+func ValidateEsp(r io.ReaderAt, g *Gpt, p EspPolicy) (*EspReport, error) {
+	for _, a := range p.Archs {
+		if _, ok := EspFallbackLoaders[a]; !ok {
+			return nil, fmt.Errorf("ValidateEsp:unknown arch %q", a)
+		}
+	}
+
+	ret := &EspReport{Esps: []EspResult{}}
+	for i, e := range g.Entries {
+		if e.IsBlank() || !e.TypeGuid.Equal(*EspGuid) {
+			continue
+		}
+		v, err := validateEsp(r, g, i, p)
+		if err != nil {
+			return nil, fmt.Errorf("ValidateEsp:%w", err)
+		}
+		ret.Esps = append(ret.Esps, *v)
+	}
+
+	switch {
+	case len(ret.Esps) == 0:
+		ret.Problems = append(ret.Problems, ErrEspNotFound)
+	case len(ret.Esps) > 1:
+		ret.Problems = append(ret.Problems, fmt.Errorf("%w. %d ESPs", ErrEspMultiple, len(ret.Esps)))
+	}
+	return ret, nil
+}
+
+// FormatEsp formats FAT32 in the ESP at index i of Entries and copies files of src to it.
+// Index starts from 0. src can be nil to make an empty ESP.
+// The sector size of the filesystem is the logical sector size of g unless opt.SectorSize is set.
+func FormatEsp(w io.WriterAt, g *Gpt, i int, opt fat.FormatOptions, src fs.FS) error {
+	off, n, err := g.section(i)
+	if err != nil {
+		return fmt.Errorf("FormatEsp:%w", err)
+	}
+	if !g.Entries[i].TypeGuid.Equal(*EspGuid) {
+		return fmt.Errorf("FormatEsp:%w. index=%d", ErrEspNotFound, i)
+	}
+	if opt.SectorSize == 0 {
+		opt.SectorSize = g.sectorSize()
+	}
+	if opt.HiddenSectors == 0 {
+		opt.HiddenSectors = uint32(off / opt.SectorSize)
+	}
+	if err := fat.Format(NewSectionWriter(w, off, n), n, opt, src); err != nil {
+		return fmt.Errorf("FormatEsp:%w", err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/fat"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// newEspDisk returns a disk which has n ESPs. Each ESP has the FAT12 image of pkg/fat.
+func newEspDisk(t *testing.T, n int) (*gpt.Gpt, memDisk) {
+	t.Helper()
+	img, err := ioutil.ReadFile(filepath.Join("..", "fat", "testdata", "fat12.img"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, n)
+	for i := 0; i < n; i++ {
+		g.Entries[i].TypeGuid = *gpt.EspGuid
+		g.Entries[i].LastLBA = g.Entries[i].FirstLBA + uint64(len(img)/512) - 1
+		copy(d[g.Entries[i].FirstLBA*512:], img)
+	}
+	return g, d
+}
+
+func TestValidateEsp(t *testing.T) {
+	type testcase struct {
+		name   string
+		n      int
+		policy gpt.EspPolicy
+		modify func(g *gpt.Gpt, d memDisk)
+		expect error
+	}
+
+	cases := []testcase{
+		{"valid", 1, gpt.EspPolicy{}, nil, nil},
+		{"valid x64", 1, gpt.EspPolicy{Archs: []string{"x64"}, MinSize: 256 * 1024}, nil, nil},
+		{"no ESP", 0, gpt.EspPolicy{}, nil, gpt.ErrEspNotFound},
+		{"multiple", 2, gpt.EspPolicy{}, nil, gpt.ErrEspMultiple},
+		{"too small", 1, gpt.EspPolicy{MinSize: 100 * 1024 * 1024}, nil, gpt.ErrEspTooSmall},
+		{"no aa64 loader", 1, gpt.EspPolicy{Archs: []string{"x64", "aa64"}}, nil, gpt.ErrEspNoLoader},
+		{"platform required", 1, gpt.EspPolicy{RequirePlatformRequired: true}, nil, gpt.ErrEspPlatformRequired},
+		{"not FAT", 1, gpt.EspPolicy{}, func(g *gpt.Gpt, d memDisk) {
+			copy(d[g.Entries[0].FirstLBA*512:], make([]byte, 512))
+		}, gpt.ErrEspNotFat},
+	}
+
+	for _, v := range cases {
+		g, d := newEspDisk(t, v.n)
+		if v.modify != nil {
+			v.modify(g, d)
+		}
+		r, err := gpt.ValidateEsp(d, g, v.policy)
+		if err != nil {
+			t.Errorf("%s:ValidateEsp err:%s", v.name, err)
+			continue
+		}
+
+		problems := append([]error{}, r.Problems...)
+		for _, e := range r.Esps {
+			problems = append(problems, e.Problems...)
+		}
+		if v.expect == nil {
+			if !r.IsValid() {
+				t.Errorf("%s:it should be valid. %v", v.name, problems)
+			}
+			continue
+		}
+		found := false
+		for _, p := range problems {
+			if errors.Is(p, v.expect) {
+				found = true
+			}
+		}
+		if !found || r.IsValid() {
+			t.Errorf("%s:given %v expect %s", v.name, problems, v.expect)
+		}
+	}
+}
+
+func TestValidateEspLoaders(t *testing.T) {
+	g, d := newEspDisk(t, 1)
+	r, err := gpt.ValidateEsp(d, g, gpt.EspPolicy{})
+	if err != nil {
+		t.Fatalf("ValidateEsp err:%s", err)
+	}
+	e := r.Esps[0]
+	if e.FatType != "FAT12" {
+		t.Errorf("FatType mismatch\n given :%s\n expect:%s", e.FatType, "FAT12")
+	}
+	if len(e.Loaders) != 1 || e.Loaders[0] != gpt.EspFallbackLoaders["x64"] {
+		t.Errorf("Loaders mismatch\n given :%v\n expect:%s", e.Loaders, gpt.EspFallbackLoaders["x64"])
+	}
+}
+
+// failReader fails to read at off.
+type failReader struct {
+	memDisk
+	off int64
+}
+
+func (r failReader) ReadAt(b []byte, off int64) (int, error) {
+	if off <= r.off && r.off < off+int64(len(b)) {
+		return 0, errors.New("read error")
+	}
+	return r.memDisk.ReadAt(b, off)
+}
+
+func TestValidateEspError(t *testing.T) {
+	g, d := newEspDisk(t, 1)
+	if _, err := gpt.ValidateEsp(d, g, gpt.EspPolicy{Archs: []string{"x86_64"}}); err == nil {
+		t.Errorf("unknown arch should be error")
+	}
+
+	r, err := gpt.ValidateEsp(failReader{d, int64(g.Entries[0].FirstLBA) * 512}, g, gpt.EspPolicy{})
+	if err == nil {
+		t.Errorf("read error should not be reported as a problem. %+v", r)
+	}
+}
+
+func TestFormatEsp(t *testing.T) {
+	const sectors = 48 * 1024 * 2 // 48MiB
+	g, err := gpt.NewGpt(sectors)
+	if err != nil {
+		t.Fatalf("NewGpt err:%s", err)
+	}
+	g.Entries[0] = gpt.Entry{TypeGuid: *gpt.EspGuid, UniqueGuid: gpt.Guid{1}, FirstLBA: 2048, LastLBA: sectors - 2048}
+	g.Entries[1] = gpt.Entry{TypeGuid: *gpt.LinuxFilesystemGuid, UniqueGuid: gpt.Guid{2}, FirstLBA: 34, LastLBA: 2047}
+	d := make(memDisk, sectors*512)
+
+	src := fstest.MapFS{
+		"EFI/BOOT/BOOTX64.EFI": {Data: []byte("MZ loader")},
+	}
+	if err := gpt.FormatEsp(d, g, 0, fat.FormatOptions{Label: "EFI"}, src); err != nil {
+		t.Fatalf("FormatEsp err:%s", err)
+	}
+	if err := gpt.FormatEsp(d, g, 1, fat.FormatOptions{}, src); !errors.Is(err, gpt.ErrEspNotFound) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, gpt.ErrEspNotFound)
+	}
+
+	r, err := gpt.ValidateEsp(d, g, gpt.EspPolicy{Archs: []string{"x64"}})
+	if err != nil {
+		t.Fatalf("ValidateEsp err:%s", err)
+	}
+	if len(r.Esps) != 1 || r.Esps[0].FatType != "FAT32" {
+		t.Fatalf("ESP mismatch. %+v", r.Esps)
+	}
+	if len(r.Esps[0].Problems) != 0 {
+		t.Errorf("ESP should be valid. %v", r.Esps[0].Problems)
+	}
+}
+
+func TestFormatEsp4Kn(t *testing.T) {
+	// FAT32 of 4KiB sectors needs 256MiB at least. Use sparse file.
+	const sectorSize = 4096
+	const sectors = 300 * 1024 * 1024 / sectorSize
+	g, err := gpt.NewGpt(sectors)
+	if err != nil {
+		t.Fatalf("NewGpt err:%s", err)
+	}
+	g.SectorSize = sectorSize
+	g.Entries[0] = gpt.Entry{TypeGuid: *gpt.EspGuid, UniqueGuid: gpt.Guid{1}, FirstLBA: 256, LastLBA: sectors - 256}
+	d, err := ioutil.TempFile("", "esp")
+	if err != nil {
+		t.Fatalf("TempFile err:%s", err)
+	}
+	defer os.Remove(d.Name())
+	defer d.Close()
+	if err := d.Truncate(sectors * sectorSize); err != nil {
+		t.Fatalf("Truncate err:%s", err)
+	}
+
+	if err := gpt.FormatEsp(d, g, 0, fat.FormatOptions{}, nil); err != nil {
+		t.Fatalf("FormatEsp err:%s", err)
+	}
+	b := make([]byte, 32)
+	if _, err := d.ReadAt(b, 256*sectorSize); err != nil {
+		t.Fatalf("ReadAt err:%s", err)
+	}
+	if bps := binary.LittleEndian.Uint16(b[11:]); bps != sectorSize {
+		t.Errorf("bytes per sector mismatch\n given :%d\n expect:%d", bps, sectorSize)
+	}
+	if hidden := binary.LittleEndian.Uint32(b[28:]); hidden != 256 {
+		t.Errorf("hidden sectors mismatch\n given :%d\n expect:%d", hidden, 256)
+	}
+}
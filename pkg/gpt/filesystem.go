@@ -0,0 +1,411 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrNoFilesystem is returned if no known signature is found.
+var ErrNoFilesystem = errors.New("no known filesystem")
+
+// Filesystem represents the content of a partition.
+// Type is the same name as blkid. e.g. "vfat", "ext4", "crypto_LUKS".
+type Filesystem struct {
+	Type    string
+	Version string `json:",omitempty"`
+	Label   string `json:",omitempty"`
+	Uuid    string `json:",omitempty"`
+	Size    int64  `json:",omitempty"` // in bytes if the superblock has it.
+}
+
+// fsProbes are tried in order.
+// Containers like RAID and encryption are tried before filesystems
+// since they may be wrapped around a filesystem signature.
+var fsProbes = []func(r io.ReaderAt, size int64) *Filesystem{
+	probeMdraid,
+	probeLuks,
+	probeLvm2,
+	probeBitLocker,
+	probeExfat,
+	probeNtfs,
+	probeFat,
+	probeExt,
+	probeXfs,
+	probeBtrfs,
+	probeSwap,
+	probeZfs,
+	probeIso9660,
+	probeSquashfs,
+	probeErofs,
+}
+
+// ProbeFilesystem detects the filesystem from the known superblock signatures like blkid.
+// size is the size of r in bytes. It can be 0 if unknown,
+// then signatures at the end of r like md v1.0 and v0.90 are not probed.
+// It returns ErrNoFilesystem if no known signature is found.
+func ProbeFilesystem(r io.ReaderAt, size int64) (*Filesystem, error) {
+	for _, f := range fsProbes {
+		if fs := f(r, size); fs != nil {
+			return fs, nil
+		}
+	}
+	return nil, ErrNoFilesystem
+}
+
+// ProbeFilesystem detects the filesystem in the partition of e.
+// The logical sector size of r is 512.
+func (e Entry) ProbeFilesystem(r io.ReaderAt) (*Filesystem, error) {
+	return e.ProbeFilesystemWithSectorSize(r, DefaultSectorSize)
+}
+
+// ProbeFilesystemWithSectorSize detects the filesystem in the partition of e.
+// sectorSize is the logical sector size of r.
+func (e Entry) ProbeFilesystemWithSectorSize(r io.ReaderAt, sectorSize int64) (*Filesystem, error) {
+	size := int64(e.LastLBA-e.FirstLBA+1) * sectorSize
+	return ProbeFilesystem(io.NewSectionReader(r, int64(e.FirstLBA)*sectorSize, size), size)
+}
+
+// ProbeFilesystem detects the filesystem in the partition of m.
+// The logical sector size of r is 512.
+func (m MbrEntry) ProbeFilesystem(r io.ReaderAt) (*Filesystem, error) {
+	return m.ProbeFilesystemWithSectorSize(r, DefaultSectorSize)
+}
+
+// ProbeFilesystemWithSectorSize detects the filesystem in the partition of m.
+// sectorSize is the logical sector size of r.
+func (m MbrEntry) ProbeFilesystemWithSectorSize(r io.ReaderAt, sectorSize int64) (*Filesystem, error) {
+	size := int64(m.AllLBA) * sectorSize
+	return ProbeFilesystem(io.NewSectionReader(r, int64(m.FirstLBA)*sectorSize, size), size)
+}
+
+// readBytes reads n bytes at off. It returns nil if it fails.
+func readBytes(r io.ReaderAt, off int64, n int) []byte {
+	if off < 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil
+	}
+	return b
+}
+
+// hasMagic reports whether r has magic at off.
+func hasMagic(r io.ReaderAt, off int64, magic string) bool {
+	b := readBytes(r, off, len(magic))
+	return b != nil && string(b) == magic
+}
+
+// trimLabel removes null chars and trailing spaces.
+func trimLabel(b []byte) string {
+	return strings.TrimRight(cString(b), " ")
+}
+
+// formatUuid returns the string representation of 16 bytes UUID in big endian.
+func formatUuid(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+func probeMdraid(r io.ReaderAt, size int64) *Filesystem {
+	const magic = 0xa92b4efc
+
+	// version 1.1, 1.2 and 1.0
+	offs := []int64{0, 4096}
+	if size > 8192 {
+		offs = append(offs, (size&^4095)-8192)
+	}
+	for _, off := range offs {
+		b := readBytes(r, off, 64)
+		if b != nil && binary.LittleEndian.Uint32(b) == magic && binary.LittleEndian.Uint32(b[4:]) == 1 {
+			return &Filesystem{Type: "linux_raid_member", Version: "1", Label: trimLabel(b[32:64]), Uuid: formatUuid(b[16:32])}
+		}
+	}
+
+	// version 0.90 is at the last 64KiB aligned block
+	if size >= 0x20000 {
+		b := readBytes(r, (size&^0xffff)-0x10000, 64)
+		if b != nil && binary.LittleEndian.Uint32(b) == magic {
+			u := append(append([]byte{}, b[20:24]...), b[52:64]...)
+			return &Filesystem{Type: "linux_raid_member", Version: "0.90", Uuid: formatUuid(u)}
+		}
+	}
+	return nil
+}
+
+func probeLuks(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0, 208)
+	if b == nil || string(b[:6]) != "LUKS\xba\xbe" {
+		return nil
+	}
+	v := binary.BigEndian.Uint16(b[6:])
+	fs := &Filesystem{Type: "crypto_LUKS", Version: fmt.Sprintf("%d", v), Uuid: cString(b[168:208])}
+	if v == 2 {
+		fs.Label = cString(b[24:72])
+	}
+	return fs
+}
+
+func probeLvm2(r io.ReaderAt, size int64) *Filesystem {
+	// label is in one of the first 4 sectors
+	for i := int64(0); i < 4; i++ {
+		b := readBytes(r, i*512, 64)
+		if b == nil || string(b[:8]) != "LABELONE" || string(b[24:32]) != "LVM2 001" {
+			continue
+		}
+		u := string(b[32:64])
+		return &Filesystem{Type: "LVM2_member", Version: "LVM2 001",
+			Uuid: strings.Join([]string{u[0:6], u[6:10], u[10:14], u[14:18], u[18:22], u[22:26], u[26:32]}, "-")}
+	}
+	return nil
+}
+
+func probeBitLocker(r io.ReaderAt, size int64) *Filesystem {
+	if hasMagic(r, 3, "-FVE-FS-") {
+		return &Filesystem{Type: "BitLocker"}
+	}
+	return nil
+}
+
+func probeExfat(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0, 512)
+	if b == nil || string(b[3:11]) != "EXFAT   " {
+		return nil
+	}
+	id := binary.LittleEndian.Uint32(b[100:])
+	return &Filesystem{Type: "exfat", Uuid: fmt.Sprintf("%04X-%04X", id>>16, id&0xffff),
+		Size: int64(binary.LittleEndian.Uint64(b[72:])) << b[108]}
+}
+
+func probeNtfs(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0, 512)
+	if b == nil || string(b[3:11]) != "NTFS    " {
+		return nil
+	}
+	// the backup boot sector is at the end of the volume.
+	n := int64(binary.LittleEndian.Uint64(b[40:])) + 1
+	return &Filesystem{Type: "ntfs", Uuid: fmt.Sprintf("%016X", binary.LittleEndian.Uint64(b[72:])),
+		Size: n * int64(binary.LittleEndian.Uint16(b[11:]))}
+}
+
+// fatBpb represents the BIOS parameter block of FAT.
+type fatBpb struct {
+	Jump           [3]byte
+	OemName        [8]byte
+	BytesPerSector uint16
+	SecPerCluster  uint8
+	ReservedSec    uint16
+	NumFats        uint8
+	RootEntries    uint16
+	TotalSectors16 uint16
+	Media          uint8
+	FatSize16      uint16
+	SecPerTrack    uint16
+	NumHeads       uint16
+	HiddenSectors  uint32
+	TotalSectors32 uint32
+}
+
+// isPowerOf2 reports whether v is power of 2.
+func isPowerOf2(v uint32) bool {
+	return v != 0 && v&(v-1) == 0
+}
+
+// fatType returns "FAT12", "FAT16" or "FAT32" from the number of clusters.
+// It returns "" if b is not FAT boot sector.
+func fatType(b []byte) string {
+	bpb := fatBpb{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &bpb); err != nil {
+		return ""
+	}
+	if bpb.Jump[0] != 0xeb && bpb.Jump[0] != 0xe9 {
+		return ""
+	}
+	bps := uint32(bpb.BytesPerSector)
+	if bps < 512 || bps > 4096 || !isPowerOf2(bps) || !isPowerOf2(uint32(bpb.SecPerCluster)) || bpb.ReservedSec == 0 || bpb.NumFats == 0 {
+		return ""
+	}
+
+	fatSize := uint32(bpb.FatSize16)
+	if fatSize == 0 {
+		fatSize = binary.LittleEndian.Uint32(b[36:])
+	}
+	total := uint32(bpb.TotalSectors16)
+	if total == 0 {
+		total = bpb.TotalSectors32
+	}
+	rootSectors := (uint32(bpb.RootEntries)*32 + bps - 1) / bps
+	meta := uint32(bpb.ReservedSec) + uint32(bpb.NumFats)*fatSize + rootSectors
+	if fatSize == 0 || total <= meta {
+		return ""
+	}
+
+	clusters := (total - meta) / uint32(bpb.SecPerCluster)
+	switch {
+	case clusters < 4085:
+		return "FAT12"
+	case clusters < 65525:
+		return "FAT16"
+	}
+	return "FAT32"
+}
+
+func probeFat(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0, 512)
+	if b == nil {
+		return nil
+	}
+	t := fatType(b)
+	if t == "" {
+		return nil
+	}
+
+	total := int64(binary.LittleEndian.Uint16(b[19:]))
+	if total == 0 {
+		total = int64(binary.LittleEndian.Uint32(b[32:]))
+	}
+	fs := &Filesystem{Type: "vfat", Version: t, Size: total * int64(binary.LittleEndian.Uint16(b[11:]))}
+	// extended BPB
+	off := 36
+	if t == "FAT32" {
+		off = 64
+	}
+	if b[off+2] == 0x29 {
+		id := binary.LittleEndian.Uint32(b[off+3:])
+		fs.Uuid = fmt.Sprintf("%04X-%04X", id>>16, id&0xffff)
+		if l := trimLabel(b[off+7 : off+18]); l != "NO NAME" {
+			fs.Label = l
+		}
+	}
+	return fs
+}
+
+func probeExt(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 1024, 0x154)
+	if b == nil || binary.LittleEndian.Uint16(b[56:]) != 0xef53 {
+		return nil
+	}
+	compat := binary.LittleEndian.Uint32(b[92:])
+	incompat := binary.LittleEndian.Uint32(b[96:])
+
+	blocks := int64(binary.LittleEndian.Uint32(b[4:]))
+	if incompat&0x80 != 0 { // 64bit
+		blocks |= int64(binary.LittleEndian.Uint32(b[0x150:])) << 32
+	}
+	fs := &Filesystem{Type: "ext2", Version: "1.0", Label: cString(b[120:136]), Uuid: formatUuid(b[104:120]),
+		Size: blocks * (1024 << binary.LittleEndian.Uint32(b[24:]))}
+	switch {
+	case incompat&(0x40|0x80|0x200) != 0: // extents, 64bit, flex_bg
+		fs.Type = "ext4"
+	case compat&0x4 != 0: // has_journal
+		fs.Type = "ext3"
+	}
+	return fs
+}
+
+func probeXfs(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0, 120)
+	if b == nil || string(b[:4]) != "XFSB" {
+		return nil
+	}
+	return &Filesystem{Type: "xfs", Label: cString(b[108:120]), Uuid: formatUuid(b[32:48]),
+		Size: int64(binary.BigEndian.Uint64(b[8:])) * int64(binary.BigEndian.Uint32(b[4:]))}
+}
+
+func probeBtrfs(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0x10000, 0x22b)
+	if b == nil || string(b[0x40:0x48]) != "_BHRfS_M" {
+		return nil
+	}
+	return &Filesystem{Type: "btrfs", Label: cString(b[0x12b:0x22b]), Uuid: formatUuid(b[0x20:0x30]),
+		Size: int64(binary.LittleEndian.Uint64(b[0x70:]))}
+}
+
+func probeSwap(r io.ReaderAt, size int64) *Filesystem {
+	for _, pagesize := range []int64{4096, 8192, 16384, 65536} {
+		b := readBytes(r, pagesize-10, 10)
+		if b == nil {
+			return nil
+		}
+		switch string(b) {
+		case "SWAP-SPACE":
+			return &Filesystem{Type: "swap", Version: "0"}
+		case "SWAPSPACE2":
+			fs := &Filesystem{Type: "swap", Version: "1"}
+			if h := readBytes(r, 1024, 44); h != nil {
+				fs.Uuid = formatUuid(h[12:28])
+				fs.Label = cString(h[28:44])
+				fs.Size = (int64(binary.LittleEndian.Uint32(h[4:])) + 1) * pagesize
+			}
+			return fs
+		}
+	}
+	return nil
+}
+
+func probeZfs(r io.ReaderAt, size int64) *Filesystem {
+	const magic = 0x00bab10c
+
+	// uberblock array is at 128KiB of the first label.
+	b := readBytes(r, 128*1024, 128*1024)
+	if b == nil {
+		return nil
+	}
+	for off := 0; off < len(b); off += 1024 {
+		if binary.LittleEndian.Uint64(b[off:]) == magic || binary.BigEndian.Uint64(b[off:]) == magic {
+			return &Filesystem{Type: "zfs_member"}
+		}
+	}
+	return nil
+}
+
+func probeIso9660(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0x8000, 2048)
+	if b == nil || string(b[1:6]) != "CD001" || b[0] != 1 {
+		return nil
+	}
+	fs := &Filesystem{Type: "iso9660", Label: trimLabel(b[40:72]),
+		Size: int64(binary.LittleEndian.Uint32(b[80:])) * int64(binary.LittleEndian.Uint16(b[128:]))}
+	// blkid uses the creation date as UUID.
+	d := b[813:829]
+	if d[0] != '0' && d[0] != 0 {
+		fs.Uuid = fmt.Sprintf("%s-%s-%s-%s-%s-%s-%s", d[0:4], d[4:6], d[6:8], d[8:10], d[10:12], d[12:14], d[14:16])
+	}
+	return fs
+}
+
+func probeSquashfs(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 0, 48)
+	if b == nil || string(b[:4]) != "hsqs" {
+		return nil
+	}
+	return &Filesystem{Type: "squashfs", Version: fmt.Sprintf("%d.%d", binary.LittleEndian.Uint16(b[28:]), binary.LittleEndian.Uint16(b[30:])),
+		Size: int64(binary.LittleEndian.Uint64(b[40:]))}
+}
+
+func probeErofs(r io.ReaderAt, size int64) *Filesystem {
+	b := readBytes(r, 1024, 80)
+	if b == nil || binary.LittleEndian.Uint32(b) != 0xe0f5e1e2 {
+		return nil
+	}
+	return &Filesystem{Type: "erofs", Label: cString(b[64:80]), Uuid: formatUuid(b[48:64])}
+}
@@ -0,0 +1,190 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"testing"
+)
+
+var testUuid = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef, 0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+
+const testUuidString = "01234567-89ab-cdef-0123-456789abcdef"
+
+// putFatBootSector writes FAT boot sector which has clusters.
+func putFatBootSector(b []byte, clusters uint32, fat32 bool) {
+	copy(b, []byte{0xeb, 0x3c, 0x90})
+	copy(b[3:], "mkfs.fat")
+	binary.LittleEndian.PutUint16(b[11:], 512)
+	b[13] = 1 // sectors per cluster
+	binary.LittleEndian.PutUint16(b[14:], 1)
+	b[16] = 2
+	off := 36
+	if fat32 {
+		binary.LittleEndian.PutUint32(b[32:], clusters+1+2*1024)
+		binary.LittleEndian.PutUint32(b[36:], 1024)
+		off = 64
+	} else {
+		binary.LittleEndian.PutUint16(b[17:], 16) // 1 sector
+		binary.LittleEndian.PutUint32(b[32:], clusters+1+2*64+1)
+		binary.LittleEndian.PutUint16(b[22:], 64)
+	}
+	b[off+2] = 0x29
+	binary.LittleEndian.PutUint32(b[off+3:], 0x1234abcd)
+	copy(b[off+7:], "ESP        ")
+	b[510] = 0x55
+	b[511] = 0xaa
+}
+
+func TestProbeFilesystem(t *testing.T) {
+	type testcase struct {
+		name    string
+		build   func(b []byte)
+		typ     string
+		version string
+		label   string
+		uuid    string
+		size    int64
+	}
+
+	cases := []testcase{
+		{"fat12", func(b []byte) { putFatBootSector(b, 2000, false) }, "vfat", "FAT12", "ESP", "1234-ABCD", 1090560},
+		{"fat16", func(b []byte) { putFatBootSector(b, 20000, false) }, "vfat", "FAT16", "ESP", "1234-ABCD", 10306560},
+		{"fat32", func(b []byte) { putFatBootSector(b, 70000, true) }, "vfat", "FAT32", "ESP", "1234-ABCD", 36889088},
+		{"exfat", func(b []byte) {
+			copy(b[3:], "EXFAT   ")
+			binary.LittleEndian.PutUint32(b[100:], 0x1234abcd)
+		}, "exfat", "", "", "1234-ABCD", 0},
+		{"ntfs", func(b []byte) {
+			copy(b[3:], "NTFS    ")
+			binary.LittleEndian.PutUint64(b[72:], 0x0123456789abcdef)
+		}, "ntfs", "", "", "0123456789ABCDEF", 0},
+		{"ext2", func(b []byte) {
+			binary.LittleEndian.PutUint16(b[1024+56:], 0xef53)
+			copy(b[1024+104:], testUuid)
+			copy(b[1024+120:], "root")
+		}, "ext2", "1.0", "root", testUuidString, 0},
+		{"ext3", func(b []byte) {
+			binary.LittleEndian.PutUint16(b[1024+56:], 0xef53)
+			binary.LittleEndian.PutUint32(b[1024+92:], 0x4)
+		}, "ext3", "1.0", "", "00000000-0000-0000-0000-000000000000", 0},
+		{"ext4", func(b []byte) {
+			binary.LittleEndian.PutUint16(b[1024+56:], 0xef53)
+			binary.LittleEndian.PutUint32(b[1024+92:], 0x4)
+			binary.LittleEndian.PutUint32(b[1024+96:], 0x40)
+		}, "ext4", "1.0", "", "00000000-0000-0000-0000-000000000000", 0},
+		{"xfs", func(b []byte) {
+			copy(b, "XFSB")
+			copy(b[32:], testUuid)
+			copy(b[108:], "data")
+		}, "xfs", "", "data", testUuidString, 0},
+		{"btrfs", func(b []byte) {
+			copy(b[0x10040:], "_BHRfS_M")
+			copy(b[0x10020:], testUuid)
+			copy(b[0x1012b:], "pool")
+		}, "btrfs", "", "pool", testUuidString, 0},
+		{"swap", func(b []byte) {
+			copy(b[4096-10:], "SWAPSPACE2")
+			copy(b[1024+12:], testUuid)
+			copy(b[1024+28:], "swap0")
+		}, "swap", "1", "swap0", testUuidString, 4096},
+		{"luks1", func(b []byte) {
+			copy(b, "LUKS\xba\xbe\x00\x01")
+			copy(b[168:], testUuidString)
+		}, "crypto_LUKS", "1", "", testUuidString, 0},
+		{"luks2", func(b []byte) {
+			copy(b, "LUKS\xba\xbe\x00\x02")
+			copy(b[24:], "secret")
+			copy(b[168:], testUuidString)
+		}, "crypto_LUKS", "2", "secret", testUuidString, 0},
+		{"lvm2", func(b []byte) {
+			copy(b[512:], "LABELONE")
+			copy(b[512+24:], "LVM2 001")
+			copy(b[512+32:], "abcdefghijklmnopqrstuvwxyz012345")
+		}, "LVM2_member", "LVM2 001", "", "abcdef-ghij-klmn-opqr-stuv-wxyz-012345", 0},
+		{"mdraid", func(b []byte) {
+			binary.LittleEndian.PutUint32(b[4096:], 0xa92b4efc)
+			binary.LittleEndian.PutUint32(b[4096+4:], 1)
+			copy(b[4096+16:], testUuid)
+			copy(b[4096+32:], "host:0")
+		}, "linux_raid_member", "1", "host:0", testUuidString, 0},
+		{"zfs", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[128*1024+2048:], 0x00bab10c)
+		}, "zfs_member", "", "", "", 0},
+		{"bitlocker", func(b []byte) {
+			copy(b, []byte{0xeb, 0x58, 0x90})
+			copy(b[3:], "-FVE-FS-")
+		}, "BitLocker", "", "", "", 0},
+		{"iso9660", func(b []byte) {
+			b[0x8000] = 1
+			copy(b[0x8001:], "CD001")
+			copy(b[0x8000+40:], "UBUNTU                          ")
+			copy(b[0x8000+813:], "2021020712345600")
+		}, "iso9660", "", "UBUNTU", "2021-02-07-12-34-56-00", 0},
+		{"squashfs", func(b []byte) {
+			copy(b, "hsqs")
+			binary.LittleEndian.PutUint16(b[28:], 4)
+		}, "squashfs", "4.0", "", "", 0},
+		{"erofs", func(b []byte) {
+			binary.LittleEndian.PutUint32(b[1024:], 0xe0f5e1e2)
+			copy(b[1024+48:], testUuid)
+			copy(b[1024+64:], "rootfs")
+		}, "erofs", "", "rootfs", testUuidString, 0},
+	}
+
+	for _, v := range cases {
+		b := make([]byte, 512*1024)
+		v.build(b)
+		fs, err := gpt.ProbeFilesystem(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Errorf("%s:ProbeFilesystem err:%s", v.name, err)
+			continue
+		}
+		expect := gpt.Filesystem{Type: v.typ, Version: v.version, Label: v.label, Uuid: v.uuid, Size: v.size}
+		if *fs != expect {
+			t.Errorf("%s:mismatch\n given :%+v\n expect:%+v", v.name, *fs, expect)
+		}
+	}
+}
+
+func TestProbeFilesystemNone(t *testing.T) {
+	b := make([]byte, 512*1024)
+	_, err := gpt.ProbeFilesystem(bytes.NewReader(b), int64(len(b)))
+	if !errors.Is(err, gpt.ErrNoFilesystem) {
+		t.Errorf("given %v expect %s", err, gpt.ErrNoFilesystem)
+	}
+}
+
+func TestEntryProbeFilesystem(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 2)
+	putFatBootSector(d[g.Entries[1].FirstLBA*512:], 200, false)
+
+	fs, err := g.Entries[1].ProbeFilesystem(d)
+	if err != nil {
+		t.Fatalf("ProbeFilesystem err:%s", err)
+	}
+	if fs.Type != "vfat" {
+		t.Errorf("type mismatch\n given :%s\n expect:%s", fs.Type, "vfat")
+	}
+	if _, err := g.Entries[0].ProbeFilesystem(d); err == nil {
+		t.Errorf("It should be error")
+	}
+}
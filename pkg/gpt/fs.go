@@ -0,0 +1,192 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Names of metadata files in FS.
+const (
+	FSMbrName     = "mbr.bin"
+	FSHeaderName  = "header.bin"
+	FSEntriesName = "entries.bin"
+)
+
+// PartitionInfo is returned by Sys() of fs.FileInfo of the partition file in FS.
+type PartitionInfo struct {
+	Index      int // index of Entries. It starts from 0.
+	Type       string
+	TypeGuid   Guid
+	UniqueGuid Guid
+	Entry      Entry
+}
+
+// FS represents a disk image as fs.FS.
+// Each partition is a file named "<number>-<name>.img" in the root directory.
+// The number starts from 1.
+// There are also FSMbrName, FSHeaderName and FSEntriesName for raw MBR, primary header and entries.
+type FS struct {
+	r     io.ReaderAt
+	files []fsFile // sorted by name
+}
+
+// fsFile is a file in FS.
+type fsFile struct {
+	name string
+	off  int64
+	size int64
+	sys  interface{}
+}
+
+// fsFileName returns the file name of the partition.
+// It replaces the characters which can't be used in the file name.
+func fsFileName(i int, name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '/' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	if name == "" {
+		return fmt.Sprintf("%d.img", i+1)
+	}
+	return fmt.Sprintf("%d-%s.img", i+1, name)
+}
+
+// NewFS returns FS of g which is read from r.
+func NewFS(r io.ReaderAt, g *Gpt) *FS {
+	sectorSize := g.sectorSize()
+
+	ret := &FS{r: r}
+	ret.files = []fsFile{
+		{name: FSMbrName, off: 0, size: 512},
+		{name: FSHeaderName, off: sectorSize * int64(g.Header.CurrentLBA), size: sectorSize},
+		{name: FSEntriesName, off: sectorSize * int64(g.Header.StartingLBA), size: int64(g.Header.NumOfEntries) * int64(g.Header.SizeOfEntry)},
+	}
+	for i, e := range g.Entries {
+		off, n, err := g.section(i)
+		if err != nil {
+			continue
+		}
+		info := &PartitionInfo{Index: i, Type: e.TypeGuid.TypeString(), TypeGuid: e.TypeGuid, UniqueGuid: e.UniqueGuid, Entry: e}
+		ret.files = append(ret.files, fsFile{name: fsFileName(i, e.ReadName()), off: off, size: n, sys: info})
+	}
+	sort.Slice(ret.files, func(i, j int) bool { return ret.files[i].name < ret.files[j].name })
+	return ret
+}
+
+// Open implements fs.FS interface.
+func (f *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return &fsDir{fs: f}, nil
+	}
+	for _, v := range f.files {
+		if v.name == name {
+			return &fsOpenFile{SectionReader: io.NewSectionReader(f.r, v.off, v.size), info: fsFileInfo{v}}, nil
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir implements fs.ReadDirFS interface.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	if name != "." {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	ret := make([]fs.DirEntry, len(f.files))
+	for i, v := range f.files {
+		ret[i] = fsFileInfo{v}
+	}
+	return ret, nil
+}
+
+// fsFileInfo implements fs.FileInfo and fs.DirEntry of files in FS.
+type fsFileInfo struct {
+	f fsFile
+}
+
+func (i fsFileInfo) Name() string               { return i.f.name }
+func (i fsFileInfo) Size() int64                { return i.f.size }
+func (i fsFileInfo) Mode() fs.FileMode          { return 0444 }
+func (i fsFileInfo) ModTime() time.Time         { return time.Time{} }
+func (i fsFileInfo) IsDir() bool                { return false }
+func (i fsFileInfo) Sys() interface{}           { return i.f.sys }
+func (i fsFileInfo) Type() fs.FileMode          { return 0 }
+func (i fsFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+// fsOpenFile is an opened file in FS.
+// It also implements io.Seeker and io.ReaderAt.
+type fsOpenFile struct {
+	*io.SectionReader
+	info fsFileInfo
+}
+
+func (f *fsOpenFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsOpenFile) Close() error               { return nil }
+
+// fsDirInfo implements fs.FileInfo of the root directory.
+type fsDirInfo struct{}
+
+func (fsDirInfo) Name() string       { return "." }
+func (fsDirInfo) Size() int64        { return 0 }
+func (fsDirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0555 }
+func (fsDirInfo) ModTime() time.Time { return time.Time{} }
+func (fsDirInfo) IsDir() bool        { return true }
+func (fsDirInfo) Sys() interface{}   { return nil }
+
+// fsDir is the opened root directory of FS.
+type fsDir struct {
+	fs  *FS
+	off int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return fsDirInfo{}, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read(p []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: ".", Err: fs.ErrInvalid}
+}
+
+// ReadDir implements fs.ReadDirFile interface.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.fs.files[d.off:]
+	if n > 0 && len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > 0 && n < len(rest) {
+		rest = rest[:n]
+	}
+	d.off += len(rest)
+
+	ret := make([]fs.DirEntry, len(rest))
+	for i, v := range rest {
+		ret[i] = fsFileInfo{v}
+	}
+	return ret, nil
+}
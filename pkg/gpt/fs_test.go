@@ -0,0 +1,92 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func newSampleFS(t *testing.T) (*gpt.FS, memDisk) {
+	t.Helper()
+	d := readGptSample(t)
+	g, err := gpt.ReadGpt(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	return gpt.NewFS(d, g), d
+}
+
+func TestFS(t *testing.T) {
+	fsys, _ := newSampleFS(t)
+	err := fstest.TestFS(fsys, gpt.FSMbrName, gpt.FSHeaderName, gpt.FSEntriesName, "1-EFI System.img", "2-Linux filesystem.img")
+	if err != nil {
+		t.Errorf("TestFS err:%s", err)
+	}
+}
+
+func TestFSReadFile(t *testing.T) {
+	fsys, d := newSampleFS(t)
+
+	type testcase struct {
+		name   string
+		expect []byte
+	}
+	cases := []testcase{
+		{gpt.FSMbrName, d[:512]},
+		{gpt.FSHeaderName, d[512:1024]},
+		{gpt.FSEntriesName, d[1024 : 1024+128*128]},
+		{"1-EFI System.img", d[34*512 : 36*512]},
+	}
+
+	for _, v := range cases {
+		b, err := fs.ReadFile(fsys, v.name)
+		if err != nil {
+			t.Errorf("%s:ReadFile err:%s", v.name, err)
+			continue
+		}
+		if !bytes.Equal(b, v.expect) {
+			t.Errorf("%s:data mismatch", v.name)
+		}
+	}
+}
+
+func TestFSStat(t *testing.T) {
+	fsys, _ := newSampleFS(t)
+
+	fi, err := fs.Stat(fsys, "1-EFI System.img")
+	if err != nil {
+		t.Fatalf("Stat err:%s", err)
+	}
+	if fi.Size() != 2*512 {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", fi.Size(), 2*512)
+	}
+	info, ok := fi.Sys().(*gpt.PartitionInfo)
+	if !ok {
+		t.Fatalf("Sys() is not PartitionInfo: %T", fi.Sys())
+	}
+	if !info.TypeGuid.Equal(*gpt.EspGuid) || info.Type != "EFI System" {
+		t.Errorf("type mismatch\n given :%s %s\n expect:%s", info.TypeGuid, info.Type, gpt.EspGuid)
+	}
+
+	if _, err := fs.Stat(fsys, "no-such-file"); err == nil {
+		t.Errorf("It should be error")
+	}
+}
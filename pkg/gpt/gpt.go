@@ -86,6 +86,19 @@ func (h Header) IsValid() bool {
 	return c == crc32.ChecksumIEEE(buf.Bytes()[:h.Size])
 }
 
+// UpdateCrc32 calculates and sets Crc32OfHeader.
+func (h *Header) UpdateCrc32() error {
+	h.Crc32OfHeader = 0
+
+	buf := bytes.NewBuffer([]byte{})
+	err := binary.Write(buf, binary.LittleEndian, h)
+	if err != nil {
+		return fmt.Errorf("UpdateCrc32:%w", err)
+	}
+	h.Crc32OfHeader = crc32.ChecksumIEEE(buf.Bytes()[:h.Size])
+	return nil
+}
+
 // Entry represents a partition entries of GPT.
 // ref: https://en.wikipedia.org/wiki/GUID_Partition_Table#Partition_entries_(LBA_2%E2%80%9333)
 type Entry struct {
@@ -97,6 +110,13 @@ type Entry struct {
 	Name       [36]uint16
 }
 
+// Attribute flags of Entry.
+const (
+	AttrPlatformRequired   = uint64(1) << 0
+	AttrNoBlockIOProtocol  = uint64(1) << 1
+	AttrLegacyBIOSBootable = uint64(1) << 2
+)
+
 // ReadEntry reads GPT Entry from r.
 // It returns GPT Entry pointer or error if error occured.
 func ReadEntry(r io.Reader) (*Entry, error) {
@@ -145,12 +165,30 @@ type Gpt struct {
 	Entries       []Entry
 	BackupEntries []Entry
 	BackupHeader  Header
+	SectorSize    int64              // logical sector size in bytes. 0 means 512.
+	Disklabels    map[int]*Disklabel // nested disklabels of BSD partitions. The key is the index of Entries.
 }
 
-// ReadGpt reads GPT from rs.
+// DefaultSectorSize is the logical sector size which is used if it is not specified.
+const DefaultSectorSize = 512
+
+// sectorSize returns the logical sector size of g.
+func (g Gpt) sectorSize() int64 {
+	if g.SectorSize == 0 {
+		return DefaultSectorSize
+	}
+	return g.SectorSize
+}
+
+// ReadGpt reads GPT from rs whose logical sector size is 512.
+// Use ReadGptWithSectorSize for the disk of other sector sizes.
 func ReadGpt(rs io.ReadSeeker) (*Gpt, error) {
-	sectorSize := int64(512)
-	g := &Gpt{}
+	return ReadGptWithSectorSize(rs, DefaultSectorSize)
+}
+
+// ReadGptWithSectorSize reads GPT from rs whose logical sector size is sectorSize.
+func ReadGptWithSectorSize(rs io.ReadSeeker, sectorSize int64) (*Gpt, error) {
+	g := &Gpt{SectorSize: sectorSize}
 
 	rs.Seek(0, io.SeekStart)
 	m, err := ReadMbr(rs)
@@ -196,5 +234,116 @@ func ReadGpt(rs io.ReadSeeker) (*Gpt, error) {
 		g.BackupEntries = append(g.BackupEntries, *e)
 	}
 
+	for i, e := range g.Entries {
+		if e.IsBlank() {
+			continue
+		}
+		if d, err := e.ReadDisklabelWithSectorSize(rs, sectorSize); err == nil {
+			if g.Disklabels == nil {
+				g.Disklabels = map[int]*Disklabel{}
+			}
+			g.Disklabels[i] = d
+		}
+	}
+
+	return g, nil
+}
+
+// Well-known values of GPT Header.
+const (
+	HeaderRevision     = 0x00010000
+	HeaderSize         = 92
+	DefaultNumOfEntry  = 128
+	DefaultSizeOfEntry = 128
+)
+
+// entriesSectors returns the number of sectors of the partition entry array.
+func entriesSectors(numOfEntries, sizeOfEntry uint32, sectorSize int64) uint64 {
+	n := int64(numOfEntries) * int64(sizeOfEntry)
+	return uint64((n + sectorSize - 1) / sectorSize)
+}
+
+// NewGpt returns empty Gpt for the disk which has sectors.
+// DiskGuid is generated randomly.
+// Mbr is a protective MBR.
+func NewGpt(sectors uint64) (*Gpt, error) {
+	sectorSize := int64(512)
+	n := entriesSectors(DefaultNumOfEntry, DefaultSizeOfEntry, sectorSize)
+	if sectors < 3+2*n {
+		return nil, fmt.Errorf("NewGpt:disk is too small. %d sectors", sectors)
+	}
+
+	diskGuid, err := NewRandomGuid()
+	if err != nil {
+		return nil, fmt.Errorf("NewGpt:%w", err)
+	}
+
+	g := &Gpt{SectorSize: sectorSize}
+	g.Mbr = *NewProtectiveMbr(sectors)
+	g.Header = Header{Signature: HeaderSignature, Revision: HeaderRevision, Size: HeaderSize,
+		CurrentLBA: 1, BackupLBA: sectors - 1, FirstUsableLBA: 2 + n, LastUsableLBA: sectors - 2 - n,
+		DiskGuid: *diskGuid, StartingLBA: 2, NumOfEntries: DefaultNumOfEntry, SizeOfEntry: DefaultSizeOfEntry}
+	g.BackupHeader = g.Header
+	g.BackupHeader.CurrentLBA = g.Header.BackupLBA
+	g.BackupHeader.BackupLBA = g.Header.CurrentLBA
+	g.BackupHeader.StartingLBA = sectors - 1 - n
+
+	g.Entries = make([]Entry, DefaultNumOfEntry)
+	g.BackupEntries = make([]Entry, DefaultNumOfEntry)
+
 	return g, nil
 }
+
+// UpdateCrc32 copies Entries to BackupEntries and updates CRC32 of headers and entries.
+func (g *Gpt) UpdateCrc32() error {
+	g.BackupEntries = make([]Entry, len(g.Entries))
+	copy(g.BackupEntries, g.Entries)
+
+	buf := bytes.NewBuffer([]byte{})
+	err := binary.Write(buf, binary.LittleEndian, g.Entries)
+	if err != nil {
+		return fmt.Errorf("UpdateCrc32:%w", err)
+	}
+	c := crc32.ChecksumIEEE(buf.Bytes())
+	g.Header.Crc32OfEntries = c
+	g.BackupHeader.Crc32OfEntries = c
+
+	if err := g.Header.UpdateCrc32(); err != nil {
+		return err
+	}
+	return g.BackupHeader.UpdateCrc32()
+}
+
+// writeAt writes binary representation of data to w at off.
+func writeAt(w io.WriterAt, off int64, data interface{}) error {
+	buf := bytes.NewBuffer([]byte{})
+	err := binary.Write(buf, binary.LittleEndian, data)
+	if err != nil {
+		return err
+	}
+	_, err = w.WriteAt(buf.Bytes(), off)
+	return err
+}
+
+// WriteGpt writes MBR, headers and entries of g to w.
+// It writes only the sectors of them and doesn't touch partitions.
+func WriteGpt(w io.WriterAt, g *Gpt) error {
+	sectorSize := g.sectorSize()
+
+	if err := writeAt(w, 0, &g.Mbr); err != nil {
+		return fmt.Errorf("WriteGpt:%w", err)
+	}
+	if err := writeAt(w, sectorSize*int64(g.Header.StartingLBA), g.Entries); err != nil {
+		return fmt.Errorf("WriteGpt:%w", err)
+	}
+	if err := writeAt(w, sectorSize*int64(g.Header.CurrentLBA), &g.Header); err != nil {
+		return fmt.Errorf("WriteGpt:%w", err)
+	}
+	if err := writeAt(w, sectorSize*int64(g.BackupHeader.StartingLBA), g.BackupEntries); err != nil {
+		return fmt.Errorf("WriteGpt:%w", err)
+	}
+	if err := writeAt(w, sectorSize*int64(g.BackupHeader.CurrentLBA), &g.BackupHeader); err != nil {
+		return fmt.Errorf("WriteGpt:%w", err)
+	}
+	return nil
+}
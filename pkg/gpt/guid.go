@@ -17,21 +17,106 @@
 package gpt
 
 import (
+	"crypto/rand"
 	"fmt"
 )
 
-var EspGuid *Guid
+// Partition type GUIDs.
+// ref: https://en.wikipedia.org/wiki/GUID_Partition_Table#Partition_type_GUIDs
+var (
+	EspGuid                *Guid
+	BiosBootGuid           *Guid
+	MicrosoftReservedGuid  *Guid
+	MicrosoftBasicDataGuid *Guid
+	WindowsRecoveryGuid    *Guid
+	LinuxFilesystemGuid    *Guid
+	LinuxSwapGuid          *Guid
+	LinuxLvmGuid           *Guid
+	LinuxRaidGuid          *Guid
+	FreeBSDBootGuid        *Guid
+	FreeBSDDataGuid        *Guid
+	FreeBSDSwapGuid        *Guid
+	FreeBSDUfsGuid         *Guid
+	FreeBSDZfsGuid         *Guid
+	OpenBSDDataGuid        *Guid
+	NetBSDFfsGuid          *Guid
+	AppleHfsGuid           *Guid
+	AppleUfsGuid           *Guid
+	SolarisBootGuid        *Guid
+	SolarisUsrGuid         *Guid
+	VMwareVmfsGuid         *Guid
+)
 
 // ZeroGuid 00000000-0000-0000-0000-00000000000
 var ZeroGuid *Guid
 
-func init() {
-	var err error
-	EspGuid, err = NewGuidFromString("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+func mustNewGuidFromString(s string) *Guid {
+	g, err := NewGuidFromString(s)
 	if err != nil {
 		panic(err)
 	}
+	return g
+}
+
+func init() {
+	EspGuid = mustNewGuidFromString("C12A7328-F81F-11D2-BA4B-00A0C93EC93B")
+	BiosBootGuid = mustNewGuidFromString("21686148-6449-6E6F-744E-656564454649")
+	MicrosoftReservedGuid = mustNewGuidFromString("E3C9E316-0B5C-4DB8-817D-F92DF00215AE")
+	MicrosoftBasicDataGuid = mustNewGuidFromString("EBD0A0A2-B9E5-4433-87C0-68B6B72699C7")
+	WindowsRecoveryGuid = mustNewGuidFromString("DE94BBA4-06D1-4D40-A16A-BFD50179D6AC")
+	LinuxFilesystemGuid = mustNewGuidFromString("0FC63DAF-8483-4772-8E79-3D69D8477DE4")
+	LinuxSwapGuid = mustNewGuidFromString("0657FD6D-A4AB-43C4-84E5-0933C84B4F4F")
+	LinuxLvmGuid = mustNewGuidFromString("E6D6D379-F507-44C2-A23C-238F2A3DF928")
+	LinuxRaidGuid = mustNewGuidFromString("A19D880F-05FC-4D3B-A006-743F0F84911E")
+	FreeBSDBootGuid = mustNewGuidFromString("83BD6B9D-7F41-11DC-BE0B-001560B84F0F")
+	FreeBSDDataGuid = mustNewGuidFromString("516E7CB4-6ECF-11D6-8FF8-00022D09712B")
+	FreeBSDSwapGuid = mustNewGuidFromString("516E7CB5-6ECF-11D6-8FF8-00022D09712B")
+	FreeBSDUfsGuid = mustNewGuidFromString("516E7CB6-6ECF-11D6-8FF8-00022D09712B")
+	FreeBSDZfsGuid = mustNewGuidFromString("516E7CBA-6ECF-11D6-8FF8-00022D09712B")
+	OpenBSDDataGuid = mustNewGuidFromString("824CC7A0-36A8-11E3-890A-952519AD3F61")
+	NetBSDFfsGuid = mustNewGuidFromString("49F48D5A-B10E-11DC-B99B-0019D1879648")
+	AppleHfsGuid = mustNewGuidFromString("48465300-0000-11AA-AA11-00306543ECAC")
+	AppleUfsGuid = mustNewGuidFromString("55465300-0000-11AA-AA11-00306543ECAC")
+	SolarisBootGuid = mustNewGuidFromString("6A82CB45-1DD2-11B2-99A6-080020736631")
+	SolarisUsrGuid = mustNewGuidFromString("6A898CC3-1DD2-11B2-99A6-080020736631")
+	VMwareVmfsGuid = mustNewGuidFromString("AA31E02A-400F-11DB-9590-000C2911D1B8")
 	ZeroGuid = &Guid{}
+
+	typeGuidNames = map[Guid]string{
+		*EspGuid:                "EFI System",
+		*BiosBootGuid:           "BIOS boot",
+		*MicrosoftReservedGuid:  "Microsoft reserved",
+		*MicrosoftBasicDataGuid: "Microsoft basic data",
+		*WindowsRecoveryGuid:    "Windows recovery environment",
+		*LinuxFilesystemGuid:    "Linux filesystem",
+		*LinuxSwapGuid:          "Linux swap",
+		*LinuxLvmGuid:           "Linux LVM",
+		*LinuxRaidGuid:          "Linux RAID",
+		*FreeBSDBootGuid:        "FreeBSD boot",
+		*FreeBSDDataGuid:        "FreeBSD data",
+		*FreeBSDSwapGuid:        "FreeBSD swap",
+		*FreeBSDUfsGuid:         "FreeBSD UFS",
+		*FreeBSDZfsGuid:         "FreeBSD ZFS",
+		*OpenBSDDataGuid:        "OpenBSD data",
+		*NetBSDFfsGuid:          "NetBSD FFS",
+		*AppleHfsGuid:           "Apple HFS/HFS+",
+		*AppleUfsGuid:           "Apple UFS",
+		*SolarisBootGuid:        "Solaris boot",
+		*SolarisUsrGuid:         "Solaris /usr & Apple ZFS",
+		*VMwareVmfsGuid:         "VMware VMFS",
+	}
+}
+
+// typeGuidNames maps partition type GUIDs to the names of partition types.
+var typeGuidNames map[Guid]string
+
+// TypeString returns the name of partition type if g is a known partition type GUID.
+// It returns "Unknown" if g is not known.
+func (g Guid) TypeString() string {
+	if s, ok := typeGuidNames[g]; ok {
+		return s
+	}
+	return "Unknown"
 }
 
 type Guid [16]byte
@@ -74,3 +159,14 @@ func NewGuidFromString(s string) (*Guid, error) {
 	}
 	return NewGuidFromBytes(b)
 }
+
+// NewRandomGuid returns a random (version 4) guid.
+func NewRandomGuid() (*Guid, error) {
+	g := &Guid{}
+	if _, err := rand.Read(g[:]); err != nil {
+		return nil, fmt.Errorf("NewRandomGuid:%w", err)
+	}
+	g[7] = (g[7] & 0x0f) | 0x40 // version 4
+	g[8] = (g[8] & 0x3f) | 0x80 // variant RFC 4122
+	return g, nil
+}
@@ -47,3 +47,33 @@ func TestNewGuidFromString(t *testing.T) {
 		t.Errorf("string mismatch:\n given :%s\n expect:%s", g, expect)
 	}
 }
+
+func TestNewRandomGuid(t *testing.T) {
+	g, err := gpt.NewRandomGuid()
+	if err != nil {
+		t.Fatalf("NewRandomGuid err:%s", err)
+	}
+	if g.Equal(*gpt.ZeroGuid) {
+		t.Errorf("guid should not be zero")
+	}
+	if s := g.String(); s[14] != '4' {
+		t.Errorf("version mismatch: %s", s)
+	}
+
+	g2, err := gpt.NewRandomGuid()
+	if err != nil {
+		t.Fatalf("NewRandomGuid err:%s", err)
+	}
+	if g.Equal(*g2) {
+		t.Errorf("guids should be unique: %s", g)
+	}
+}
+
+func TestGuidTypeString(t *testing.T) {
+	if s := gpt.EspGuid.TypeString(); s != "EFI System" {
+		t.Errorf("given %s expect %s", s, "EFI System")
+	}
+	if s := gpt.ZeroGuid.TypeString(); s != "Unknown" {
+		t.Errorf("given %s expect %s", s, "Unknown")
+	}
+}
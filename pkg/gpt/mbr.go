@@ -63,6 +63,24 @@ func (c Chs) Cylinder() uint {
 	return uint(c.Body[2]) | (uint(c.Body[1])&0xc0)<<2
 }
 
+// NewChsFromLBA returns Chs from lba.
+// It assumes the geometry is 255 heads and 63 sectors per track.
+// If lba exceeds the limit of Chs, it returns the maximum value.
+func NewChsFromLBA(lba uint64) Chs {
+	const heads = 255
+	const sectors = 63
+
+	c := lba / (heads * sectors)
+	if c > 0x3ff {
+		return Chs{Body: [3]byte{0xfe, 0xff, 0xff}}
+	}
+	h := (lba / sectors) % heads
+	s := (lba % sectors) + 1
+
+	ret, _ := NewChs(uint(h), uint(s), uint(c))
+	return *ret
+}
+
 // String implements fmt.Stringer interface
 func (c Chs) String() string {
 	return fmt.Sprintf("{cylinder:0x%x head:0x%x sector:0x%x}", c.Cylinder(), c.Head(), c.Sector())
@@ -78,16 +96,130 @@ type MbrEntry struct {
 	AllLBA   uint32
 }
 
-func (m MbrEntry) IdString() string {
+// IsExtended reports whether m is an extended partition.
+func (m MbrEntry) IsExtended() bool {
 	switch m.Id {
-	case 0x82:
-		return "Linux Swap"
-	case 0x83:
-		return "Linux"
-	case 0xee:
-		return "GPT"
-	case 0xef:
-		return "ESP"
+	case 0x05, 0x0f, 0x85:
+		return true
+	}
+	return false
+}
+
+// IsBlank reports whether m is not used.
+func (m MbrEntry) IsBlank() bool {
+	return m.Id == 0 || m.AllLBA == 0
+}
+
+// mbrIdNames maps partition IDs to the names of partition types.
+// ref: https://en.wikipedia.org/wiki/Partition_type
+var mbrIdNames = map[byte]string{
+	0x00: "Empty",
+	0x01: "FAT12",
+	0x02: "XENIX root",
+	0x03: "XENIX usr",
+	0x04: "FAT16 <32M",
+	0x05: "Extended",
+	0x06: "FAT16",
+	0x07: "HPFS/NTFS/exFAT",
+	0x08: "AIX",
+	0x09: "AIX bootable",
+	0x0a: "OS/2 Boot Manager",
+	0x0b: "W95 FAT32",
+	0x0c: "W95 FAT32 (LBA)",
+	0x0e: "W95 FAT16 (LBA)",
+	0x0f: "W95 Extended (LBA)",
+	0x10: "OPUS",
+	0x11: "Hidden FAT12",
+	0x12: "Compaq diagnostics",
+	0x14: "Hidden FAT16 <32M",
+	0x16: "Hidden FAT16",
+	0x17: "Hidden HPFS/NTFS",
+	0x18: "AST SmartSleep",
+	0x1b: "Hidden W95 FAT32",
+	0x1c: "Hidden W95 FAT32 (LBA)",
+	0x1e: "Hidden W95 FAT16 (LBA)",
+	0x24: "NEC DOS",
+	0x27: "Hidden NTFS WinRE",
+	0x39: "Plan 9",
+	0x3c: "PartitionMagic recovery",
+	0x40: "Venix 80286",
+	0x41: "PPC PReP Boot",
+	0x42: "SFS",
+	0x4d: "QNX4.x",
+	0x4e: "QNX4.x 2nd part",
+	0x4f: "QNX4.x 3rd part",
+	0x50: "OnTrack DM",
+	0x51: "OnTrack DM6 Aux1",
+	0x52: "CP/M",
+	0x53: "OnTrack DM6 Aux3",
+	0x54: "OnTrackDM6",
+	0x55: "EZ-Drive",
+	0x56: "Golden Bow",
+	0x5c: "Priam Edisk",
+	0x61: "SpeedStor",
+	0x63: "GNU HURD or SysV",
+	0x64: "Novell Netware 286",
+	0x65: "Novell Netware 386",
+	0x70: "DiskSecure Multi-Boot",
+	0x75: "PC/IX",
+	0x80: "Old Minix",
+	0x81: "Minix / old Linux",
+	0x82: "Linux Swap",
+	0x83: "Linux",
+	0x84: "OS/2 hidden C: drive",
+	0x85: "Linux extended",
+	0x86: "NTFS volume set",
+	0x87: "NTFS volume set",
+	0x88: "Linux plaintext",
+	0x8e: "Linux LVM",
+	0x93: "Amoeba",
+	0x94: "Amoeba BBT",
+	0x9f: "BSD/OS",
+	0xa0: "IBM Thinkpad hibernation",
+	0xa5: "FreeBSD",
+	0xa6: "OpenBSD",
+	0xa7: "NeXTSTEP",
+	0xa8: "Darwin UFS",
+	0xa9: "NetBSD",
+	0xab: "Darwin boot",
+	0xaf: "HFS / HFS+",
+	0xb7: "BSDI fs",
+	0xb8: "BSDI swap",
+	0xbb: "Boot Wizard hidden",
+	0xbc: "Acronis FAT32 LBA",
+	0xbe: "Solaris boot",
+	0xbf: "Solaris",
+	0xc1: "DRDOS/sec (FAT-12)",
+	0xc4: "DRDOS/sec (FAT-16 < 32M)",
+	0xc6: "DRDOS/sec (FAT-16)",
+	0xc7: "Syrinx",
+	0xda: "Non-FS data",
+	0xdb: "CP/M / CTOS / ...",
+	0xde: "Dell Utility",
+	0xdf: "BootIt",
+	0xe1: "DOS access",
+	0xe3: "DOS R/O",
+	0xe4: "SpeedStor",
+	0xea: "Linux extended boot",
+	0xeb: "BeOS fs",
+	0xee: "GPT",
+	0xef: "ESP",
+	0xf0: "Linux/PA-RISC boot",
+	0xf1: "SpeedStor",
+	0xf2: "DOS secondary",
+	0xf4: "SpeedStor",
+	0xfb: "VMware VMFS",
+	0xfc: "VMware VMKCORE",
+	0xfd: "Linux raid autodetect",
+	0xfe: "LANstep",
+	0xff: "BBT",
+}
+
+// IdString returns the name of partition type.
+// It returns "Unknown" if the Id is not known.
+func (m MbrEntry) IdString() string {
+	if s, ok := mbrIdNames[m.Id]; ok {
+		return s
 	}
 	return "Unknown"
 }
@@ -95,9 +227,11 @@ func (m MbrEntry) IdString() string {
 // Mbr represents entier MBR.
 // refs: https://en.wikipedia.org/wiki/Master_boot_record
 type Mbr struct {
-	BootCode  [446]byte
-	Entries   [4]MbrEntry
-	Signature uint16
+	BootCode      [440]byte
+	DiskSignature uint32 // Windows uses it to identify disks.
+	CopyProtect   uint16 // 0x5a5a if copy-protected.
+	Entries       [4]MbrEntry
+	Signature     uint16
 }
 
 // ReadMbr reads MBR from r.
@@ -110,6 +244,20 @@ func ReadMbr(r io.Reader) (*Mbr, error) {
 	return m, nil
 }
 
+// NewProtectiveMbr returns protective MBR for the disk which has sectors.
+func NewProtectiveMbr(sectors uint64) *Mbr {
+	m := &Mbr{Signature: 0xaa55}
+	all := sectors - 1
+	if all > 0xffffffff {
+		all = 0xffffffff
+	}
+	m.Entries[0] = MbrEntry{FirstChs: NewChsFromLBA(1), Id: 0xee, LastChs: NewChsFromLBA(sectors - 1), FirstLBA: 1, AllLBA: uint32(all)}
+	if sectors-1 >= 1024*255*63 {
+		m.Entries[0].LastChs = Chs{Body: [3]byte{0xff, 0xff, 0xff}}
+	}
+	return m
+}
+
 // IsValid check if the Mbr is valid.
 //  The signature is 0xaa55
 func (m Mbr) IsValid() bool {
@@ -118,3 +266,76 @@ func (m Mbr) IsValid() bool {
 	}
 	return true
 }
+
+// maxLogicalEntries limits the length of EBR chain to avoid looping forever.
+const maxLogicalEntries = 128
+
+// ReadLogicalEntries reads logical partitions from the extended partition of m.
+// It follows the chain of EBR(Extended Boot Record).
+// FirstLBA of returned entries is relative to the beginning of the disk.
+// It returns nil if m has no extended partition.
+func ReadLogicalEntries(rs io.ReadSeeker, m Mbr) ([]MbrEntry, error) {
+	sectorSize := int64(512)
+
+	var ext *MbrEntry
+	for i := range m.Entries {
+		if m.Entries[i].IsExtended() {
+			ext = &m.Entries[i]
+			break
+		}
+	}
+	if ext == nil {
+		return nil, nil
+	}
+
+	ret := []MbrEntry{}
+	next := uint64(0)
+	for i := 0; i < maxLogicalEntries; i++ {
+		lba := uint64(ext.FirstLBA) + next
+		rs.Seek(sectorSize*int64(lba), io.SeekStart)
+		ebr, err := ReadMbr(rs)
+		if err != nil {
+			return nil, fmt.Errorf("ReadLogicalEntries:%w", err)
+		}
+		if !ebr.IsValid() {
+			return nil, fmt.Errorf("ReadLogicalEntries:invalid EBR at LBA %d", lba)
+		}
+
+		e := ebr.Entries[0]
+		if !e.IsBlank() {
+			e.FirstLBA += uint32(lba)
+			ret = append(ret, e)
+		}
+
+		link := ebr.Entries[1]
+		if !link.IsExtended() || link.FirstLBA == 0 {
+			return ret, nil
+		}
+		next = uint64(link.FirstLBA)
+	}
+	return nil, fmt.Errorf("ReadLogicalEntries:too many logical partitions")
+}
+
+// WriteLogicalEntries writes the chain of EBR for logicals to w.
+// ext is the extended partition and FirstLBA of logicals is relative to the beginning of the disk.
+// Each EBR is placed at the sector before the logical partition.
+func WriteLogicalEntries(w io.WriterAt, ext MbrEntry, logicals []MbrEntry) error {
+	sectorSize := int64(512)
+
+	for i, e := range logicals {
+		ebrLBA := e.FirstLBA - 1
+		ebr := &Mbr{Signature: 0xaa55}
+		ebr.Entries[0] = e
+		ebr.Entries[0].FirstLBA = e.FirstLBA - ebrLBA
+		if i+1 < len(logicals) {
+			n := logicals[i+1]
+			first := uint64(n.FirstLBA - 1)
+			last := uint64(n.FirstLBA) + uint64(n.AllLBA) - 1
+			ebr.Entries[1] = MbrEntry{FirstChs: NewChsFromLBA(first), Id: 0x05, LastChs: NewChsFromLBA(last), FirstLBA: uint32(first) - ext.FirstLBA, AllLBA: n.AllLBA + 1}
+		}
+		if err := writeAt(w, sectorSize*int64(ebrLBA), ebr); err != nil {
+			return fmt.Errorf("WriteLogicalEntries:%w", err)
+		}
+	}
+	return nil
+}
@@ -80,3 +80,48 @@ func TestMbrIsValid(t *testing.T) {
 		t.Errorf("It should be invalid. signature is 0")
 	}
 }
+
+func TestMbrDiskSignature(t *testing.T) {
+	m, err := readMbr(t)
+	if err != nil {
+		t.Fatalf("readMbr: %s", err)
+	}
+
+	expect := uint32(0x667e292a)
+	if m.DiskSignature != expect {
+		t.Errorf("disk signature mismatch.\n given :0x%x\n expect:0x%x", m.DiskSignature, expect)
+	}
+	if m.Entries[0].Id != 0x83 {
+		t.Errorf("Id mismatch.\n given :0x%x\n expect:0x%x", m.Entries[0].Id, 0x83)
+	}
+	if !m.IsValid() {
+		t.Errorf("It should be valid")
+	}
+}
+
+func TestMbrEntryIdString(t *testing.T) {
+	type testcase struct {
+		id     byte
+		expect string
+	}
+
+	cases := []testcase{
+		{0x07, "HPFS/NTFS/exFAT"},
+		{0x0c, "W95 FAT32 (LBA)"},
+		{0x0f, "W95 Extended (LBA)"},
+		{0x83, "Linux"},
+		{0x8e, "Linux LVM"},
+		{0xa5, "FreeBSD"},
+		{0xbf, "Solaris"},
+		{0xee, "GPT"},
+		{0xfd, "Linux raid autodetect"},
+		{0x5f, "Unknown"},
+	}
+
+	for _, v := range cases {
+		e := gpt.MbrEntry{Id: v.id}
+		if e.IdString() != v.expect {
+			t.Errorf("0x%x:given %s expect %s", v.id, e.IdString(), v.expect)
+		}
+	}
+}
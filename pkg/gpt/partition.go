@@ -0,0 +1,151 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrOutOfPartition is returned if the access is out of the partition.
+var ErrOutOfPartition = errors.New("out of partition")
+
+// ErrPartitionNotFound is returned if there is no such partition.
+var ErrPartitionNotFound = errors.New("partition not found")
+
+// SectionWriter implements io.WriterAt on a section of an underlying io.WriterAt.
+// It refuses to write outside of the section.
+type SectionWriter struct {
+	w    io.WriterAt
+	base int64
+	size int64
+}
+
+// NewSectionWriter returns a SectionWriter that writes to w starting at offset off and stops with ErrOutOfPartition after n bytes.
+func NewSectionWriter(w io.WriterAt, off int64, n int64) *SectionWriter {
+	return &SectionWriter{w: w, base: off, size: n}
+}
+
+// WriteAt implements io.WriterAt interface.
+// It writes nothing and returns ErrOutOfPartition if p doesn't fit in the section.
+func (s *SectionWriter) WriteAt(p []byte, off int64) (int, error) {
+	if off < 0 || off > s.size || int64(len(p)) > s.size-off {
+		return 0, ErrOutOfPartition
+	}
+	return s.w.WriteAt(p, s.base+off)
+}
+
+// Size returns the size of the section in bytes.
+func (s *SectionWriter) Size() int64 {
+	return s.size
+}
+
+// Open returns the reader of the partition p.
+func (p Partition) Open(r io.ReaderAt) *io.SectionReader {
+	return io.NewSectionReader(r, int64(p.Start), int64(p.Size))
+}
+
+// FindEntryByName returns the index of the first entry whose name is name.
+func (g Gpt) FindEntryByName(name string) (int, error) {
+	for i, e := range g.Entries {
+		if !e.IsBlank() && e.ReadName() == name {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("FindEntryByName:%w. %q", ErrPartitionNotFound, name)
+}
+
+// FindEntryByGuid returns the index of the entry whose UniqueGuid is u.
+func (g Gpt) FindEntryByGuid(u Guid) (int, error) {
+	for i, e := range g.Entries {
+		if !e.IsBlank() && e.UniqueGuid.Equal(u) {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("FindEntryByGuid:%w. %s", ErrPartitionNotFound, u)
+}
+
+// section returns the offset and the size in bytes of the partition at index i of Entries.
+func (g Gpt) section(i int) (int64, int64, error) {
+	if i < 0 || i >= len(g.Entries) || g.Entries[i].IsBlank() {
+		return 0, 0, fmt.Errorf("%w. index=%d", ErrPartitionNotFound, i)
+	}
+	e := g.Entries[i]
+	if e.LastLBA < e.FirstLBA {
+		return 0, 0, fmt.Errorf("invalid LBA. FirstLBA=%d LastLBA=%d", e.FirstLBA, e.LastLBA)
+	}
+	sectorSize := g.sectorSize()
+	// LastLBA is inclusive.
+	return int64(e.FirstLBA) * sectorSize, int64(e.LastLBA-e.FirstLBA+1) * sectorSize, nil
+}
+
+// OpenPartition returns the reader of the partition at index i of Entries.
+// Index starts from 0.
+func (g Gpt) OpenPartition(r io.ReaderAt, i int) (*io.SectionReader, error) {
+	off, n, err := g.section(i)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPartition:%w", err)
+	}
+	return io.NewSectionReader(r, off, n), nil
+}
+
+// OpenPartitionByName returns the reader of the partition whose name is name.
+func (g Gpt) OpenPartitionByName(r io.ReaderAt, name string) (*io.SectionReader, error) {
+	i, err := g.FindEntryByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPartitionByName:%w", err)
+	}
+	return g.OpenPartition(r, i)
+}
+
+// OpenPartitionByGuid returns the reader of the partition whose UniqueGuid is u.
+func (g Gpt) OpenPartitionByGuid(r io.ReaderAt, u Guid) (*io.SectionReader, error) {
+	i, err := g.FindEntryByGuid(u)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPartitionByGuid:%w", err)
+	}
+	return g.OpenPartition(r, i)
+}
+
+// OpenPartitionWriter returns the writer of the partition at index i of Entries.
+// Index starts from 0.
+func (g Gpt) OpenPartitionWriter(w io.WriterAt, i int) (*SectionWriter, error) {
+	off, n, err := g.section(i)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPartitionWriter:%w", err)
+	}
+	return NewSectionWriter(w, off, n), nil
+}
+
+// OpenPartitionWriterByName returns the writer of the partition whose name is name.
+func (g Gpt) OpenPartitionWriterByName(w io.WriterAt, name string) (*SectionWriter, error) {
+	i, err := g.FindEntryByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPartitionWriterByName:%w", err)
+	}
+	return g.OpenPartitionWriter(w, i)
+}
+
+// OpenPartitionWriterByGuid returns the writer of the partition whose UniqueGuid is u.
+func (g Gpt) OpenPartitionWriterByGuid(w io.WriterAt, u Guid) (*SectionWriter, error) {
+	i, err := g.FindEntryByGuid(u)
+	if err != nil {
+		return nil, fmt.Errorf("OpenPartitionWriterByGuid:%w", err)
+	}
+	return g.OpenPartitionWriter(w, i)
+}
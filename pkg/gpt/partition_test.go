@@ -0,0 +1,160 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestOpenPartition(t *testing.T) {
+	d := readGptSample(t)
+	g, err := gpt.ReadGpt(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+
+	type testcase struct {
+		name string
+		open func() (*io.SectionReader, error)
+	}
+	cases := []testcase{
+		{"index", func() (*io.SectionReader, error) { return g.OpenPartition(d, 1) }},
+		{"name", func() (*io.SectionReader, error) { return g.OpenPartitionByName(d, "Linux filesystem") }},
+		{"guid", func() (*io.SectionReader, error) { return g.OpenPartitionByGuid(d, g.Entries[1].UniqueGuid) }},
+	}
+
+	for _, v := range cases {
+		r, err := v.open()
+		if err != nil {
+			t.Errorf("%s:open err:%s", v.name, err)
+			continue
+		}
+		// LastLBA is inclusive
+		if r.Size() != 3*512 {
+			t.Errorf("%s:size mismatch\n given :%d\n expect:%d", v.name, r.Size(), 3*512)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Errorf("%s:ReadAll err:%s", v.name, err)
+		}
+		if !bytes.Equal(b, d[36*512:39*512]) {
+			t.Errorf("%s:data mismatch", v.name)
+		}
+		if n, err := r.ReadAt(make([]byte, 2), r.Size()-1); n != 1 || err != io.EOF {
+			t.Errorf("%s:read out of partition. n=%d err=%v", v.name, n, err)
+		}
+	}
+
+	if _, err := g.OpenPartition(d, 100); !errors.Is(err, gpt.ErrPartitionNotFound) {
+		t.Errorf("blank entry:given %v expect %s", err, gpt.ErrPartitionNotFound)
+	}
+	if _, err := g.OpenPartitionByName(d, "no such name"); !errors.Is(err, gpt.ErrPartitionNotFound) {
+		t.Errorf("unknown name:given %v expect %s", err, gpt.ErrPartitionNotFound)
+	}
+}
+
+func TestOpenPartitionWriter(t *testing.T) {
+	d := readGptSample(t)
+	g, err := gpt.ReadGpt(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	orig := append([]byte{}, d...)
+
+	w, err := g.OpenPartitionWriterByName(d, "EFI System")
+	if err != nil {
+		t.Fatalf("OpenPartitionWriterByName err:%s", err)
+	}
+	if _, err := w.WriteAt([]byte{1, 2}, w.Size()-2); err != nil {
+		t.Errorf("WriteAt err:%s", err)
+	}
+	if d[36*512-1] != 2 {
+		t.Errorf("data is not written")
+	}
+	if n, err := w.WriteAt([]byte{1, 2}, w.Size()-1); n != 0 || !errors.Is(err, gpt.ErrOutOfPartition) {
+		t.Errorf("write out of partition. n=%d err=%v", n, err)
+	}
+	if _, err := w.WriteAt([]byte{1}, -1); !errors.Is(err, gpt.ErrOutOfPartition) {
+		t.Errorf("write negative offset. err=%v", err)
+	}
+	if !bytes.Equal(orig[36*512:], d[36*512:]) || !bytes.Equal(orig[:34*512], d[:34*512]) {
+		t.Errorf("data out of partition is modified")
+	}
+}
+
+func TestReadGpt4K(t *testing.T) {
+	const sectorSize = 4096
+	d := make(memDisk, testDiskSectors*sectorSize)
+	g := newTestGpt(t, 2)
+	g.SectorSize = sectorSize
+	g.Entries[1].TypeGuid = *gpt.FreeBSDDataGuid
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	if err := gpt.WriteGpt(d, g); err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+	putFatBootSector(d[g.Entries[0].FirstLBA*sectorSize:], 200, false)
+	// putDisklabel writes at the 512 bytes sector next to lba.
+	d.putDisklabel(t, int64(g.Entries[1].FirstLBA+1)*(sectorSize/512)-1, []gpt.DisklabelPartition{{Size: 128, Offset: 16, FsType: 7}})
+
+	if _, err := gpt.ReadGpt(bytes.NewReader(d)); err == nil {
+		t.Errorf("ReadGpt should be error for 4Kn disk")
+	}
+	g, err := gpt.ReadGptWithSectorSize(bytes.NewReader(d), sectorSize)
+	if err != nil {
+		t.Fatalf("ReadGptWithSectorSize err:%s", err)
+	}
+	if g.SectorSize != sectorSize {
+		t.Errorf("SectorSize mismatch\n given :%d\n expect:%d", g.SectorSize, sectorSize)
+	}
+	r, err := g.OpenPartition(d, 1)
+	if err != nil {
+		t.Fatalf("OpenPartition err:%s", err)
+	}
+	expect := int64(g.Entries[1].LastLBA-g.Entries[1].FirstLBA+1) * sectorSize
+	if r.Size() != expect {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", r.Size(), expect)
+	}
+
+	l, ok := g.Disklabels[1]
+	if !ok {
+		t.Fatalf("disklabel is not found")
+	}
+	if ps := l.Partitions(); len(ps) != 1 || ps[0].Start != (g.Entries[1].FirstLBA+16)*sectorSize {
+		t.Errorf("disklabel partition mismatch: %+v", ps)
+	}
+
+	j := gpt.NewRGpt(*g)
+	j.ProbeFilesystems(d)
+	if fs := j.Entries[0].Filesystem; fs == nil || fs.Version != "FAT12" {
+		t.Errorf("Filesystem mismatch\n given :%+v\n expect:%s", fs, "FAT12")
+	}
+
+	pt, err := gpt.ReadPartitionTable(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadPartitionTable err:%s", err)
+	}
+	if ps := pt.Partitions(); len(ps) != 2 || ps[1].Start != g.Entries[1].FirstLBA*sectorSize {
+		t.Errorf("partitions mismatch: %+v", ps)
+	}
+}
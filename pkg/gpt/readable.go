@@ -16,6 +16,10 @@
 
 package gpt
 
+import (
+	"io"
+)
+
 // RHeader represents Header for human readable format.
 //   DiskGuid is string type.
 type RHeader struct {
@@ -44,6 +48,8 @@ func NewRHeader(h Header) *RHeader {
 
 // REntry represents Entry for human readable format.
 //  TypeGuid/UniqueGuid/Name are string type.
+//  Filesystem is set only if probed.
+//  Disklabel is set only if the entry has nested BSD disklabel.
 type REntry struct {
 	TypeGuid   string
 	UniqueGuid string
@@ -51,6 +57,8 @@ type REntry struct {
 	LastLBA    uint64
 	AttrFlags  uint64
 	Name       string
+	Filesystem *Filesystem `json:",omitempty"`
+	Disklabel  *RDisklabel `json:",omitempty"`
 }
 
 func NewREntry(e Entry) *REntry {
@@ -74,13 +82,17 @@ func NewRChs(c Chs) *RChs {
 }
 
 // RMbrEntry represents MbrEntry for human readable format.
+//  Filesystem is set only if probed.
+//  Disklabel is set only if the slice has nested BSD disklabel.
 type RMbrEntry struct {
-	BootFlag byte
-	FirstChs RChs
-	Id       byte
-	LastChs  RChs
-	FirstLBA uint32
-	AllLBA   uint32
+	BootFlag   byte
+	FirstChs   RChs
+	Id         byte
+	LastChs    RChs
+	FirstLBA   uint32
+	AllLBA     uint32
+	Filesystem *Filesystem `json:",omitempty"`
+	Disklabel  *RDisklabel `json:",omitempty"`
 }
 
 func NewRMbrEntry(m MbrEntry) *RMbrEntry {
@@ -95,12 +107,13 @@ func NewRMbrEntry(m MbrEntry) *RMbrEntry {
 
 // RMbr represents Mbr for human readable format.
 type RMbr struct {
-	Entries   [4]RMbrEntry
-	Signature uint16
+	DiskSignature uint32
+	Entries       [4]RMbrEntry
+	Signature     uint16
 }
 
 func NewRMbr(m Mbr) *RMbr {
-	ret := &RMbr{Signature: m.Signature}
+	ret := &RMbr{DiskSignature: m.DiskSignature, Signature: m.Signature}
 	for i := 0; i < 4; i++ {
 		e := NewRMbrEntry(m.Entries[i])
 		ret.Entries[i] = *e
@@ -108,6 +121,63 @@ func NewRMbr(m Mbr) *RMbr {
 	return ret
 }
 
+// RMbrTable represents MbrTable for human readable format.
+type RMbrTable struct {
+	Mbr      RMbr
+	Logicals []RMbrEntry
+}
+
+func NewRMbrTable(t MbrTable) *RMbrTable {
+	ret := &RMbrTable{}
+	m := NewRMbr(t.Mbr)
+	ret.Mbr = *m
+
+	ret.Logicals = []RMbrEntry{}
+	for _, v := range t.Logicals {
+		e := NewRMbrEntry(v)
+		ret.Logicals = append(ret.Logicals, *e)
+	}
+
+	for i, d := range t.Disklabels {
+		if i < len(ret.Mbr.Entries) {
+			ret.Mbr.Entries[i].Disklabel = NewRDisklabel(*d)
+		} else if i-len(ret.Mbr.Entries) < len(ret.Logicals) {
+			ret.Logicals[i-len(ret.Mbr.Entries)].Disklabel = NewRDisklabel(*d)
+		}
+	}
+	return ret
+}
+
+// RDisklabelPartition represents DisklabelPartition for human readable format.
+//  FirstLBA is relative to the beginning of the disk.
+//  FsType is string type.
+type RDisklabelPartition struct {
+	FirstLBA uint64
+	Size     uint32
+	Offset   uint32
+	FsType   string
+}
+
+// RDisklabel represents Disklabel for human readable format.
+//  Entries are keyed by the letter of sub-partition. e.g. "a".
+type RDisklabel struct {
+	SliceLBA  uint64
+	SliceSize uint64
+	Entries   map[string]RDisklabelPartition
+}
+
+func NewRDisklabel(d Disklabel) *RDisklabel {
+	ret := &RDisklabel{SliceLBA: d.SliceLBA, SliceSize: d.SliceSize}
+	ret.Entries = make(map[string]RDisklabelPartition)
+	for i, p := range d.Entries {
+		if p.IsBlank() {
+			continue
+		}
+		ret.Entries[string(rune('a'+i))] = RDisklabelPartition{FirstLBA: d.PartitionLBA(i), Size: p.Size, Offset: p.Offset, FsType: p.FsTypeString()}
+	}
+	return ret
+}
+
 // RMbr represents RGpt for human readable format.
 type RGpt struct {
 	Mbr           RMbr
@@ -115,10 +185,11 @@ type RGpt struct {
 	Entries       map[uint]REntry
 	BackupEntries map[uint]REntry
 	BackupHeader  RHeader
+	SectorSize    int64
 }
 
 func NewRGpt(g Gpt) *RGpt {
-	ret := &RGpt{}
+	ret := &RGpt{SectorSize: g.sectorSize()}
 	m := NewRMbr(g.Mbr)
 	ret.Mbr = *m
 
@@ -132,6 +203,9 @@ func NewRGpt(g Gpt) *RGpt {
 	for i, v := range g.Entries {
 		if !v.IsBlank() {
 			e := NewREntry(g.Entries[i])
+			if d, ok := g.Disklabels[i]; ok {
+				e.Disklabel = NewRDisklabel(*d)
+			}
 			ret.Entries[uint(i)] = *e
 		}
 	}
@@ -146,3 +220,80 @@ func NewRGpt(g Gpt) *RGpt {
 
 	return ret
 }
+
+// ProbeFilesystems probes filesystems of entries and MBR partitions from r.
+// Blank, extended and protective MBR partitions are skipped.
+func (rg *RGpt) ProbeFilesystems(r io.ReaderAt) {
+	sectorSize := rg.SectorSize
+	if sectorSize == 0 {
+		sectorSize = DefaultSectorSize
+	}
+	for i, v := range rg.Entries {
+		e := Entry{FirstLBA: v.FirstLBA, LastLBA: v.LastLBA}
+		if fs, err := e.ProbeFilesystemWithSectorSize(r, sectorSize); err == nil {
+			v.Filesystem = fs
+			rg.Entries[i] = v
+		}
+	}
+	for i := range rg.Mbr.Entries {
+		rg.Mbr.Entries[i].probeFilesystem(r, sectorSize)
+	}
+}
+
+// probeFilesystem probes the filesystem of the partition of re from r.
+// Blank, extended and protective MBR partitions are skipped.
+func (re *RMbrEntry) probeFilesystem(r io.ReaderAt, sectorSize int64) {
+	m := MbrEntry{Id: re.Id, FirstLBA: re.FirstLBA, AllLBA: re.AllLBA}
+	if m.IsBlank() || m.IsExtended() || m.Id == 0xee {
+		return
+	}
+	if fs, err := m.ProbeFilesystemWithSectorSize(r, sectorSize); err == nil {
+		re.Filesystem = fs
+	}
+}
+
+// ProbeFilesystems probes filesystems of primary and logical partitions from r.
+// Blank and extended partitions are skipped.
+func (rt *RMbrTable) ProbeFilesystems(r io.ReaderAt) {
+	for i := range rt.Mbr.Entries {
+		rt.Mbr.Entries[i].probeFilesystem(r, DefaultSectorSize)
+	}
+	for i := range rt.Logicals {
+		rt.Logicals[i].probeFilesystem(r, DefaultSectorSize)
+	}
+}
+
+// RApmEntry represents ApmEntry for human readable format.
+//  Name/Type are string type.
+type RApmEntry struct {
+	MapBlockCount uint32
+	PhysStart     uint32
+	BlockCount    uint32
+	Name          string
+	Type          string
+	LogDataStart  uint32
+	DataCount     uint32
+	Status        uint32
+}
+
+func NewRApmEntry(e ApmEntry) *RApmEntry {
+	return &RApmEntry{MapBlockCount: e.MapBlockCount, PhysStart: e.PhysStart, BlockCount: e.BlockCount,
+		Name: e.ReadName(), Type: e.ReadType(), LogDataStart: e.LogDataStart, DataCount: e.DataCount, Status: e.Status}
+}
+
+// RApm represents Apm for human readable format.
+type RApm struct {
+	BlockSize  uint32
+	BlockCount uint32
+	Entries    map[uint]RApmEntry
+}
+
+func NewRApm(a Apm) *RApm {
+	ret := &RApm{BlockSize: a.BlockSize, BlockCount: a.Ddm.BlockCount}
+	ret.Entries = make(map[uint]RApmEntry)
+	for i, v := range a.Entries {
+		e := NewRApmEntry(v)
+		ret.Entries[uint(i)] = *e
+	}
+	return ret
+}
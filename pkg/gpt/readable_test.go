@@ -17,6 +17,7 @@
 package gpt_test
 
 import (
+	"bytes"
 	"github.com/nokute78/go-gpt/pkg/gpt"
 	"strings"
 	"testing"
@@ -33,3 +34,46 @@ func TestREntryName(t *testing.T) {
 		t.Errorf("j.Name mismatch\n given :%s expect:%s", j.Name, "EFI System")
 	}
 }
+
+func TestRGptProbeFilesystems(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 2)
+	putFatBootSector(d[g.Entries[0].FirstLBA*512:], 200, false)
+
+	j := gpt.NewRGpt(*g)
+	j.ProbeFilesystems(d)
+	if fs := j.Entries[0].Filesystem; fs == nil || fs.Version != "FAT12" {
+		t.Errorf("Filesystem mismatch\n given :%+v\n expect:%s", fs, "FAT12")
+	}
+	if fs := j.Entries[1].Filesystem; fs != nil {
+		t.Errorf("Filesystem should be nil: %+v", fs)
+	}
+}
+
+func TestRMbrTableProbeFilesystems(t *testing.T) {
+	d := newMbrDisk(t)
+	putFatBootSector(d[2048*512:], 200, false)
+	putFatBootSector(d[(4096+64)*512:], 200, false)
+
+	tb, err := gpt.ReadMbrTable(bytes.NewReader(d))
+	if err != nil {
+		t.Fatalf("ReadMbrTable err:%s", err)
+	}
+	j := gpt.NewRMbrTable(*tb)
+	j.ProbeFilesystems(d)
+	if fs := j.Mbr.Entries[0].Filesystem; fs == nil || fs.Version != "FAT12" {
+		t.Errorf("primary:Filesystem mismatch\n given :%+v\n expect:%s", fs, "FAT12")
+	}
+	if fs := j.Mbr.Entries[1].Filesystem; fs != nil {
+		t.Errorf("extended:Filesystem should be nil: %+v", fs)
+	}
+	if len(j.Logicals) != 2 {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(j.Logicals), 2)
+	}
+	if fs := j.Logicals[0].Filesystem; fs == nil || fs.Version != "FAT12" {
+		t.Errorf("logical:Filesystem mismatch\n given :%+v\n expect:%s", fs, "FAT12")
+	}
+	if fs := j.Logicals[1].Filesystem; fs != nil {
+		t.Errorf("logical:Filesystem should be nil: %+v", fs)
+	}
+}
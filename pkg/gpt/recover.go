@@ -0,0 +1,373 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/nokute78/go-gpt/pkg/fat"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"sort"
+)
+
+// Sources of RecoveryCandidate.
+const (
+	SourceHeader     = "header"     // entries referred by a "EFI PART" header.
+	SourceEntries    = "entries"    // entries without a header.
+	SourceFilesystem = "filesystem" // a filesystem superblock.
+)
+
+// Confidence of RecoveryCandidate.
+const (
+	confidenceValidHeader  = 90 // both CRC32 of the header and entries are valid.
+	confidenceBrokenEntry  = 60 // CRC32 of the header is valid but entries are broken.
+	confidenceBrokenHeader = 40 // CRC32 of the header is invalid.
+	confidenceEntries      = 50
+	confidenceFsWithSize   = 50
+	confidenceFs           = 30
+	confidenceFsBonus      = 10 // a filesystem is found at the start of the entry.
+)
+
+// ScanOptions represents options of ScanLostPartitions.
+type ScanOptions struct {
+	SectorSize int64  // 0 means 512.
+	Alignment  uint64 // filesystems are probed at every Alignment sectors. 0 means 1MiB.
+}
+
+// RecoveryCandidate represents a partition found by ScanLostPartitions.
+// Confidence is from 0 to 100.
+type RecoveryCandidate struct {
+	Entry      Entry
+	Source     string
+	FoundLBA   uint64 // LBA of the header, entries or filesystem.
+	Confidence int
+	Filesystem *Filesystem `json:",omitempty"`
+}
+
+// ScanResult represents the result of ScanLostPartitions.
+type ScanResult struct {
+	Candidates []RecoveryCandidate
+	DiskGuid   *Guid `json:",omitempty"` // DiskGuid of the valid header if found.
+	SectorSize int64
+	Sectors    uint64
+}
+
+// ScanLostPartitions scans r whose size is size to find lost partitions.
+// It searches "EFI PART" headers and partition entry arrays at any LBA
+// and filesystem superblocks at every opt.Alignment sectors.
+func ScanLostPartitions(r io.ReaderAt, size int64, opt ScanOptions) (*ScanResult, error) {
+	ss := opt.SectorSize
+	if ss == 0 {
+		ss = DefaultSectorSize
+	}
+	align := opt.Alignment
+	if align == 0 {
+		align = uint64(1024 * 1024 / ss)
+	}
+	s := &ScanResult{Candidates: []RecoveryCandidate{}, SectorSize: ss, Sectors: uint64(size / ss)}
+
+	if err := s.scanTables(r); err != nil {
+		return nil, fmt.Errorf("ScanLostPartitions:%w", err)
+	}
+	s.scanFilesystems(r, align)
+	s.dedup()
+	return s, nil
+}
+
+// validEntry reports whether e looks a partition of the disk.
+func (s *ScanResult) validEntry(e Entry) bool {
+	return !e.IsBlank() && e.FirstLBA > 0 && e.FirstLBA <= e.LastLBA && e.LastLBA < s.Sectors
+}
+
+// readEntries reads n entries at lba.
+func (s *ScanResult) readEntries(r io.ReaderAt, lba uint64, n uint32, size uint32) ([]Entry, []byte, error) {
+	if size < DefaultSizeOfEntry || n == 0 || n > 1024 {
+		return nil, nil, fmt.Errorf("invalid entries. num=%d size=%d", n, size)
+	}
+	b := make([]byte, int64(n)*int64(size))
+	if _, err := r.ReadAt(b, int64(lba)*s.SectorSize); err != nil {
+		return nil, nil, err
+	}
+	ret := make([]Entry, n)
+	for i := range ret {
+		binary.Read(bytes.NewReader(b[i*int(size):]), binary.LittleEndian, &ret[i])
+	}
+	return ret, b, nil
+}
+
+// scanTables searches headers and entry arrays in every sector.
+func (s *ScanResult) scanTables(r io.ReaderAt) error {
+	type array struct {
+		lba     uint64
+		entries []Entry
+	}
+	orphans := []array{}
+	used := [][2]uint64{} // ranges of entries referred by headers
+
+	const chunk = 2048
+	buf := make([]byte, chunk*s.SectorSize)
+	skip := uint64(0)
+	for lba := uint64(0); lba < s.Sectors; lba += chunk {
+		n, err := r.ReadAt(buf, int64(lba)*s.SectorSize)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		for i := 0; i+int(s.SectorSize) <= n; i += int(s.SectorSize) {
+			cur := lba + uint64(i)/uint64(s.SectorSize)
+			b := buf[i : i+int(s.SectorSize)]
+
+			if binary.LittleEndian.Uint64(b) == HeaderSignature {
+				if rng, ok := s.addHeader(r, cur, b); ok {
+					used = append(used, rng)
+				}
+				continue
+			}
+			if cur < skip {
+				continue
+			}
+			e := Entry{}
+			binary.Read(bytes.NewReader(b), binary.LittleEndian, &e)
+			if _, ok := typeGuidNames[e.TypeGuid]; !ok || !s.validEntry(e) {
+				continue
+			}
+			es, _, err := s.readEntries(r, cur, DefaultNumOfEntry, DefaultSizeOfEntry)
+			if err != nil {
+				continue
+			}
+			orphans = append(orphans, array{cur, es})
+			skip = cur + entriesSectors(DefaultNumOfEntry, DefaultSizeOfEntry, s.SectorSize)
+		}
+		if n < len(buf) {
+			break
+		}
+	}
+
+	for _, a := range orphans {
+		referred := false
+		for _, u := range used {
+			if a.lba >= u[0] && a.lba < u[1] {
+				referred = true
+			}
+		}
+		if referred {
+			continue
+		}
+		for _, e := range a.entries {
+			if s.validEntry(e) {
+				s.Candidates = append(s.Candidates, RecoveryCandidate{Entry: e, Source: SourceEntries, FoundLBA: a.lba, Confidence: confidenceEntries})
+			}
+		}
+	}
+	return nil
+}
+
+// addHeader adds entries referred by the header b at lba.
+// It returns the range of the entry array.
+func (s *ScanResult) addHeader(r io.ReaderAt, lba uint64, b []byte) ([2]uint64, bool) {
+	h := Header{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &h); err != nil {
+		return [2]uint64{}, false
+	}
+	confidence := confidenceBrokenHeader
+	if h.Size >= HeaderSize && h.Size <= uint32(binary.Size(h)) && h.IsValid() && h.CurrentLBA == lba {
+		confidence = confidenceBrokenEntry
+	}
+	es, raw, err := s.readEntries(r, h.StartingLBA, h.NumOfEntries, h.SizeOfEntry)
+	if err != nil || h.StartingLBA >= s.Sectors {
+		return [2]uint64{}, false
+	}
+	if confidence == confidenceBrokenEntry {
+		if crc32.ChecksumIEEE(raw) == h.Crc32OfEntries {
+			confidence = confidenceValidHeader
+			if s.DiskGuid == nil {
+				g := h.DiskGuid
+				s.DiskGuid = &g
+			}
+		}
+	}
+
+	for _, e := range es {
+		if s.validEntry(e) {
+			s.Candidates = append(s.Candidates, RecoveryCandidate{Entry: e, Source: SourceHeader, FoundLBA: lba, Confidence: confidence})
+		}
+	}
+	return [2]uint64{h.StartingLBA, h.StartingLBA + entriesSectors(h.NumOfEntries, h.SizeOfEntry, s.SectorSize)}, true
+}
+
+// fsTypeGuid returns the partition type GUID for the filesystem.
+func fsTypeGuid(r io.ReaderAt, f *Filesystem) Guid {
+	switch f.Type {
+	case "vfat":
+		if v, err := fat.New(r); err == nil {
+			if info, err := fs.Stat(v, "EFI"); err == nil && info.IsDir() {
+				return *EspGuid
+			}
+		}
+		return *MicrosoftBasicDataGuid
+	case "ntfs", "exfat", "BitLocker":
+		return *MicrosoftBasicDataGuid
+	case "swap":
+		return *LinuxSwapGuid
+	case "LVM2_member":
+		return *LinuxLvmGuid
+	case "linux_raid_member":
+		return *LinuxRaidGuid
+	case "zfs_member":
+		return *SolarisUsrGuid
+	}
+	return *LinuxFilesystemGuid
+}
+
+// scanFilesystems probes filesystems at every align sectors.
+// The region of a found filesystem is skipped if its size is known
+// since a filesystem may have backup superblocks.
+// Only signatures at the start are probed since the end of the filesystem is unknown.
+// Otherwise a superblock at the end of the disk is found from every LBA.
+func (s *ScanResult) scanFilesystems(r io.ReaderAt, align uint64) {
+	found := []RecoveryCandidate{}
+	for lba := align; lba < s.Sectors; lba += align {
+		off := int64(lba) * s.SectorSize
+		rest := int64(s.Sectors)*s.SectorSize - off
+		sr := io.NewSectionReader(r, off, rest)
+		f, err := ProbeFilesystem(sr, 0)
+		if err != nil {
+			continue
+		}
+
+		c := RecoveryCandidate{Source: SourceFilesystem, FoundLBA: lba, Confidence: confidenceFs, Filesystem: f}
+		c.Entry.FirstLBA = lba
+		c.Entry.TypeGuid = fsTypeGuid(sr, f)
+		c.Entry.WriteName(f.Label)
+		if f.Size > 0 && f.Size <= rest {
+			c.Confidence = confidenceFsWithSize
+			c.Entry.LastLBA = lba + uint64((f.Size+s.SectorSize-1)/s.SectorSize) - 1
+			if next := (c.Entry.LastLBA + align) / align * align; next > lba {
+				lba = next - align
+			}
+		}
+		found = append(found, c)
+	}
+
+	// The size of the filesystem is unknown. It ends before the next partition.
+	starts := []uint64{s.Sectors - 1}
+	for _, c := range append(found, s.Candidates...) {
+		starts = append(starts, c.Entry.FirstLBA)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+	for i := range found {
+		if found[i].Entry.LastLBA != 0 {
+			continue
+		}
+		for _, v := range starts {
+			if v > found[i].Entry.FirstLBA {
+				found[i].Entry.LastLBA = v - 1
+				break
+			}
+		}
+	}
+
+	// A filesystem at the start of the entry makes the entry reliable.
+	for i, c := range s.Candidates {
+		for j, f := range found {
+			if c.Entry.FirstLBA == f.Entry.FirstLBA {
+				s.Candidates[i].Filesystem = f.Filesystem
+				s.Candidates[i].Confidence += confidenceFsBonus
+				found[j].Confidence = 0
+			}
+		}
+	}
+	for _, f := range found {
+		if f.Confidence > 0 && f.Entry.LastLBA >= f.Entry.FirstLBA {
+			s.Candidates = append(s.Candidates, f)
+		}
+	}
+}
+
+// dedup removes candidates which have the same partition.
+// The one of the highest confidence is kept.
+func (s *ScanResult) dedup() {
+	sort.SliceStable(s.Candidates, func(i, j int) bool {
+		return s.Candidates[i].Confidence > s.Candidates[j].Confidence
+	})
+	type key struct {
+		u           Guid
+		first, last uint64
+	}
+	seen := map[key]bool{}
+	ret := []RecoveryCandidate{}
+	for _, c := range s.Candidates {
+		k := key{c.Entry.UniqueGuid, c.Entry.FirstLBA, c.Entry.LastLBA}
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		ret = append(ret, c)
+	}
+	s.Candidates = ret
+}
+
+// Proposal returns Gpt made from the candidates.
+// Candidates are chosen in order of confidence unless they overlap with chosen ones.
+// The returned Gpt can be written by WriteGpt after review.
+func (s ScanResult) Proposal() (*Gpt, error) {
+	g, err := NewGpt(s.Sectors)
+	if err != nil {
+		return nil, fmt.Errorf("Proposal:%w", err)
+	}
+	if s.SectorSize != DefaultSectorSize {
+		return nil, fmt.Errorf("Proposal:sector size %d is not supported", s.SectorSize)
+	}
+	if s.DiskGuid != nil {
+		g.Header.DiskGuid = *s.DiskGuid
+		g.BackupHeader.DiskGuid = *s.DiskGuid
+	}
+
+	chosen := []Entry{}
+	for _, c := range s.Candidates {
+		e := c.Entry
+		if len(chosen) >= len(g.Entries) || e.FirstLBA < g.Header.FirstUsableLBA || e.LastLBA > g.Header.LastUsableLBA {
+			continue
+		}
+		overlap := false
+		for _, v := range chosen {
+			if e.FirstLBA <= v.LastLBA && v.FirstLBA <= e.LastLBA {
+				overlap = true
+			}
+		}
+		if overlap {
+			continue
+		}
+		if e.UniqueGuid.Equal(*ZeroGuid) {
+			u, err := NewRandomGuid()
+			if err != nil {
+				return nil, fmt.Errorf("Proposal:%w", err)
+			}
+			e.UniqueGuid = *u
+		}
+		chosen = append(chosen, e)
+	}
+	sort.Slice(chosen, func(i, j int) bool { return chosen[i].FirstLBA < chosen[j].FirstLBA })
+	copy(g.Entries, chosen)
+
+	if err := g.UpdateCrc32(); err != nil {
+		return nil, fmt.Errorf("Proposal:%w", err)
+	}
+	return g, nil
+}
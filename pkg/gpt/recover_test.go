@@ -0,0 +1,140 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"testing"
+)
+
+// newDamagedDisk returns a disk which has 2 GPT entries and an ext2 filesystem without entry.
+// The sectors at wipe are zeroed.
+func newDamagedDisk(t *testing.T, wipe ...int64) (*gpt.Gpt, memDisk) {
+	t.Helper()
+	d := make(memDisk, testDiskSectors*512)
+	g := newTestGpt(t, 2)
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	if err := gpt.WriteGpt(d, g); err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+	putFatBootSector(d[2048*512:], 200, false)
+
+	// ext2 of 512KiB at LBA 6144
+	sb := d[6144*512+1024:]
+	binary.LittleEndian.PutUint32(sb[4:], 512)
+	binary.LittleEndian.PutUint16(sb[56:], 0xef53)
+	copy(sb[120:], "lost")
+
+	for _, lba := range wipe {
+		copy(d[lba*512:], make([]byte, 512))
+	}
+	return g, d
+}
+
+func TestScanLostPartitions(t *testing.T) {
+	type testcase struct {
+		name       string
+		wipe       []int64
+		confidence int
+		source     string
+	}
+
+	cases := []testcase{
+		{"primary header", []int64{0, 1}, 100, gpt.SourceHeader},
+		{"both headers", []int64{0, 1, testDiskSectors - 1}, 60, gpt.SourceEntries},
+	}
+
+	for _, v := range cases {
+		g, d := newDamagedDisk(t, v.wipe...)
+		if _, err := gpt.ReadGpt(bytes.NewReader(d)); err == nil {
+			t.Errorf("%s:ReadGpt should be error", v.name)
+		}
+
+		s, err := gpt.ScanLostPartitions(d, int64(len(d)), gpt.ScanOptions{Alignment: 256})
+		if err != nil {
+			t.Errorf("%s:ScanLostPartitions err:%s", v.name, err)
+			continue
+		}
+		if len(s.Candidates) != 3 {
+			t.Errorf("%s:number of candidates mismatch. given %d expect 3. %+v", v.name, len(s.Candidates), s.Candidates)
+			continue
+		}
+		c := s.Candidates[0]
+		if c.Entry != g.Entries[0] || c.Confidence != v.confidence || c.Source != v.source {
+			t.Errorf("%s:candidate mismatch\n given :%+v\n expect:%+v", v.name, c, g.Entries[0])
+		}
+		if c.Filesystem == nil || c.Filesystem.Type != "vfat" {
+			t.Errorf("%s:filesystem mismatch. %+v", v.name, c.Filesystem)
+		}
+		c = s.Candidates[2]
+		if c.Source != gpt.SourceFilesystem || c.Entry.FirstLBA != 6144 || c.Entry.LastLBA != 6144+1023 || c.Entry.ReadName() != "lost" {
+			t.Errorf("%s:filesystem candidate mismatch. %+v", v.name, c)
+		}
+
+		p, err := s.Proposal()
+		if err != nil {
+			t.Errorf("%s:Proposal err:%s", v.name, err)
+			continue
+		}
+		if err := gpt.WriteGpt(d, p); err != nil {
+			t.Errorf("%s:WriteGpt err:%s", v.name, err)
+			continue
+		}
+		r, err := gpt.ReadGpt(bytes.NewReader(d))
+		if err != nil {
+			t.Errorf("%s:ReadGpt err:%s", v.name, err)
+			continue
+		}
+		if r.Entries[0] != g.Entries[0] || r.Entries[1] != g.Entries[1] || !r.Entries[2].TypeGuid.Equal(*gpt.LinuxFilesystemGuid) {
+			t.Errorf("%s:recovered entries mismatch. %+v", v.name, r.Entries[:3])
+		}
+		if v.source == gpt.SourceHeader && !r.Header.DiskGuid.Equal(g.Header.DiskGuid) {
+			t.Errorf("%s:DiskGuid mismatch\n given :%s\n expect:%s", v.name, r.Header.DiskGuid, g.Header.DiskGuid)
+		}
+	}
+}
+
+func TestScanLostPartitionsEmpty(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	s, err := gpt.ScanLostPartitions(d, int64(len(d)), gpt.ScanOptions{})
+	if err != nil {
+		t.Fatalf("ScanLostPartitions err:%s", err)
+	}
+	if len(s.Candidates) != 0 {
+		t.Errorf("candidates should be empty. %+v", s.Candidates)
+	}
+}
+
+func TestScanLostPartitionsMdraidAtEnd(t *testing.T) {
+	const size = 16 * 1024 * 1024
+
+	// md v0.90 superblock at the end of the disk
+	d := make(memDisk, size)
+	binary.LittleEndian.PutUint32(d[size-0x10000:], 0xa92b4efc)
+
+	s, err := gpt.ScanLostPartitions(d, int64(len(d)), gpt.ScanOptions{})
+	if err != nil {
+		t.Fatalf("ScanLostPartitions err:%s", err)
+	}
+	if len(s.Candidates) != 0 {
+		t.Errorf("candidates should be empty. %+v", s.Candidates)
+	}
+}
@@ -0,0 +1,274 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Usages of Signature.
+const (
+	UsagePartitionTable = "partition table"
+	UsageFilesystem     = "filesystem"
+)
+
+// Signature represents a magic string of a partition table or a filesystem like wipefs.
+// Type is "gpt", "dos", "apm", "bsd" or the filesystem type of Filesystem.
+type Signature struct {
+	Type   string
+	Usage  string
+	Offset int64  // offset of the magic from the beginning of the disk in bytes.
+	Length int64  // length of the magic in bytes.
+	Label  string `json:",omitempty"`
+	Uuid   string `json:",omitempty"`
+}
+
+// byteRange represents [start, end) in bytes.
+type byteRange struct {
+	start, end int64
+}
+
+// layout represents the regions which are used by the active partition table.
+type layout struct {
+	tables     []byteRange
+	partitions []byteRange
+}
+
+// contains reports whether [off, off+n) overlaps with the active layout.
+func (l layout) contains(off, n int64) bool {
+	for _, r := range append(append([]byteRange{}, l.tables...), l.partitions...) {
+		if off < r.end && r.start < off+n {
+			return true
+		}
+	}
+	return false
+}
+
+// inPartition returns the end of the partition which contains off.
+func (l layout) inPartition(off int64) (int64, bool) {
+	for _, r := range l.partitions {
+		if off >= r.start && off < r.end {
+			return r.end, true
+		}
+	}
+	return 0, false
+}
+
+// readLayout reads the active partition table of r.
+// It returns empty layout if r has no readable partition table
+// so that the whole disk is scanned.
+func readLayout(r io.ReaderAt, size int64) *layout {
+	l := &layout{}
+	t, err := ReadPartitionTable(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return l
+	}
+
+	switch v := t.(type) {
+	case *Gpt:
+		ss := v.sectorSize()
+		for _, h := range []Header{v.Header, v.BackupHeader} {
+			start := int64(h.StartingLBA)
+			if h.CurrentLBA < h.StartingLBA {
+				start = int64(h.CurrentLBA)
+			}
+			end := int64(h.StartingLBA + entriesSectors(h.NumOfEntries, h.SizeOfEntry, ss))
+			if int64(h.CurrentLBA) >= end {
+				end = int64(h.CurrentLBA) + 1
+			}
+			l.tables = append(l.tables, byteRange{start * ss, end * ss})
+		}
+		l.tables = append(l.tables, byteRange{0, ss})
+	case *MbrTable:
+		l.tables = append(l.tables, byteRange{0, 512})
+		for _, e := range v.Mbr.Entries {
+			if e.IsExtended() {
+				l.tables = append(l.tables, byteRange{int64(e.FirstLBA) * 512, int64(e.FirstLBA+e.AllLBA) * 512})
+			}
+		}
+	case *Apm:
+		l.tables = append(l.tables, byteRange{0, int64(len(v.Entries)+1) * int64(v.BlockSize)})
+	case *Disklabel:
+		l.tables = append(l.tables, byteRange{0, 2 * 512})
+	}
+
+	for _, p := range t.Partitions() {
+		// e.g. the raw partition of disklabel covers the whole disk.
+		if p.Start == 0 || p.Size == 0 {
+			continue
+		}
+		l.partitions = append(l.partitions, byteRange{int64(p.Start), int64(p.Start + p.Size)})
+	}
+	return l
+}
+
+// tableSignatures returns the signatures of partition tables at the well-known offsets.
+func tableSignatures(r io.ReaderAt, size int64) []Signature {
+	ret := []Signature{}
+	add := func(typ string, off, n int64) {
+		ret = append(ret, Signature{Type: typ, Usage: UsagePartitionTable, Offset: off, Length: n})
+	}
+
+	gptMagic := make([]byte, 8)
+	binary.LittleEndian.PutUint64(gptMagic, HeaderSignature)
+	for _, ss := range []int64{DefaultSectorSize, 4096} {
+		for _, off := range []int64{ss, size - ss} {
+			if off > 0 && hasMagic(r, off, string(gptMagic)) {
+				add("gpt", off, 8)
+			}
+		}
+	}
+	if hasMagic(r, 510, "\x55\xaa") {
+		add("dos", 510, 2)
+	}
+	if hasMagic(r, 0, "ER") {
+		add("apm", 0, 2)
+		if b := readBytes(r, 2, 2); b != nil {
+			bs := int64(binary.BigEndian.Uint16(b))
+			for _, off := range []int64{512, bs} {
+				if off >= 512 && hasMagic(r, off, "PM") {
+					add("apm", off, 2)
+				}
+			}
+		}
+	}
+	if b := readBytes(r, 512, 4); b != nil {
+		if binary.LittleEndian.Uint32(b) == DisklabelMagic || binary.BigEndian.Uint32(b) == DisklabelMagic {
+			add("bsd", 512, 4)
+		}
+	}
+	return ret
+}
+
+// fsMagic represents the magic string of a filesystem.
+type fsMagic struct {
+	off   int64
+	magic string
+}
+
+// fsMagics are magic strings which wipefs erases.
+var fsMagics = map[string][]fsMagic{
+	"crypto_LUKS": {{0, "LUKS\xba\xbe"}},
+	"LVM2_member": {{0, "LABELONE"}, {512, "LABELONE"}, {1024, "LABELONE"}, {1536, "LABELONE"}},
+	"BitLocker":   {{3, "-FVE-FS-"}},
+	"exfat":       {{3, "EXFAT   "}},
+	"ntfs":        {{3, "NTFS    "}},
+	"vfat":        {{54, "FAT1"}, {82, "FAT32"}, {510, "\x55\xaa"}},
+	"ext2":        {{1024 + 56, "\x53\xef"}},
+	"ext3":        {{1024 + 56, "\x53\xef"}},
+	"ext4":        {{1024 + 56, "\x53\xef"}},
+	"xfs":         {{0, "XFSB"}},
+	"btrfs":       {{0x10040, "_BHRfS_M"}},
+	"swap": {{4096 - 10, "SWAPSPACE2"}, {8192 - 10, "SWAPSPACE2"}, {16384 - 10, "SWAPSPACE2"}, {65536 - 10, "SWAPSPACE2"},
+		{4096 - 10, "SWAP-SPACE"}, {8192 - 10, "SWAP-SPACE"}, {16384 - 10, "SWAP-SPACE"}, {65536 - 10, "SWAP-SPACE"}},
+	"iso9660":  {{0x8001, "CD001"}},
+	"squashfs": {{0, "hsqs"}},
+	"erofs":    {{1024, "\xe2\xe1\xf5\xe0"}},
+}
+
+// fsSignatures returns the signatures of the filesystem f which starts at off.
+func fsSignatures(r io.ReaderAt, size int64, off int64, f *Filesystem) []Signature {
+	ms := fsMagics[f.Type]
+	switch f.Type {
+	case "linux_raid_member":
+		magic := "\xfc\x4e\x2b\xa9"
+		ms = []fsMagic{{0, magic}, {4096, magic}}
+		if size > 8192 {
+			ms = append(ms, fsMagic{(size &^ 4095) - 8192, magic})
+		}
+		if size >= 0x20000 {
+			ms = append(ms, fsMagic{(size &^ 0xffff) - 0x10000, magic})
+		}
+	case "zfs_member":
+		ms = nil
+		for o := int64(128 * 1024); o < 256*1024; o += 1024 {
+			ms = append(ms, fsMagic{o, "\x0c\xb1\xba\x00\x00\x00\x00\x00"}, fsMagic{o, "\x00\x00\x00\x00\x00\xba\xb1\x0c"})
+		}
+	}
+
+	sr := io.NewSectionReader(r, off, size)
+	ret := []Signature{}
+	for _, m := range ms {
+		if hasMagic(sr, m.off, m.magic) {
+			ret = append(ret, Signature{Type: f.Type, Usage: UsageFilesystem, Offset: off + m.off, Length: int64(len(m.magic)),
+				Label: f.Label, Uuid: f.Uuid})
+		}
+	}
+	return ret
+}
+
+// FindStaleSignatures reports signatures of partition tables and filesystems
+// which are outside of the active partition table of r whose size is size.
+// Filesystems are searched at the beginning of the disk and every 1MiB of the free space.
+// Signatures are sorted by offset.
+func FindStaleSignatures(r io.ReaderAt, size int64) ([]Signature, error) {
+	l := readLayout(r, size)
+
+	// The filesystem which uses the whole disk is active if no partition table.
+	if len(l.tables) == 0 {
+		if f, err := ProbeFilesystem(r, size); err == nil {
+			end := size
+			if f.Size > 0 && f.Size < size {
+				end = f.Size
+			}
+			l.partitions = append(l.partitions, byteRange{0, end})
+		}
+	}
+
+	found := tableSignatures(r, size)
+	const align = 1024 * 1024
+	for off := int64(0); off < size; off += align {
+		if end, ok := l.inPartition(off); ok {
+			off = (end+align-1)/align*align - align
+			continue
+		}
+		f, err := ProbeFilesystem(io.NewSectionReader(r, off, size-off), size-off)
+		if err != nil {
+			continue
+		}
+		found = append(found, fsSignatures(r, size-off, off, f)...)
+		// skip backup superblocks
+		if f.Size > 0 {
+			off = (off+f.Size+align-1)/align*align - align
+		}
+	}
+
+	ret := []Signature{}
+	seen := map[int64]bool{}
+	for _, s := range found {
+		if seen[s.Offset] || l.contains(s.Offset, s.Length) {
+			continue
+		}
+		seen[s.Offset] = true
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Offset < ret[j].Offset })
+	return ret, nil
+}
+
+// WipeSignatures zeroes the magic strings of sigs like wipefs.
+func WipeSignatures(w io.WriterAt, sigs []Signature) error {
+	for _, s := range sigs {
+		if _, err := w.WriteAt(make([]byte, s.Length), s.Offset); err != nil {
+			return fmt.Errorf("WipeSignatures:%w", err)
+		}
+	}
+	return nil
+}
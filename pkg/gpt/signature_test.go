@@ -0,0 +1,102 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"testing"
+)
+
+func TestFindStaleSignatures(t *testing.T) {
+	type testcase struct {
+		name   string
+		build  func(t *testing.T) memDisk
+		expect []gpt.Signature
+	}
+
+	cases := []testcase{
+		{"gpt", func(t *testing.T) memDisk {
+			_, d := newDamagedDisk(t) // ext2 at 3MiB is outside of the entries.
+			d[0x8000] = 1
+			copy(d[0x8001:], "CD001")
+			return d
+		}, []gpt.Signature{
+			{Type: "iso9660", Usage: gpt.UsageFilesystem, Offset: 0x8001, Length: 5},
+			{Type: "ext2", Usage: gpt.UsageFilesystem, Offset: 3*1024*1024 + 1024 + 56, Length: 2, Label: "lost", Uuid: "00000000-0000-0000-0000-000000000000"},
+		}},
+		{"mbr", func(t *testing.T) memDisk {
+			d := newMbrDisk(t)
+			binary.LittleEndian.PutUint64(d[len(d)-512:], gpt.HeaderSignature)
+			return d
+		}, []gpt.Signature{
+			{Type: "gpt", Usage: gpt.UsagePartitionTable, Offset: testDiskSectors*512 - 512, Length: 8},
+		}},
+		{"mbr with stale primary gpt", func(t *testing.T) memDisk {
+			d := newMbrDisk(t)
+			binary.LittleEndian.PutUint64(d[512:], gpt.HeaderSignature)
+			return d
+		}, []gpt.Signature{
+			{Type: "gpt", Usage: gpt.UsagePartitionTable, Offset: 512, Length: 8},
+		}},
+		{"broken gpt", func(t *testing.T) memDisk {
+			d := make(memDisk, testDiskSectors*512)
+			d.putMbr(t, 0, gpt.NewProtectiveMbr(testDiskSectors))
+			binary.LittleEndian.PutUint64(d[512:], gpt.HeaderSignature) // header without valid crc
+			return d
+		}, []gpt.Signature{
+			{Type: "dos", Usage: gpt.UsagePartitionTable, Offset: 510, Length: 2},
+			{Type: "gpt", Usage: gpt.UsagePartitionTable, Offset: 512, Length: 8},
+		}},
+		{"superfloppy", func(t *testing.T) memDisk {
+			d := make(memDisk, testDiskSectors*512)
+			putFatBootSector(d, 2000, false)
+			return d
+		}, []gpt.Signature{}},
+	}
+
+	for _, v := range cases {
+		d := v.build(t)
+		s, err := gpt.FindStaleSignatures(d, int64(len(d)))
+		if err != nil {
+			t.Errorf("%s:FindStaleSignatures err:%s", v.name, err)
+			continue
+		}
+		if len(s) != len(v.expect) {
+			t.Errorf("%s:mismatch\n given :%+v\n expect:%+v", v.name, s, v.expect)
+			continue
+		}
+		for i := range s {
+			if s[i] != v.expect[i] {
+				t.Errorf("%s:mismatch\n given :%+v\n expect:%+v", v.name, s[i], v.expect[i])
+			}
+		}
+
+		if err := gpt.WipeSignatures(d, s); err != nil {
+			t.Errorf("%s:WipeSignatures err:%s", v.name, err)
+			continue
+		}
+		s, err = gpt.FindStaleSignatures(d, int64(len(d)))
+		if err != nil || len(s) != 0 {
+			t.Errorf("%s:signatures should be wiped. %+v err:%v", v.name, s, err)
+		}
+		if _, err := gpt.ReadPartitionTable(bytes.NewReader(d)); err != nil && v.name != "superfloppy" && v.name != "broken gpt" {
+			t.Errorf("%s:ReadPartitionTable err:%s", v.name, err)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Scheme represents the partitioning scheme of a disk.
+type Scheme int
+
+const (
+	SchemeNone      Scheme = iota // no partition table
+	SchemeGpt                     // GPT with protective MBR
+	SchemeHybridMbr               // GPT with hybrid MBR
+	SchemeMbr                     // MBR only
+	SchemeApm                     // Apple Partition Map
+	SchemeBsd                     // BSD disklabel
+)
+
+// String implements fmt.Stringer interface
+func (s Scheme) String() string {
+	switch s {
+	case SchemeNone:
+		return "None"
+	case SchemeGpt:
+		return "GPT"
+	case SchemeHybridMbr:
+		return "Hybrid MBR"
+	case SchemeMbr:
+		return "MBR"
+	case SchemeApm:
+		return "APM"
+	case SchemeBsd:
+		return "BSD disklabel"
+	}
+	return "Unknown"
+}
+
+// ErrNoPartitionTable is returned if the disk has no known partition table.
+var ErrNoPartitionTable = errors.New("no partition table")
+
+// ErrUnsupportedScheme is returned if the partition table can't be read.
+var ErrUnsupportedScheme = errors.New("unsupported scheme")
+
+// Partition represents a partition independent of Scheme.
+type Partition struct {
+	Index     int    // number of the partition. It starts from 1.
+	Start     uint64 // offset in bytes from the beginning of the disk.
+	Size      uint64 // size in bytes.
+	Type      string
+	Name      string
+	Details   interface{} // scheme specific entry. e.g. Entry, MbrEntry.
+	Disklabel *Disklabel  `json:",omitempty"` // nested BSD disklabel if the partition has it.
+}
+
+// PartitionTable is the interface to list partitions of any Scheme.
+type PartitionTable interface {
+	Scheme() Scheme
+	Partitions() []Partition
+}
+
+// Scheme returns SchemeHybridMbr if g.Mbr has partitions other than protective one.
+// Otherwise it returns SchemeGpt.
+func (g Gpt) Scheme() Scheme {
+	for _, e := range g.Mbr.Entries {
+		if !e.IsBlank() && e.Id != 0xee {
+			return SchemeHybridMbr
+		}
+	}
+	return SchemeGpt
+}
+
+// Partitions returns non-blank entries as Partition.
+// Details is Entry.
+func (g Gpt) Partitions() []Partition {
+	sectorSize := uint64(g.sectorSize())
+
+	ret := []Partition{}
+	for i, e := range g.Entries {
+		if e.IsBlank() {
+			continue
+		}
+		p := Partition{Index: i + 1, Start: e.FirstLBA * sectorSize, Size: (e.LastLBA - e.FirstLBA + 1) * sectorSize,
+			Type: e.TypeGuid.TypeString(), Name: e.ReadName(), Details: e, Disklabel: g.Disklabels[i]}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// MbrTable represents MBR and logical partitions.
+type MbrTable struct {
+	Mbr        Mbr
+	Logicals   []MbrEntry         // FirstLBA is relative to the beginning of the disk.
+	Disklabels map[int]*Disklabel // nested disklabels of BSD slices. The key is Index-1 of Partitions.
+}
+
+// ReadMbrTable reads MBR and logical partitions from rs.
+func ReadMbrTable(rs io.ReadSeeker) (*MbrTable, error) {
+	rs.Seek(0, io.SeekStart)
+	m, err := ReadMbr(rs)
+	if err != nil {
+		return nil, fmt.Errorf("ReadMbrTable:%w", err)
+	}
+	if !m.IsValid() {
+		return nil, fmt.Errorf("ReadMbrTable:invalid MBR signature 0x%x", m.Signature)
+	}
+	l, err := ReadLogicalEntries(rs, *m)
+	if err != nil {
+		return nil, fmt.Errorf("ReadMbrTable:%w", err)
+	}
+	t := &MbrTable{Mbr: *m, Logicals: l}
+	for i, e := range append(m.Entries[:], l...) {
+		if d, err := e.ReadDisklabel(rs); err == nil {
+			if t.Disklabels == nil {
+				t.Disklabels = map[int]*Disklabel{}
+			}
+			t.Disklabels[i] = d
+		}
+	}
+	return t, nil
+}
+
+// Scheme returns SchemeMbr.
+func (t MbrTable) Scheme() Scheme {
+	return SchemeMbr
+}
+
+// Partitions returns primary and logical partitions as Partition.
+// Primary partitions are numbered from 1 to 4 and logical partitions are from 5.
+// Extended partition is not included. Details is MbrEntry.
+func (t MbrTable) Partitions() []Partition {
+	sectorSize := uint64(512)
+
+	ret := []Partition{}
+	add := func(i int, e MbrEntry) {
+		p := Partition{Index: i, Start: uint64(e.FirstLBA) * sectorSize, Size: uint64(e.AllLBA) * sectorSize,
+			Type: e.IdString(), Details: e, Disklabel: t.Disklabels[i-1]}
+		ret = append(ret, p)
+	}
+	for i, e := range t.Mbr.Entries {
+		if e.IsBlank() || e.IsExtended() {
+			continue
+		}
+		add(i+1, e)
+	}
+	for i, e := range t.Logicals {
+		add(len(t.Mbr.Entries)+i+1, e)
+	}
+	return ret
+}
+
+// readSector reads 512 bytes at the sector lba.
+func readSector(rs io.ReadSeeker, lba int64) ([]byte, error) {
+	return readSectorWithSize(rs, lba, DefaultSectorSize)
+}
+
+// readSectorWithSize reads sectorSize bytes at the sector lba.
+func readSectorWithSize(rs io.ReadSeeker, lba int64, sectorSize int64) ([]byte, error) {
+	b := make([]byte, sectorSize)
+	if _, err := rs.Seek(sectorSize*lba, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rs, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// DetectScheme detects the partitioning scheme of rs.
+// It returns SchemeNone if rs has no known partition table.
+func DetectScheme(rs io.ReadSeeker) (Scheme, error) {
+	s0, err := readSector(rs, 0)
+	if err != nil {
+		return SchemeNone, fmt.Errorf("DetectScheme:%w", err)
+	}
+	s1, err := readSector(rs, 1)
+	if err != nil {
+		// too small disk
+		return SchemeNone, nil
+	}
+
+	mbrValid := binary.LittleEndian.Uint16(s0[510:]) == 0xaa55
+	protective := false
+	others := false
+	if mbrValid {
+		for i := 0; i < 4; i++ {
+			e := s0[446+16*i:]
+			if e[4] == 0xee {
+				protective = true
+			} else if e[4] != 0 && binary.LittleEndian.Uint32(e[12:]) != 0 {
+				others = true
+			}
+		}
+	}
+
+	// GPT header is active only with protective MBR or without MBR.
+	// A leftover GPT header on the disk which is re-partitioned as MBR is ignored.
+	if protective || (!mbrValid && binary.LittleEndian.Uint64(s1) == HeaderSignature) {
+		if others {
+			return SchemeHybridMbr, nil
+		}
+		return SchemeGpt, nil
+	}
+	if binary.BigEndian.Uint16(s0) == DdmSignature {
+		if binary.BigEndian.Uint16(s1) == ApmEntrySignature {
+			return SchemeApm, nil
+		}
+		// block size of Ddm may be larger than 512. e.g. hybrid ISO image.
+		if bs := int64(binary.BigEndian.Uint16(s0[2:])); bs > 512 && bs%512 == 0 {
+			if b, err := readSector(rs, bs/512); err == nil && binary.BigEndian.Uint16(b) == ApmEntrySignature {
+				return SchemeApm, nil
+			}
+		}
+	}
+	if binary.LittleEndian.Uint32(s1) == DisklabelMagic {
+		return SchemeBsd, nil
+	}
+	if mbrValid {
+		return SchemeMbr, nil
+	}
+	return SchemeNone, nil
+}
+
+// ReadPartitionTable detects the partitioning scheme and reads the partition table from rs.
+// GPT is read with logical sector size 512 and then 4096.
+// It returns ErrNoPartitionTable if rs has no known partition table.
+func ReadPartitionTable(rs io.ReadSeeker) (PartitionTable, error) {
+	s, err := DetectScheme(rs)
+	if err != nil {
+		return nil, fmt.Errorf("ReadPartitionTable:%w", err)
+	}
+
+	switch s {
+	case SchemeGpt, SchemeHybridMbr:
+		g, err := ReadGpt(rs)
+		if err != nil {
+			// logical sector size is unknown. try 4Kn.
+			if g4k, err4k := ReadGptWithSectorSize(rs, 4096); err4k == nil {
+				return g4k, nil
+			}
+			return nil, fmt.Errorf("ReadPartitionTable:%w", err)
+		}
+		return g, nil
+	case SchemeMbr:
+		t, err := ReadMbrTable(rs)
+		if err != nil {
+			return nil, fmt.Errorf("ReadPartitionTable:%w", err)
+		}
+		return t, nil
+	case SchemeApm:
+		a, err := ReadApm(rs)
+		if err != nil {
+			return nil, fmt.Errorf("ReadPartitionTable:%w", err)
+		}
+		return a, nil
+	case SchemeBsd:
+		d, err := ReadDisklabel(rs, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("ReadPartitionTable:%w", err)
+		}
+		return d, nil
+	case SchemeNone:
+		return nil, fmt.Errorf("ReadPartitionTable:%w", ErrNoPartitionTable)
+	}
+	return nil, fmt.Errorf("ReadPartitionTable:%w. %s", ErrUnsupportedScheme, s)
+}
@@ -0,0 +1,142 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func readGptSample(t *testing.T) memDisk {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "gpt_sample.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	return memDisk(b)
+}
+
+func TestDetectScheme(t *testing.T) {
+	type testcase struct {
+		name   string
+		disk   memDisk
+		expect gpt.Scheme
+	}
+
+	hybrid := readGptSample(t)
+	hybrid[446+16+4] = 0x83
+	binary.LittleEndian.PutUint32(hybrid[446+16+8:], 34)
+	binary.LittleEndian.PutUint32(hybrid[446+16+12:], 2)
+
+	// MBR disk which has the leftover GPT header.
+	stale := newMbrDisk(t)
+	copy(stale[512:], readGptSample(t)[512:1024])
+
+	// GPT header without MBR.
+	noMbr := readGptSample(t)
+	copy(noMbr, make([]byte, 512))
+
+	apm := make(memDisk, 4096)
+	copy(apm, "ER")
+	copy(apm[512:], "PM")
+
+	bsd := make(memDisk, 4096)
+	binary.LittleEndian.PutUint32(bsd[512:], gpt.DisklabelMagic)
+
+	cases := []testcase{
+		{"gpt", readGptSample(t), gpt.SchemeGpt},
+		{"hybrid", hybrid, gpt.SchemeHybridMbr},
+		{"mbr", newMbrDisk(t), gpt.SchemeMbr},
+		{"mbr with stale gpt", stale, gpt.SchemeMbr},
+		{"gpt without mbr", noMbr, gpt.SchemeGpt},
+		{"apm", apm, gpt.SchemeApm},
+		{"bsd", bsd, gpt.SchemeBsd},
+		{"none", make(memDisk, 4096), gpt.SchemeNone},
+	}
+
+	for _, v := range cases {
+		s, err := gpt.DetectScheme(bytes.NewReader(v.disk))
+		if err != nil {
+			t.Errorf("%s:DetectScheme err:%s", v.name, err)
+			continue
+		}
+		if s != v.expect {
+			t.Errorf("%s:given %s expect %s", v.name, s, v.expect)
+		}
+	}
+}
+
+func TestReadPartitionTable(t *testing.T) {
+	type partition struct {
+		index int
+		start uint64
+		size  uint64
+		typ   string
+	}
+	type testcase struct {
+		name   string
+		disk   memDisk
+		scheme gpt.Scheme
+		expect []partition
+	}
+
+	cases := []testcase{
+		{"gpt", readGptSample(t), gpt.SchemeGpt, []partition{
+			{1, 34 * 512, 2 * 512, "EFI System"},
+			{2, 36 * 512, 3 * 512, "Linux filesystem"},
+		}},
+		{"mbr", newMbrDisk(t), gpt.SchemeMbr, []partition{
+			{1, 2048 * 512, 2048 * 512, "Linux"},
+			{5, (4096 + 64) * 512, 960 * 512, "HPFS/NTFS/exFAT"},
+			{6, (4096 + 1024 + 64) * 512, 1984 * 512, "Linux Swap"},
+		}},
+	}
+
+	for _, v := range cases {
+		pt, err := gpt.ReadPartitionTable(bytes.NewReader(v.disk))
+		if err != nil {
+			t.Errorf("%s:ReadPartitionTable err:%s", v.name, err)
+			continue
+		}
+		if pt.Scheme() != v.scheme {
+			t.Errorf("%s:scheme mismatch\n given :%s\n expect:%s", v.name, pt.Scheme(), v.scheme)
+		}
+		ps := pt.Partitions()
+		if len(ps) < len(v.expect) {
+			t.Errorf("%s:length mismatch\n given :%d\n expect:%d", v.name, len(ps), len(v.expect))
+			continue
+		}
+		for i, e := range v.expect {
+			p := ps[i]
+			if p.Index != e.index || p.Start != e.start || p.Size != e.size || p.Type != e.typ {
+				t.Errorf("%s:partition mismatch\n given :%+v\n expect:%+v", v.name, p, e)
+			}
+		}
+	}
+}
+
+func TestReadPartitionTableNone(t *testing.T) {
+	_, err := gpt.ReadPartitionTable(bytes.NewReader(make([]byte, 4096)))
+	if !errors.Is(err, gpt.ErrNoPartitionTable) {
+		t.Errorf("given %v expect %s", err, gpt.ErrNoPartitionTable)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ByteRange represents a region of the disk in bytes.
+type ByteRange struct {
+	Offset int64
+	Length int64
+}
+
+// WipeOptions represents options of WipeRanges.
+type WipeOptions struct {
+	SectorSize int64 // 0 means the sector size of GPT or both 512 and 4096 if GPT is not found.
+	ZeroMiB    int64 // the first and last ZeroMiB MiB are also zeroed.
+}
+
+// gptRanges returns the regions of protective MBR, headers and entries
+// at the default location for the sector size.
+func gptRanges(size int64, sectorSize int64) []ByteRange {
+	n := int64(entriesSectors(DefaultNumOfEntry, DefaultSizeOfEntry, sectorSize))
+	return []ByteRange{
+		{0, sectorSize},                                 // protective MBR
+		{sectorSize, sectorSize * (1 + n)},              // primary header and entries
+		{size - sectorSize*(1+n), sectorSize * (1 + n)}, // backup entries and header
+	}
+}
+
+// mergeRanges sorts and merges overlapped ranges and clips them into [0, size).
+func mergeRanges(rs []ByteRange, size int64) []ByteRange {
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Offset < rs[j].Offset })
+	ret := []ByteRange{}
+	for _, r := range rs {
+		start, end := r.Offset, r.Offset+r.Length
+		if start < 0 {
+			start = 0
+		}
+		if end > size {
+			end = size
+		}
+		if start >= end {
+			continue
+		}
+		if n := len(ret); n > 0 && start <= ret[n-1].Offset+ret[n-1].Length {
+			if end > ret[n-1].Offset+ret[n-1].Length {
+				ret[n-1].Length = end - ret[n-1].Offset
+			}
+			continue
+		}
+		ret = append(ret, ByteRange{start, end - start})
+	}
+	return ret
+}
+
+// WipeRanges returns the regions to be zeroed to remove the partition table of r whose size is size.
+// The regions are protective MBR, primary header and entries, backup header and entries.
+// The locations written in headers are also included if GPT is found.
+// It doesn't write anything so that it can be used as dry-run.
+func WipeRanges(r io.ReaderAt, size int64, opt WipeOptions) ([]ByteRange, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("WipeRanges:invalid size %d", size)
+	}
+	if opt.ZeroMiB < 0 {
+		return nil, fmt.Errorf("WipeRanges:invalid ZeroMiB %d", opt.ZeroMiB)
+	}
+
+	rs := []ByteRange{}
+	sizes := []int64{DefaultSectorSize, 4096}
+	if opt.SectorSize != 0 {
+		sizes = []int64{opt.SectorSize}
+	}
+
+	sr := io.NewSectionReader(r, 0, size)
+	var g *Gpt
+	var err error
+	for _, ss := range sizes {
+		if g, err = ReadGptWithSectorSize(sr, ss); err == nil {
+			break
+		}
+	}
+	if err == nil {
+		ss := g.sectorSize()
+		sizes = []int64{ss}
+		for _, h := range []Header{g.Header, g.BackupHeader} {
+			n := int64(entriesSectors(h.NumOfEntries, h.SizeOfEntry, ss))
+			rs = append(rs, ByteRange{ss * int64(h.CurrentLBA), ss}, ByteRange{ss * int64(h.StartingLBA), ss * n})
+		}
+	}
+
+	for _, ss := range sizes {
+		rs = append(rs, gptRanges(size, ss)...)
+	}
+	if opt.ZeroMiB > 0 {
+		n := opt.ZeroMiB * 1024 * 1024
+		rs = append(rs, ByteRange{0, n}, ByteRange{size - n, n})
+	}
+	return mergeRanges(rs, size), nil
+}
+
+// ZeroRanges zero-fills rs of w.
+// It overwrites the regions only once and it is not intended to be a secure erase.
+func ZeroRanges(w io.WriterAt, rs []ByteRange) error {
+	const chunk = 1024 * 1024
+	zero := make([]byte, chunk)
+	for _, r := range rs {
+		for off := int64(0); off < r.Length; off += chunk {
+			n := r.Length - off
+			if n > chunk {
+				n = chunk
+			}
+			if _, err := w.WriteAt(zero[:n], r.Offset+off); err != nil {
+				return fmt.Errorf("ZeroRanges:%w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package gpt_test
+
+import (
+	"bytes"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"testing"
+)
+
+func TestWipeRanges(t *testing.T) {
+	const size = testDiskSectors * 512
+
+	type testcase struct {
+		name   string
+		gpt    bool
+		opt    gpt.WipeOptions
+		expect []gpt.ByteRange
+	}
+
+	cases := []testcase{
+		{"gpt", true, gpt.WipeOptions{}, []gpt.ByteRange{{0, 34 * 512}, {size - 33*512, 33 * 512}}},
+		{"unknown", false, gpt.WipeOptions{}, []gpt.ByteRange{{0, 6 * 4096}, {size - 5*4096, 5 * 4096}}},
+		{"4Kn", false, gpt.WipeOptions{SectorSize: 4096}, []gpt.ByteRange{{0, 6 * 4096}, {size - 5*4096, 5 * 4096}}},
+		{"1MiB", true, gpt.WipeOptions{ZeroMiB: 1}, []gpt.ByteRange{{0, 1024 * 1024}, {size - 1024*1024, 1024 * 1024}}},
+		{"whole", true, gpt.WipeOptions{ZeroMiB: 4}, []gpt.ByteRange{{0, size}}},
+	}
+
+	for _, v := range cases {
+		d := make(memDisk, size)
+		if v.gpt {
+			g := newTestGpt(t, 2)
+			if err := g.UpdateCrc32(); err != nil {
+				t.Fatalf("UpdateCrc32 err:%s", err)
+			}
+			if err := gpt.WriteGpt(d, g); err != nil {
+				t.Fatalf("WriteGpt err:%s", err)
+			}
+		}
+
+		rs, err := gpt.WipeRanges(d, size, v.opt)
+		if err != nil {
+			t.Errorf("%s:WipeRanges err:%s", v.name, err)
+			continue
+		}
+		if len(rs) != len(v.expect) {
+			t.Errorf("%s:mismatch\n given :%+v\n expect:%+v", v.name, rs, v.expect)
+			continue
+		}
+		for i := range rs {
+			if rs[i] != v.expect[i] {
+				t.Errorf("%s:mismatch\n given :%+v\n expect:%+v", v.name, rs[i], v.expect[i])
+			}
+		}
+
+		if err := gpt.ZeroRanges(d, rs); err != nil {
+			t.Errorf("%s:ZeroRanges err:%s", v.name, err)
+			continue
+		}
+		s, err := gpt.DetectScheme(bytes.NewReader(d))
+		if err != nil || s != gpt.SchemeNone {
+			t.Errorf("%s:partition table is not wiped. scheme=%s err:%v", v.name, s, err)
+		}
+	}
+}
+
+func TestWipeRangesInvalid(t *testing.T) {
+	d := make(memDisk, testDiskSectors*512)
+	if _, err := gpt.WipeRanges(d, 0, gpt.WipeOptions{}); err == nil {
+		t.Errorf("zero size:it should be error")
+	}
+	if _, err := gpt.WipeRanges(d, int64(len(d)), gpt.WipeOptions{ZeroMiB: -5}); err == nil {
+		t.Errorf("negative ZeroMiB:it should be error")
+	}
+}
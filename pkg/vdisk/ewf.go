@@ -0,0 +1,401 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"hash/adler32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	ewfSignature          = "EVF\x09\x0d\x0a\xff\x00"
+	ewfFileHeaderSize     = 13
+	ewfSectionSize        = 76
+	ewfTableHeaderSize    = 24
+	ewfVolumeSize         = 1052
+	ewfChunkCompressed    = uint32(1) << 31
+	ewfMaxTableEntries    = 65534
+	ewfMaxSegmentSections = 1 << 20
+)
+
+// EwfSectionDescriptor represents the descriptor at the beginning of each section.
+// ref: Expert Witness Compression Format specification by Joachim Metz
+type EwfSectionDescriptor struct {
+	Type     [16]byte
+	Next     uint64 // offset of the next section in the segment file.
+	Size     uint64 // size of the section including the descriptor.
+	Padding  [40]byte
+	Checksum uint32 // Adler-32 of the preceding bytes.
+}
+
+// TypeString returns Type without trailing NUL.
+func (d EwfSectionDescriptor) TypeString() string {
+	return strings.TrimRight(string(d.Type[:]), "\x00")
+}
+
+// EwfVolume represents the beginning of "volume" or "disk" section.
+type EwfVolume struct {
+	MediaType       uint8
+	Unknown         [3]byte
+	ChunkCount      uint32
+	SectorsPerChunk uint32
+	BytesPerSector  uint32
+	SectorCount     uint64
+}
+
+// ewfChunk represents the location of a chunk in the segment files.
+type ewfChunk struct {
+	segment    int
+	offset     int64
+	size       int64 // size of stored data including the checksum of uncompressed chunk.
+	compressed bool
+}
+
+// Ewf is a reader of Expert Witness Format (E01) images.
+type Ewf struct {
+	*io.SectionReader
+	Volume EwfVolume
+	Header string // decompressed "header" section.
+	Md5    []byte // MD5 of the media from "hash" or "digest" section. It is nil if not present.
+
+	segments  []io.ReaderAt
+	chunks    []ewfChunk
+	chunkSize int64
+
+	mu        sync.Mutex
+	lastChunk int
+	chunkBuf  []byte
+}
+
+// isEwf reports whether b starts with the signature of EWF.
+func isEwf(b []byte) bool {
+	return len(b) >= len(ewfSignature) && string(b[:len(ewfSignature)]) == ewfSignature
+}
+
+// EwfSegmentPaths returns the paths of the segment files of the image whose first segment is path.
+// It looks for the files which have the following extensions, e.g. .E02 ... .E99, .EAA ... .EZZ, .FAA ...
+// The case of the extension follows path.
+// It returns only path if the extension of path is not .E01.
+func EwfSegmentPaths(path string) []string {
+	ret := []string{path}
+	ext := filepath.Ext(path)
+	if !strings.EqualFold(ext, ".E01") {
+		return ret
+	}
+	base := path[:len(path)-len(ext)]
+	lower := ext[1] == 'e'
+	first := byte('E')
+	for n := 2; ; n++ {
+		var e string
+		if n < 100 {
+			e = fmt.Sprintf("%c%02d", first, n)
+		} else {
+			i := n - 100
+			c := int(first) + i/(26*26)
+			if c > 'Z' {
+				break
+			}
+			e = fmt.Sprintf("%c%c%c", c, 'A'+(i/26)%26, 'A'+i%26)
+		}
+		if lower {
+			e = strings.ToLower(e)
+		}
+		p := base + "." + e
+		if _, err := os.Stat(p); err != nil {
+			break
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+// readEwfSection reads the section descriptor at off.
+func readEwfSection(r io.ReaderAt, off int64) (*EwfSectionDescriptor, error) {
+	b := make([]byte, ewfSectionSize)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	d := &EwfSectionDescriptor{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, d); err != nil {
+		return nil, err
+	}
+	if adler32.Checksum(b[:ewfSectionSize-4]) != d.Checksum {
+		return nil, fmt.Errorf("%w. section descriptor at 0x%x", ErrChecksum, off)
+	}
+	return d, nil
+}
+
+// readChecked reads n bytes at off which are followed by Adler-32 of them.
+func readChecked(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	b := make([]byte, n+4)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	if adler32.Checksum(b[:n]) != binary.LittleEndian.Uint32(b[n:]) {
+		return nil, fmt.Errorf("%w. offset 0x%x", ErrChecksum, off)
+	}
+	return b[:n], nil
+}
+
+// NewEwf returns a reader of the EWF image which consists of segments.
+// segments must be ordered by the segment number.
+func NewEwf(segments []io.ReaderAt) (*Ewf, error) {
+	e := &Ewf{segments: segments, lastChunk: -1}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("NewEwf:no segment")
+	}
+	hasVolume := false
+	for i, r := range segments {
+		last, err := e.readSegment(i, r, &hasVolume)
+		if err != nil {
+			return nil, fmt.Errorf("NewEwf:segment %d:%w", i+1, err)
+		}
+		if last != (i == len(segments)-1) {
+			if last {
+				return nil, fmt.Errorf("NewEwf:segment %d:unexpected done section", i+1)
+			}
+			return nil, fmt.Errorf("NewEwf:segment %d:missing next segment", i+1)
+		}
+	}
+	if !hasVolume {
+		return nil, fmt.Errorf("NewEwf:volume section not found")
+	}
+
+	size := int64(e.Volume.SectorCount) * int64(e.Volume.BytesPerSector)
+	if n := (size + e.chunkSize - 1) / e.chunkSize; int64(len(e.chunks)) < n {
+		return nil, fmt.Errorf("NewEwf:too few chunks. %d < %d", len(e.chunks), n)
+	}
+	e.SectionReader = io.NewSectionReader(e, 0, size)
+	return e, nil
+}
+
+// readSegment reads the sections of the segment file r.
+// It returns true if the segment has "done" section which means the last segment.
+func (e *Ewf) readSegment(i int, r io.ReaderAt, hasVolume *bool) (bool, error) {
+	b := make([]byte, ewfFileHeaderSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return false, err
+	}
+	if !isEwf(b) {
+		return false, fmt.Errorf("Not EWF")
+	}
+	if n := binary.LittleEndian.Uint16(b[9:]); int(n) != i+1 {
+		return false, fmt.Errorf("segment number mismatch %d", n)
+	}
+
+	type section struct{ start, end int64 }
+	sectors := []section{}
+	off := int64(ewfFileHeaderSize)
+	for j := 0; j < ewfMaxSegmentSections; j++ {
+		d, err := readEwfSection(r, off)
+		if err != nil {
+			return false, err
+		}
+		data := off + ewfSectionSize
+
+		switch d.TypeString() {
+		case "header":
+			if e.Header != "" || d.Size <= ewfSectionSize {
+				break
+			}
+			zr, err := zlib.NewReader(io.NewSectionReader(r, data, int64(d.Size)-ewfSectionSize))
+			if err != nil {
+				return false, fmt.Errorf("header:%w", err)
+			}
+			h, err := ioutil.ReadAll(zr)
+			if err != nil {
+				return false, fmt.Errorf("header:%w", err)
+			}
+			e.Header = string(h)
+		case "volume", "disk":
+			if *hasVolume {
+				break
+			}
+			b, err := readChecked(r, data, ewfVolumeSize-4)
+			if err != nil {
+				return false, fmt.Errorf("volume:%w", err)
+			}
+			binary.Read(bytes.NewReader(b), binary.LittleEndian, &e.Volume)
+			v := e.Volume
+			if v.SectorsPerChunk == 0 || v.BytesPerSector == 0 || int64(v.SectorsPerChunk)*int64(v.BytesPerSector) > 64*1024*1024 {
+				return false, fmt.Errorf("invalid chunk size. %+v", v)
+			}
+			e.chunkSize = int64(v.SectorsPerChunk) * int64(v.BytesPerSector)
+			*hasVolume = true
+		case "sectors":
+			sectors = append(sectors, section{data, off + int64(d.Size)})
+		case "table":
+			if !*hasVolume {
+				return false, fmt.Errorf("table before volume")
+			}
+			// the end of the last chunk is the end of the sectors section which contains it.
+			end := off
+			for _, s := range sectors {
+				if s.start < off && s.end <= off {
+					end = s.end
+				}
+			}
+			if err := e.readTable(i, r, data, end); err != nil {
+				return false, fmt.Errorf("table:%w", err)
+			}
+		case "hash":
+			b, err := readChecked(r, data, 32)
+			if err != nil {
+				return false, fmt.Errorf("hash:%w", err)
+			}
+			e.Md5 = b[:md5.Size]
+		case "digest":
+			b, err := readChecked(r, data, 76)
+			if err != nil {
+				return false, fmt.Errorf("digest:%w", err)
+			}
+			e.Md5 = b[:md5.Size]
+		case "done":
+			return true, nil
+		case "next":
+			return false, nil
+		}
+
+		if int64(d.Next) <= off {
+			return false, fmt.Errorf("invalid next section 0x%x at 0x%x", d.Next, off)
+		}
+		off = int64(d.Next)
+	}
+	return false, fmt.Errorf("too many sections")
+}
+
+// readTable reads the table section at off. end is the end of the data of the last chunk.
+func (e *Ewf) readTable(i int, r io.ReaderAt, off int64, end int64) error {
+	h, err := readChecked(r, off, ewfTableHeaderSize-4)
+	if err != nil {
+		return err
+	}
+	n := binary.LittleEndian.Uint32(h)
+	base := int64(binary.LittleEndian.Uint64(h[8:]))
+	if n > ewfMaxTableEntries {
+		return fmt.Errorf("too many entries %d", n)
+	}
+	b, err := readChecked(r, off+ewfTableHeaderSize, int(n)*4)
+	if err != nil {
+		return err
+	}
+
+	for j := uint32(0); j < n; j++ {
+		v := binary.LittleEndian.Uint32(b[j*4:])
+		c := ewfChunk{segment: i, offset: base + int64(v&^ewfChunkCompressed), compressed: v&ewfChunkCompressed != 0}
+		if j+1 < n {
+			c.size = base + int64(binary.LittleEndian.Uint32(b[(j+1)*4:])&^ewfChunkCompressed) - c.offset
+		} else {
+			c.size = end - c.offset
+		}
+		if c.size <= 0 {
+			return fmt.Errorf("invalid chunk at 0x%x", c.offset)
+		}
+		e.chunks = append(e.chunks, c)
+	}
+	return nil
+}
+
+// chunk returns the decompressed chunk at index i.
+func (e *Ewf) chunk(i int) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.chunkBuf != nil && e.lastChunk == i {
+		return e.chunkBuf, nil
+	}
+
+	c := e.chunks[i]
+	n := e.chunkSize
+	if rest := e.Size() - int64(i)*e.chunkSize; rest < n {
+		n = rest
+	}
+	r := e.segments[c.segment]
+
+	var ret []byte
+	if c.compressed {
+		zr, err := zlib.NewReader(io.NewSectionReader(r, c.offset, c.size))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d:%w", i, err)
+		}
+		// zlib verifies Adler-32 at the end of the stream.
+		if ret, err = ioutil.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("chunk %d:%w", i, err)
+		}
+		if int64(len(ret)) < n {
+			return nil, fmt.Errorf("chunk %d:too short %d", i, len(ret))
+		}
+	} else {
+		if c.size < n+4 {
+			return nil, fmt.Errorf("chunk %d:too short %d", i, c.size)
+		}
+		b, err := readChecked(r, c.offset, int(n))
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d:%w", i, err)
+		}
+		ret = b
+	}
+	e.lastChunk, e.chunkBuf = i, ret
+	return ret, nil
+}
+
+// readChunk reads p from the chunk which contains off.
+func (e *Ewf) readChunk(p []byte, off int64) error {
+	b, err := e.chunk(int(off / e.chunkSize))
+	if err != nil {
+		return err
+	}
+	copy(p, b[off%e.chunkSize:])
+	return nil
+}
+
+// ReadAt reads the media.
+func (e *Ewf) ReadAt(p []byte, off int64) (int, error) {
+	return readClusters(p, off, e.Size(), e.chunkSize, e.readChunk)
+}
+
+// SectorSize returns the bytes per sector of the media.
+func (e *Ewf) SectorSize() int64 {
+	return int64(e.Volume.BytesPerSector)
+}
+
+// Verify reads the whole media and compares it with the MD5 hash stored in the image.
+// The whole media is read even if the image has no MD5 hash,
+// since the checksums of chunks are verified by reading.
+func (e *Ewf) Verify() error {
+	h := md5.New()
+	var w io.Writer = h
+	if e.Md5 == nil {
+		w = io.Discard
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(e, 0, e.Size())); err != nil {
+		return fmt.Errorf("Verify:%w", err)
+	}
+	if e.Md5 != nil && !bytes.Equal(h.Sum(nil), e.Md5) {
+		return fmt.Errorf("Verify:%w. MD5", ErrChecksum)
+	}
+	return nil
+}
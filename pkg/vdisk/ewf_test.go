@@ -0,0 +1,145 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readEwfSegments returns the segments of gpt_sample.E01.
+func readEwfSegments(t *testing.T) [][]byte {
+	t.Helper()
+	ret := [][]byte{}
+	for _, v := range []string{"gpt_sample.E01", "gpt_sample.E02"} {
+		b, err := ioutil.ReadFile(filepath.Join(testdir, v))
+		if err != nil {
+			t.Fatalf("ReadFile err:%s", err)
+		}
+		ret = append(ret, b)
+	}
+	return ret
+}
+
+func newEwf(segs [][]byte) (*vdisk.Ewf, error) {
+	rs := []io.ReaderAt{}
+	for _, v := range segs {
+		rs = append(rs, bytes.NewReader(v))
+	}
+	return vdisk.NewEwf(rs)
+}
+
+func TestNewEwf(t *testing.T) {
+	segs := readEwfSegments(t)
+	e, err := newEwf(segs)
+	if err != nil {
+		t.Fatalf("NewEwf err:%s", err)
+	}
+	if e.Volume.SectorsPerChunk != 64 || e.Volume.SectorCount != 256 || e.SectorSize() != 512 {
+		t.Errorf("volume mismatch. %+v", e.Volume)
+	}
+	if !strings.Contains(e.Header, "examiner") {
+		t.Errorf("header mismatch. %q", e.Header)
+	}
+	if len(e.Md5) != 16 {
+		t.Errorf("MD5 is not found")
+	}
+	checkImage(t, "ewf", e, readSample(t))
+	if err := e.Verify(); err != nil {
+		t.Errorf("Verify err:%s", err)
+	}
+
+	if _, err := newEwf(segs[:1]); err == nil {
+		t.Errorf("it should be error. missing segment")
+	}
+	if _, err := newEwf([][]byte{segs[1], segs[0]}); err == nil {
+		t.Errorf("it should be error. wrong order")
+	}
+}
+
+func TestEwfChecksum(t *testing.T) {
+	segs := readEwfSegments(t)
+	sample := readSample(t)
+
+	// the 2nd chunk is not compressed. It is followed by its Adler-32.
+	chunk := sample[32768:65536]
+	i := bytes.Index(segs[0], chunk)
+	if i < 0 {
+		t.Fatalf("uncompressed chunk is not found")
+	}
+	broken := append([]byte{}, segs[0]...)
+	broken[i] ^= 0xff
+	e, err := newEwf([][]byte{broken, segs[1]})
+	if err != nil {
+		t.Fatalf("NewEwf err:%s", err)
+	}
+	if _, err := e.ReadAt(make([]byte, 512), 32768); !errors.Is(err, vdisk.ErrChecksum) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrChecksum)
+	}
+
+	// the MD5 of the media mismatches.
+	e, err = newEwf(segs)
+	if err != nil {
+		t.Fatalf("NewEwf err:%s", err)
+	}
+	e.Md5[0] ^= 0xff
+	if err := e.Verify(); !errors.Is(err, vdisk.ErrChecksum) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrChecksum)
+	}
+}
+
+func TestEwfSegmentPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ewf")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	names := []string{"a.e01", "a.e02"}
+	for i := 3; i < 100; i++ {
+		names = append(names, "a.e"+string([]byte{byte('0' + i/10), byte('0' + i%10)}))
+	}
+	names = append(names, "a.eaa", "a.eab")
+	for _, v := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, v), nil, 0644); err != nil {
+			t.Fatalf("WriteFile err:%s", err)
+		}
+	}
+
+	ret := vdisk.EwfSegmentPaths(filepath.Join(dir, "a.e01"))
+	if len(ret) != len(names) {
+		t.Fatalf("length mismatch\n given :%d\n expect:%d", len(ret), len(names))
+	}
+	for i, v := range names {
+		if ret[i] != filepath.Join(dir, v) {
+			t.Errorf("%d:mismatch\n given :%s\n expect:%s", i, ret[i], v)
+		}
+	}
+	// segments are searched only from .E01
+	for _, v := range []string{"a.e02", "a.eaa"} {
+		if ret := vdisk.EwfSegmentPaths(filepath.Join(dir, v)); len(ret) != 1 {
+			t.Errorf("%s:only the path should be returned. %v", v, ret)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	httpBlockSize = 4096
+	httpMaxBlocks = 1024 // cache up to 4MiB.
+)
+
+// Http is a reader of the image served by HTTP server which supports Range requests.
+// Fetched blocks are cached, so that reading GPT transfers only a few KiB.
+type Http struct {
+	*io.SectionReader
+	Url string
+
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[int64][]byte // block number to data
+}
+
+// isUrl reports whether path is HTTP(S) URL.
+func isUrl(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// NewHttp returns a reader of the image at url. http.DefaultClient is used if client is nil.
+// The size is learned from Content-Length of HEAD or Content-Range of GET.
+func NewHttp(client *http.Client, url string) (*Http, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	h := &Http{Url: url, client: client, cache: map[int64][]byte{}}
+	size, err := h.size()
+	if err != nil {
+		return nil, fmt.Errorf("NewHttp:%w", err)
+	}
+	h.SectionReader = io.NewSectionReader(h, 0, size)
+	return h, nil
+}
+
+// size returns the size of the image.
+func (h *Http) size() (int64, error) {
+	res, err := h.client.Head(h.Url)
+	if err == nil {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK && res.ContentLength >= 0 && res.Header.Get("Accept-Ranges") != "none" {
+			return res.ContentLength, nil
+		}
+	}
+
+	// Some servers don't support HEAD or omit Content-Length.
+	res, err = h.get(0, 0)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	return parseContentRange(res.Header.Get("Content-Range"))
+}
+
+// parseContentRange returns the complete length of the header "bytes 0-0/12345".
+func parseContentRange(s string) (int64, error) {
+	i := strings.LastIndexByte(s, '/')
+	if !strings.HasPrefix(s, "bytes ") || i < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	n, err := strconv.ParseInt(s[i+1:], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	return n, nil
+}
+
+// get requests bytes from first to last. last is inclusive.
+func (h *Http) get(first, last int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, h.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	res, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		if res.StatusCode == http.StatusOK {
+			return nil, fmt.Errorf("%w. server ignores Range request", ErrUnsupported)
+		}
+		return nil, fmt.Errorf("GET %s:%s", h.Url, res.Status)
+	}
+	return res, nil
+}
+
+// fetch fetches blocks from first to last in a request and caches them.
+func (h *Http) fetch(first, last int64) error {
+	end := (last + 1) * httpBlockSize
+	if end > h.Size() {
+		end = h.Size()
+	}
+	res, err := h.get(first*httpBlockSize, end-1)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	b := make([]byte, end-first*httpBlockSize)
+	if _, err := io.ReadFull(res.Body, b); err != nil {
+		return fmt.Errorf("GET %s:%w", h.Url, err)
+	}
+
+	if len(h.cache)+int(last-first+1) > httpMaxBlocks {
+		h.cache = map[int64][]byte{}
+	}
+	for i := first; i <= last; i++ {
+		n := int64(httpBlockSize)
+		if int64(len(b)) < n {
+			n = int64(len(b))
+		}
+		h.cache[i] = b[:n:n]
+		b = b[n:]
+	}
+	return nil
+}
+
+// ReadAt reads the image. Missing blocks in the range are fetched in a request.
+// Large p is split into the ranges of httpMaxBlocks blocks which fit in the cache.
+func (h *Http) ReadAt(p []byte, off int64) (int, error) {
+	const window = httpMaxBlocks * httpBlockSize
+	if off < 0 {
+		return h.readWindow(p, off)
+	}
+	ret := 0
+	for {
+		cur := off + int64(ret)
+		n := int64(len(p) - ret)
+		if next := (cur/window + 1) * window; cur+n > next {
+			n = next - cur
+		}
+		m, err := h.readWindow(p[ret:ret+int(n)], cur)
+		ret += m
+		if err != nil || ret == len(p) {
+			return ret, err
+		}
+	}
+}
+
+// readWindow reads p which is in a range of httpMaxBlocks blocks.
+func (h *Http) readWindow(p []byte, off int64) (int, error) {
+	if off >= 0 && off < h.Size() {
+		end := off + int64(len(p))
+		if end > h.Size() {
+			end = h.Size()
+		}
+		first, last := int64(-1), int64(-1)
+		h.mu.Lock()
+		for i := off / httpBlockSize; i*httpBlockSize < end; i++ {
+			if _, ok := h.cache[i]; !ok {
+				if first < 0 {
+					first = i
+				}
+				last = i
+			}
+		}
+		if first >= 0 {
+			if err := h.fetch(first, last); err != nil {
+				h.mu.Unlock()
+				return 0, err
+			}
+		}
+		h.mu.Unlock()
+	}
+	return readClusters(p, off, h.Size(), httpBlockSize, h.readBlock)
+}
+
+// readBlock reads p from the block which contains off.
+func (h *Http) readBlock(p []byte, off int64) error {
+	i := off / httpBlockSize
+	h.mu.Lock()
+	b, ok := h.cache[i]
+	if !ok {
+		if err := h.fetch(i, i); err != nil {
+			h.mu.Unlock()
+			return err
+		}
+		b = h.cache[i]
+	}
+	h.mu.Unlock()
+	copy(p, b[off%httpBlockSize:])
+	return nil
+}
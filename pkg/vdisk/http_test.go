@@ -0,0 +1,173 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingWriter counts the bytes of response bodies.
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}
+
+// newGptDisk returns the disk image which has GPT.
+func newGptDisk(t *testing.T, size int64) []byte {
+	t.Helper()
+	g, err := gpt.NewGpt(uint64(size / 512))
+	if err != nil {
+		t.Fatalf("NewGpt err:%s", err)
+	}
+	g.Entries[0] = gpt.Entry{TypeGuid: *gpt.LinuxFilesystemGuid, UniqueGuid: gpt.Guid{1}, FirstLBA: 2048, LastLBA: uint64(size/512) - 2048}
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	d := make(memDisk, size)
+	if err := gpt.WriteGpt(d, g); err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+	return d
+}
+
+func TestNewHttp(t *testing.T) {
+	const size = 32 * 1024 * 1024
+	d := newGptDisk(t, size)
+	var n int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(countingWriter{w, &n}, r, "disk.img", time.Time{}, bytes.NewReader(d))
+	}))
+	defer srv.Close()
+
+	h, err := vdisk.NewHttp(srv.Client(), srv.URL+"/disk.img")
+	if err != nil {
+		t.Fatalf("NewHttp err:%s", err)
+	}
+	if h.Size() != size {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", h.Size(), size)
+	}
+	g, err := gpt.ReadGpt(h)
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	if g.BackupHeader.CurrentLBA != size/512-1 {
+		t.Errorf("backup header mismatch. %+v", g.BackupHeader)
+	}
+	if n > 64*1024 {
+		t.Errorf("too many bytes are transferred. %d", n)
+	}
+
+	b := make([]byte, 5000)
+	if _, err := h.ReadAt(b, 3000); err != nil || !bytes.Equal(b, d[3000:8000]) {
+		t.Errorf("ReadAt mismatch. err=%v", err)
+	}
+}
+
+func TestHttpReadAtLarge(t *testing.T) {
+	const size = 32 * 1024 * 1024
+	d := make([]byte, size)
+	for i := range d {
+		d[i] = byte(i / 4096)
+	}
+	var gets int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			atomic.AddInt64(&gets, 1)
+		}
+		http.ServeContent(w, r, "disk.img", time.Time{}, bytes.NewReader(d))
+	}))
+	defer srv.Close()
+
+	h, err := vdisk.NewHttp(srv.Client(), srv.URL+"/disk.img")
+	if err != nil {
+		t.Fatalf("NewHttp err:%s", err)
+	}
+	atomic.StoreInt64(&gets, 0)
+
+	// 10MiB is larger than the cache. It should be fetched in a few requests, not per block.
+	b := make([]byte, 10*1024*1024)
+	if _, err := h.ReadAt(b, 1000); err != nil || !bytes.Equal(b, d[1000:1000+len(b)]) {
+		t.Errorf("ReadAt mismatch. err=%v", err)
+	}
+	if n := atomic.LoadInt64(&gets); n > 4 {
+		t.Errorf("too many requests. %d", n)
+	}
+}
+
+func TestNewHttpNoHead(t *testing.T) {
+	sample := readSample(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, "disk.img", time.Time{}, bytes.NewReader(sample))
+	}))
+	defer srv.Close()
+
+	h, err := vdisk.NewHttp(srv.Client(), srv.URL)
+	if err != nil {
+		t.Fatalf("NewHttp err:%s", err)
+	}
+	checkImage(t, "no HEAD", h, sample)
+}
+
+func TestNewHttpNoRange(t *testing.T) {
+	sample := readSample(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "none")
+		w.Write(sample)
+	}))
+	defer srv.Close()
+
+	if _, err := vdisk.NewHttp(srv.Client(), srv.URL); !errors.Is(err, vdisk.ErrUnsupported) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrUnsupported)
+	}
+}
+
+func TestOpenUrl(t *testing.T) {
+	sample := readSample(t)
+	overlay := append([]byte{}, sample...)
+	copy(overlay[65536:], bytes.Repeat([]byte{0x5a}, 4096))
+
+	srv := httptest.NewServer(http.FileServer(http.Dir(testdir)))
+	defer srv.Close()
+
+	// the backing file is resolved relative to the URL.
+	d, err := vdisk.Open(srv.URL + "/overlay.qcow2")
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer d.Close()
+	if d.Format != vdisk.FormatQcow2 {
+		t.Errorf("format mismatch\n given :%s\n expect:%s", d.Format, vdisk.FormatQcow2)
+	}
+	checkImage(t, "url", d, overlay)
+}
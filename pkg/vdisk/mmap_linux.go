@@ -0,0 +1,104 @@
+//go:build linux
+// +build linux
+
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// mmapMaxSize is the largest image to be mapped. It is 1GiB on 32-bit platforms.
+// Large images can't be mapped into the address space of 32-bit platforms.
+const mmapMaxSize = int64(^uint(0) >> 2)
+
+// Mmap is a reader of the file mapped into memory.
+// It avoids the syscall of each read when scanning many images.
+// The file must not be truncated while it is mapped.
+type Mmap struct {
+	*io.SectionReader
+	mu   sync.RWMutex
+	data []byte
+}
+
+// NewMmap maps the regular file or the block device f whose size is size.
+// It returns ErrUnsupported if the file can't be mapped, e.g. it is too large for the platform.
+// The whole file is mapped at once, so the image larger than 1GiB on 32-bit platforms
+// is not mapped and Open reads the whole image through os.File instead.
+func NewMmap(f *os.File, size int64) (*Mmap, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("NewMmap:%w", err)
+	}
+	switch {
+	case st.Mode().IsRegular():
+		if size > st.Size() {
+			return nil, fmt.Errorf("NewMmap:size %d exceeds the file size %d", size, st.Size())
+		}
+	case st.Mode()&os.ModeDevice != 0 && st.Mode()&os.ModeCharDevice == 0:
+	default:
+		return nil, fmt.Errorf("NewMmap:%w. mode %s", ErrUnsupported, st.Mode())
+	}
+	if size <= 0 || size > mmapMaxSize {
+		return nil, fmt.Errorf("NewMmap:%w. size %d", ErrUnsupported, size)
+	}
+
+	b, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, fmt.Errorf("NewMmap:%w. %s", ErrUnsupported, err)
+	}
+	m := &Mmap{data: b}
+	m.SectionReader = io.NewSectionReader(m, 0, size)
+	return m, nil
+}
+
+// ReadAt reads the mapped file.
+func (m *Mmap) ReadAt(p []byte, off int64) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.data == nil {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset %d", off)
+	}
+	if off >= int64(len(m.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close unmaps the file. The file itself is not closed.
+func (m *Mmap) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.data == nil {
+		return nil
+	}
+	err := syscall.Munmap(m.data)
+	m.data = nil
+	return err
+}
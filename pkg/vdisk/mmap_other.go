@@ -0,0 +1,42 @@
+//go:build !linux
+// +build !linux
+
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Mmap is a reader of the file mapped into memory.
+// It is supported only on Linux.
+type Mmap struct {
+	*io.SectionReader
+}
+
+// NewMmap always returns ErrUnsupported on this platform.
+func NewMmap(f *os.File, size int64) (*Mmap, error) {
+	return nil, fmt.Errorf("NewMmap:%w. platform", ErrUnsupported)
+}
+
+// Close does nothing.
+func (m *Mmap) Close() error {
+	return nil
+}
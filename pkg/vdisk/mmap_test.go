@@ -0,0 +1,87 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestNewMmap(t *testing.T) {
+	f, err := os.Open(filepath.Join("..", "gpt", "testdata", "gpt_sample.bin"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer f.Close()
+	sample := readSample(t)
+
+	m, err := vdisk.NewMmap(f, int64(len(sample)))
+	if runtime.GOOS != "linux" {
+		if !errors.Is(err, vdisk.ErrUnsupported) {
+			t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrUnsupported)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("NewMmap err:%s", err)
+	}
+	checkImage(t, "mmap", m, sample)
+	if err := m.Close(); err != nil {
+		t.Errorf("Close err:%s", err)
+	}
+	if _, err := m.ReadAt(make([]byte, 512), 0); err == nil {
+		t.Errorf("it should be error after Close")
+	}
+
+	if _, err := vdisk.NewMmap(f, int64(len(sample))+1); err == nil {
+		t.Errorf("it should be error. size exceeds the file")
+	}
+}
+
+func TestNewMmapEmpty(t *testing.T) {
+	f, err := ioutil.TempFile("", "mmap")
+	if err != nil {
+		t.Fatalf("TempFile err:%s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// empty file can't be mapped. Open falls back to the file.
+	if _, err := vdisk.NewMmap(f, 0); !errors.Is(err, vdisk.ErrUnsupported) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrUnsupported)
+	}
+
+	d, err := vdisk.Open(f.Name())
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer d.Close()
+	if d.Format != vdisk.FormatRaw {
+		t.Errorf("format mismatch\n given :%s\n expect:%s", d.Format, vdisk.FormatRaw)
+	}
+	if d.Size() != 0 {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", d.Size(), 0)
+	}
+	if b, err := ioutil.ReadAll(d); err != nil || len(b) != 0 {
+		t.Errorf("content mismatch. %d bytes err:%v", len(b), err)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const qcow2Magic = "QFI\xfb"
+
+// BackingResolver opens the backing file name of an image.
+// format is the format written in the image or "" if unknown.
+type BackingResolver func(name string, format Format) (Image, error)
+
+// Qcow2Header represents the header of qcow2.
+// Fields after NbSnapshots are only for version 3.
+// ref: https://gitlab.com/qemu-project/qemu/-/blob/master/docs/interop/qcow2.txt
+type Qcow2Header struct {
+	Magic                 [4]byte
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+	CompatibleFeatures    uint64
+	AutoclearFeatures     uint64
+	RefcountOrder         uint32
+	HeaderLength          uint32
+}
+
+// Incompatible feature bits of qcow2.
+const (
+	Qcow2IncompatDirty           = uint64(1) << 0
+	Qcow2IncompatCorrupt         = uint64(1) << 1
+	Qcow2IncompatExternalData    = uint64(1) << 2
+	Qcow2IncompatCompressionType = uint64(1) << 3
+	Qcow2IncompatExtendedL2      = uint64(1) << 4
+)
+
+const (
+	qcow2ExtEnd           = 0
+	qcow2ExtBackingFormat = 0xe2792aca
+	qcow2OffsetMask       = 0x00fffffffffffe00
+	qcow2Compressed       = uint64(1) << 62
+	qcow2ZeroFlag         = uint64(1) << 0
+	qcow2MaxL2Cache       = 64
+)
+
+// Qcow2 is a reader of qcow2 image.
+type Qcow2 struct {
+	*io.SectionReader
+	Header        Qcow2Header
+	BackingFile   string
+	BackingFormat Format
+
+	r           io.ReaderAt
+	backing     Image
+	clusterSize int64
+	l1          []uint64
+
+	mu       sync.Mutex
+	l2Cache  map[uint64][]uint64
+	lastComp uint64 // L2 entry of the last decompressed cluster.
+	compBuf  []byte
+}
+
+// NewQcow2 returns a reader of qcow2 image r.
+// resolve is called to open the backing file. It can be nil if r has no backing file.
+func NewQcow2(r io.ReaderAt, resolve BackingResolver) (*Qcow2, error) {
+	q := &Qcow2{r: r, l2Cache: map[uint64][]uint64{}}
+
+	b := make([]byte, binary.Size(q.Header))
+	if _, err := r.ReadAt(b, 0); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("NewQcow2:%w", err)
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, &q.Header); err != nil {
+		return nil, fmt.Errorf("NewQcow2:%w", err)
+	}
+	h := &q.Header
+	if string(h.Magic[:]) != qcow2Magic {
+		return nil, fmt.Errorf("Not qcow2")
+	}
+	switch h.Version {
+	case 2:
+		h.IncompatibleFeatures, h.CompatibleFeatures, h.AutoclearFeatures = 0, 0, 0
+		h.RefcountOrder, h.HeaderLength = 4, 72
+	case 3:
+	default:
+		return nil, fmt.Errorf("NewQcow2:%w. version %d", ErrUnsupported, h.Version)
+	}
+	if h.ClusterBits < 9 || h.ClusterBits > 21 {
+		return nil, fmt.Errorf("NewQcow2:invalid cluster bits %d", h.ClusterBits)
+	}
+	if h.CryptMethod != 0 {
+		return nil, fmt.Errorf("NewQcow2:%w. encryption", ErrUnsupported)
+	}
+	if f := h.IncompatibleFeatures &^ (Qcow2IncompatDirty | Qcow2IncompatCorrupt | Qcow2IncompatCompressionType); f != 0 {
+		return nil, fmt.Errorf("NewQcow2:%w. incompatible features 0x%x", ErrUnsupported, f)
+	}
+	if h.IncompatibleFeatures&Qcow2IncompatCompressionType != 0 && h.HeaderLength > 104 {
+		t := make([]byte, 1)
+		if _, err := r.ReadAt(t, 104); err != nil {
+			return nil, fmt.Errorf("NewQcow2:%w", err)
+		}
+		if t[0] != 0 {
+			return nil, fmt.Errorf("NewQcow2:%w. compression type %d", ErrUnsupported, t[0])
+		}
+	}
+	q.clusterSize = int64(1) << h.ClusterBits
+
+	if err := q.readExtensions(); err != nil {
+		return nil, fmt.Errorf("NewQcow2:%w", err)
+	}
+
+	// L1 table
+	if uint64(h.L1Size)*8 > 32*1024*1024 {
+		return nil, fmt.Errorf("NewQcow2:too large L1 table. %d entries", h.L1Size)
+	}
+	l1 := make([]byte, int64(h.L1Size)*8)
+	if _, err := r.ReadAt(l1, int64(h.L1TableOffset)); err != nil {
+		return nil, fmt.Errorf("NewQcow2:L1 table:%w", err)
+	}
+	q.l1 = make([]uint64, h.L1Size)
+	for i := range q.l1 {
+		q.l1[i] = binary.BigEndian.Uint64(l1[i*8:])
+	}
+
+	// backing file
+	if h.BackingFileOffset != 0 && h.BackingFileSize > 0 {
+		if h.BackingFileSize > 1023 {
+			return nil, fmt.Errorf("NewQcow2:too long backing file name")
+		}
+		name := make([]byte, h.BackingFileSize)
+		if _, err := r.ReadAt(name, int64(h.BackingFileOffset)); err != nil {
+			return nil, fmt.Errorf("NewQcow2:backing file:%w", err)
+		}
+		q.BackingFile = string(name)
+		if resolve == nil {
+			return nil, fmt.Errorf("NewQcow2:no resolver for backing file %s", q.BackingFile)
+		}
+		img, err := resolve(q.BackingFile, q.BackingFormat)
+		if err != nil {
+			return nil, fmt.Errorf("NewQcow2:backing file %s:%w", q.BackingFile, err)
+		}
+		q.backing = img
+	}
+
+	q.SectionReader = io.NewSectionReader(q, 0, int64(h.Size))
+	return q, nil
+}
+
+// readExtensions reads header extensions.
+func (q *Qcow2) readExtensions() error {
+	off := int64(q.Header.HeaderLength)
+	for off+8 <= q.clusterSize {
+		b := make([]byte, 8)
+		if _, err := q.r.ReadAt(b, off); err != nil {
+			return err
+		}
+		typ, n := binary.BigEndian.Uint32(b), int64(binary.BigEndian.Uint32(b[4:]))
+		if typ == qcow2ExtEnd {
+			return nil
+		}
+		if typ == qcow2ExtBackingFormat && n > 0 && n < 256 {
+			f := make([]byte, n)
+			if _, err := q.r.ReadAt(f, off+8); err != nil {
+				return err
+			}
+			q.BackingFormat = Format(f)
+		}
+		off += 8 + (n+7)/8*8
+	}
+	return nil
+}
+
+// l2Table returns the L2 table at off.
+func (q *Qcow2) l2Table(off uint64) ([]uint64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t, ok := q.l2Cache[off]; ok {
+		return t, nil
+	}
+
+	b := make([]byte, q.clusterSize)
+	if _, err := q.r.ReadAt(b, int64(off)); err != nil {
+		return nil, fmt.Errorf("L2 table:%w", err)
+	}
+	t := make([]uint64, q.clusterSize/8)
+	for i := range t {
+		t[i] = binary.BigEndian.Uint64(b[i*8:])
+	}
+	if len(q.l2Cache) >= qcow2MaxL2Cache {
+		q.l2Cache = map[uint64][]uint64{}
+	}
+	q.l2Cache[off] = t
+	return t, nil
+}
+
+// readCompressed decompresses the cluster of the L2 entry e.
+// The last decompressed cluster is cached since a cluster is read in small pieces.
+func (q *Qcow2) readCompressed(e uint64) ([]byte, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.compBuf != nil && q.lastComp == e {
+		return q.compBuf, nil
+	}
+
+	x := 62 - (q.Header.ClusterBits - 8)
+	off := int64(e & (uint64(1)<<x - 1))
+	sectors := int64(e>>x&(uint64(1)<<(q.Header.ClusterBits-8)-1)) + 1
+	n := sectors*512 - off%512
+
+	b := make([]byte, n)
+	m, err := q.r.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	ret := make([]byte, q.clusterSize)
+	if _, err := io.ReadFull(flate.NewReader(bytes.NewReader(b[:m])), ret); err != nil {
+		return nil, fmt.Errorf("compressed cluster at 0x%x:%w", off, err)
+	}
+	q.lastComp, q.compBuf = e, ret
+	return ret, nil
+}
+
+// readCluster reads p from the cluster which contains off.
+// p must not cross the cluster boundary.
+func (q *Qcow2) readCluster(p []byte, off int64) error {
+	c := uint64(off) >> q.Header.ClusterBits
+	in := off & (q.clusterSize - 1)
+	l2Entries := uint64(q.clusterSize / 8)
+
+	e := uint64(0)
+	if i := c / l2Entries; i < uint64(len(q.l1)) {
+		if l2 := q.l1[i] & qcow2OffsetMask; l2 != 0 {
+			t, err := q.l2Table(l2)
+			if err != nil {
+				return err
+			}
+			e = t[c%l2Entries]
+		}
+	}
+
+	switch {
+	case e&qcow2Compressed != 0:
+		b, err := q.readCompressed(e)
+		if err != nil {
+			return err
+		}
+		copy(p, b[in:])
+	case e&qcow2ZeroFlag != 0:
+		zero(p)
+	case e&qcow2OffsetMask != 0:
+		if _, err := q.r.ReadAt(p, int64(e&qcow2OffsetMask)+in); err != nil {
+			return err
+		}
+	default:
+		// unallocated
+		return readBacking(q.backing, p, off)
+	}
+	return nil
+}
+
+// ReadAt reads the virtual disk.
+func (q *Qcow2) ReadAt(p []byte, off int64) (int, error) {
+	return readClusters(p, off, int64(q.Header.Size), q.clusterSize, q.readCluster)
+}
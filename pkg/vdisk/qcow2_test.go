@@ -0,0 +1,59 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewQcow2(t *testing.T) {
+	f, err := os.Open(filepath.Join(testdir, "overlay.qcow2"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer f.Close()
+
+	if _, err := vdisk.NewQcow2(f, nil); err == nil {
+		t.Errorf("it should be error without resolver")
+	}
+
+	base, err := vdisk.Open(filepath.Join(testdir, "gpt_sample.qcow2"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer base.Close()
+	q, err := vdisk.NewQcow2(f, func(name string, format vdisk.Format) (vdisk.Image, error) {
+		if name != "gpt_sample.qcow2" || format != vdisk.FormatQcow2 {
+			t.Errorf("backing file mismatch. name=%s format=%s", name, format)
+		}
+		return base, nil
+	})
+	if err != nil {
+		t.Fatalf("NewQcow2 err:%s", err)
+	}
+	if q.Header.Version != 2 || q.BackingFile != "gpt_sample.qcow2" {
+		t.Errorf("header mismatch. %+v", q.Header)
+	}
+
+	if _, err := vdisk.NewQcow2(bytes.NewReader(readSample(t)), nil); err == nil {
+		t.Errorf("it should be error for raw image")
+	}
+}
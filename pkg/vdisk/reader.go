@@ -0,0 +1,74 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"fmt"
+	"io"
+)
+
+// zero fills p with 0.
+func zero(p []byte) {
+	for i := range p {
+		p[i] = 0
+	}
+}
+
+// readBacking reads p at off from the backing image.
+// The area which the backing image doesn't have is filled with 0.
+func readBacking(b Image, p []byte, off int64) error {
+	zero(p)
+	if b == nil || off >= b.Size() {
+		return nil
+	}
+	if n := b.Size() - off; int64(len(p)) > n {
+		p = p[:n]
+	}
+	_, err := b.ReadAt(p, off)
+	return err
+}
+
+// readClusters implements io.ReaderAt of the image whose size is size.
+// read is called for each block which doesn't cross the boundary of blockSize.
+func readClusters(p []byte, off int64, size int64, blockSize int64, read func(p []byte, off int64) error) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset %d", off)
+	}
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if n := size - off; int64(len(p)) > n {
+		p = p[:n]
+		eof = io.EOF
+	}
+
+	ret := 0
+	for len(p) > 0 {
+		n := blockSize - off%blockSize
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		if err := read(p[:n], off); err != nil {
+			return ret, err
+		}
+		ret += int(n)
+		off += n
+		p = p[n:]
+	}
+	return ret, eof
+}
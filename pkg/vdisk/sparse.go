@@ -0,0 +1,271 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// AndroidSparseMagic is the magic number of Android sparse image.
+const AndroidSparseMagic = 0xed26ff3a
+
+// Chunk types of Android sparse image.
+const (
+	SparseChunkRaw      = 0xcac1
+	SparseChunkFill     = 0xcac2
+	SparseChunkDontCare = 0xcac3
+	SparseChunkCrc32    = 0xcac4
+)
+
+const (
+	sparseHeaderSize      = 28
+	sparseChunkHeaderSize = 12
+)
+
+// SparseHeader represents the file header of Android sparse image.
+// ref: system/core/libsparse/sparse_format.h
+type SparseHeader struct {
+	Magic           uint32
+	MajorVersion    uint16
+	MinorVersion    uint16
+	FileHeaderSize  uint16
+	ChunkHeaderSize uint16
+	BlockSize       uint32
+	TotalBlocks     uint32
+	TotalChunks     uint32
+	ImageChecksum   uint32
+}
+
+// SparseChunkHeader represents the header of a chunk.
+type SparseChunkHeader struct {
+	ChunkType uint16
+	Reserved  uint16
+	ChunkSize uint32 // in blocks
+	TotalSize uint32 // in bytes including the chunk header
+}
+
+// sparseChunk represents a chunk mapped to the expanded image.
+type sparseChunk struct {
+	typ    uint16
+	block  uint32 // the first block in the expanded image
+	blocks uint32
+	offset int64 // offset of the data in the sparse image
+	fill   [4]byte
+}
+
+// AndroidSparse is a reader of Android sparse image.
+type AndroidSparse struct {
+	*io.SectionReader
+	Header SparseHeader
+
+	r      io.ReaderAt
+	chunks []sparseChunk
+}
+
+// isAndroidSparse reports whether r has the magic of Android sparse image.
+func isAndroidSparse(r io.ReaderAt) bool {
+	b := make([]byte, 4)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(b) == AndroidSparseMagic
+}
+
+// NewAndroidSparse returns a reader of the expanded image of Android sparse image r.
+func NewAndroidSparse(r io.ReaderAt) (*AndroidSparse, error) {
+	s := &AndroidSparse{r: r}
+
+	b := make([]byte, sparseHeaderSize)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("NewAndroidSparse:%w", err)
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &s.Header); err != nil {
+		return nil, fmt.Errorf("NewAndroidSparse:%w", err)
+	}
+	h := &s.Header
+	if h.Magic != AndroidSparseMagic {
+		return nil, fmt.Errorf("Not Android sparse image")
+	}
+	if h.MajorVersion != 1 {
+		return nil, fmt.Errorf("NewAndroidSparse:%w. version %d.%d", ErrUnsupported, h.MajorVersion, h.MinorVersion)
+	}
+	if h.FileHeaderSize < sparseHeaderSize || h.ChunkHeaderSize < sparseChunkHeaderSize {
+		return nil, fmt.Errorf("NewAndroidSparse:invalid header size")
+	}
+	if h.BlockSize == 0 || h.BlockSize%4 != 0 {
+		return nil, fmt.Errorf("NewAndroidSparse:invalid block size %d", h.BlockSize)
+	}
+
+	off := int64(h.FileHeaderSize)
+	block := uint64(0)
+	for i := uint32(0); i < h.TotalChunks; i++ {
+		c := SparseChunkHeader{}
+		cb := make([]byte, sparseChunkHeaderSize+4)
+		n, err := r.ReadAt(cb, off)
+		if n < sparseChunkHeaderSize {
+			return nil, fmt.Errorf("NewAndroidSparse:chunk %d:%w", i, err)
+		}
+		if err := binary.Read(bytes.NewReader(cb), binary.LittleEndian, &c); err != nil {
+			return nil, fmt.Errorf("NewAndroidSparse:chunk %d:%w", i, err)
+		}
+		// off must advance. Otherwise a broken image loops TotalChunks times at the same chunk.
+		if c.TotalSize < uint32(h.ChunkHeaderSize) {
+			return nil, fmt.Errorf("NewAndroidSparse:chunk %d:invalid chunk size %d", i, c.TotalSize)
+		}
+		if block+uint64(c.ChunkSize) > uint64(h.TotalBlocks) {
+			return nil, fmt.Errorf("NewAndroidSparse:chunk %d:blocks exceed total %d", i, h.TotalBlocks)
+		}
+		data := off + int64(h.ChunkHeaderSize)
+		size := int64(c.ChunkSize) * int64(h.BlockSize)
+
+		sc := sparseChunk{typ: c.ChunkType, block: uint32(block), blocks: c.ChunkSize, offset: data}
+		switch c.ChunkType {
+		case SparseChunkRaw:
+			if int64(c.TotalSize) != int64(h.ChunkHeaderSize)+size {
+				return nil, fmt.Errorf("NewAndroidSparse:chunk %d:invalid raw chunk size %d", i, c.TotalSize)
+			}
+		case SparseChunkFill:
+			if n < sparseChunkHeaderSize+4 || c.TotalSize != uint32(h.ChunkHeaderSize)+4 {
+				return nil, fmt.Errorf("NewAndroidSparse:chunk %d:invalid fill chunk", i)
+			}
+			fill := make([]byte, 4)
+			if _, err := r.ReadAt(fill, data); err != nil {
+				return nil, fmt.Errorf("NewAndroidSparse:chunk %d:%w", i, err)
+			}
+			copy(sc.fill[:], fill)
+		case SparseChunkDontCare:
+			if c.TotalSize != uint32(h.ChunkHeaderSize) {
+				return nil, fmt.Errorf("NewAndroidSparse:chunk %d:invalid don't care chunk size %d", i, c.TotalSize)
+			}
+		case SparseChunkCrc32:
+			if c.TotalSize != uint32(h.ChunkHeaderSize)+4 {
+				return nil, fmt.Errorf("NewAndroidSparse:chunk %d:invalid crc32 chunk size %d", i, c.TotalSize)
+			}
+		default:
+			return nil, fmt.Errorf("NewAndroidSparse:chunk %d:unknown chunk type 0x%x", i, c.ChunkType)
+		}
+		if c.ChunkType != SparseChunkCrc32 && c.ChunkSize > 0 {
+			s.chunks = append(s.chunks, sc)
+		}
+		block += uint64(c.ChunkSize)
+		off += int64(c.TotalSize)
+	}
+	if block != uint64(h.TotalBlocks) {
+		return nil, fmt.Errorf("NewAndroidSparse:block count mismatch. header=%d chunks=%d", h.TotalBlocks, block)
+	}
+
+	s.SectionReader = io.NewSectionReader(s, 0, int64(h.TotalBlocks)*int64(h.BlockSize))
+	return s, nil
+}
+
+// readChunk reads p from the block which contains off.
+func (s *AndroidSparse) readChunk(p []byte, off int64) error {
+	bs := int64(s.Header.BlockSize)
+	block := uint32(off / bs)
+	i := sort.Search(len(s.chunks), func(i int) bool { return s.chunks[i].block+s.chunks[i].blocks > block })
+	if i == len(s.chunks) {
+		zero(p)
+		return nil
+	}
+	c := s.chunks[i]
+	switch c.typ {
+	case SparseChunkRaw:
+		_, err := s.r.ReadAt(p, c.offset+off-int64(c.block)*bs)
+		return err
+	case SparseChunkFill:
+		// the block size is a multiple of 4.
+		for j := range p {
+			p[j] = c.fill[(off+int64(j))%4]
+		}
+		return nil
+	}
+	zero(p)
+	return nil
+}
+
+// ReadAt reads the expanded image.
+func (s *AndroidSparse) ReadAt(p []byte, off int64) (int, error) {
+	return readClusters(p, off, s.Size(), int64(s.Header.BlockSize), s.readChunk)
+}
+
+// sparseRun represents continuous blocks of the same kind.
+type sparseRun struct {
+	zero   bool
+	blocks uint32
+}
+
+// WriteAndroidSparse converts the raw image r whose size is size into Android sparse image.
+// Zero blocks are written as DONT_CARE chunks. size must be a multiple of blockSize.
+func WriteAndroidSparse(w io.Writer, r io.ReaderAt, size int64, blockSize uint32) error {
+	bs := int64(blockSize)
+	if bs == 0 || bs%4 != 0 {
+		return fmt.Errorf("WriteAndroidSparse:invalid block size %d", blockSize)
+	}
+	if size%bs != 0 {
+		return fmt.Errorf("WriteAndroidSparse:size %d is not a multiple of block size %d", size, blockSize)
+	}
+	if size/bs > 0xffffffff {
+		return fmt.Errorf("WriteAndroidSparse:too large image")
+	}
+
+	// The header needs the number of chunks.
+	runs := []sparseRun{}
+	b := make([]byte, bs)
+	zeros := make([]byte, bs)
+	for off := int64(0); off < size; off += bs {
+		if _, err := r.ReadAt(b, off); err != nil && err != io.EOF {
+			return fmt.Errorf("WriteAndroidSparse:%w", err)
+		}
+		z := bytes.Equal(b, zeros)
+		// a raw chunk is limited by uint32 of the total size.
+		if n := len(runs); n > 0 && runs[n-1].zero == z && (z || (int64(runs[n-1].blocks)+1)*bs+sparseChunkHeaderSize <= 0xffffffff) {
+			runs[n-1].blocks++
+			continue
+		}
+		runs = append(runs, sparseRun{z, 1})
+	}
+
+	h := SparseHeader{Magic: AndroidSparseMagic, MajorVersion: 1, FileHeaderSize: sparseHeaderSize,
+		ChunkHeaderSize: sparseChunkHeaderSize, BlockSize: blockSize, TotalBlocks: uint32(size / bs), TotalChunks: uint32(len(runs))}
+	if err := binary.Write(w, binary.LittleEndian, &h); err != nil {
+		return fmt.Errorf("WriteAndroidSparse:%w", err)
+	}
+
+	off := int64(0)
+	for _, run := range runs {
+		n := int64(run.blocks) * bs
+		c := SparseChunkHeader{ChunkType: SparseChunkDontCare, ChunkSize: run.blocks, TotalSize: sparseChunkHeaderSize}
+		if !run.zero {
+			c.ChunkType = SparseChunkRaw
+			c.TotalSize += uint32(n)
+		}
+		if err := binary.Write(w, binary.LittleEndian, &c); err != nil {
+			return fmt.Errorf("WriteAndroidSparse:%w", err)
+		}
+		if !run.zero {
+			if _, err := io.Copy(w, io.NewSectionReader(r, off, n)); err != nil {
+				return fmt.Errorf("WriteAndroidSparse:%w", err)
+			}
+		}
+		off += n
+	}
+	return nil
+}
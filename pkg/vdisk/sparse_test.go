@@ -0,0 +1,155 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewAndroidSparse(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "gpt_sample.simg"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	s, err := vdisk.NewAndroidSparse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewAndroidSparse err:%s", err)
+	}
+	if s.Header.BlockSize != 4096 || s.Size() != 131072 {
+		t.Errorf("header mismatch. %+v", s.Header)
+	}
+	checkImage(t, "simg", s, readSample(t))
+
+	if _, err := vdisk.NewAndroidSparse(bytes.NewReader(make([]byte, 1024))); err == nil {
+		t.Errorf("it should be error")
+	}
+	// truncate the chunks
+	if _, err := vdisk.NewAndroidSparse(bytes.NewReader(b[:100])); err == nil {
+		t.Errorf("it should be error")
+	}
+}
+
+func TestWriteAndroidSparse(t *testing.T) {
+	sample := readSample(t)
+
+	buf := &bytes.Buffer{}
+	if err := vdisk.WriteAndroidSparse(buf, bytes.NewReader(sample), int64(len(sample)), 4096); err != nil {
+		t.Fatalf("WriteAndroidSparse err:%s", err)
+	}
+	if buf.Len() >= len(sample) {
+		t.Errorf("it should be smaller. %d >= %d", buf.Len(), len(sample))
+	}
+	if f := vdisk.Detect(bytes.NewReader(buf.Bytes()), int64(buf.Len())); f != vdisk.FormatAndroidSparse {
+		t.Errorf("format mismatch\n given :%s\n expect:%s", f, vdisk.FormatAndroidSparse)
+	}
+
+	s, err := vdisk.NewAndroidSparse(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewAndroidSparse err:%s", err)
+	}
+	checkImage(t, "round trip", s, sample)
+
+	if err := vdisk.WriteAndroidSparse(&bytes.Buffer{}, bytes.NewReader(sample), 1000, 4096); err == nil {
+		t.Errorf("it should be error. size is not a multiple of block size")
+	}
+}
+
+// newSparseImage returns Android sparse image which has chunks.
+// fill is used as the data of FILL chunks.
+func newSparseImage(t *testing.T, blocks uint32, chunks []vdisk.SparseChunkHeader, fill []byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	h := vdisk.SparseHeader{Magic: vdisk.AndroidSparseMagic, MajorVersion: 1, FileHeaderSize: 28, ChunkHeaderSize: 12,
+		BlockSize: 4096, TotalBlocks: blocks, TotalChunks: uint32(len(chunks))}
+	if err := binary.Write(buf, binary.LittleEndian, &h); err != nil {
+		t.Fatalf("binary.Write err:%s", err)
+	}
+	for _, c := range chunks {
+		if err := binary.Write(buf, binary.LittleEndian, &c); err != nil {
+			t.Fatalf("binary.Write err:%s", err)
+		}
+		if c.ChunkType == vdisk.SparseChunkFill {
+			buf.Write(fill)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestAndroidSparseFill(t *testing.T) {
+	fill := []byte{0x01, 0x02, 0x03, 0x04}
+	b := newSparseImage(t, 3, []vdisk.SparseChunkHeader{
+		{ChunkType: vdisk.SparseChunkFill, ChunkSize: 2, TotalSize: 16},
+		{ChunkType: vdisk.SparseChunkDontCare, ChunkSize: 1, TotalSize: 12},
+	}, fill)
+	s, err := vdisk.NewAndroidSparse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewAndroidSparse err:%s", err)
+	}
+
+	expect := append(bytes.Repeat(fill, 2*4096/4), make([]byte, 4096)...)
+	if got, err := ioutil.ReadAll(s); err != nil || !bytes.Equal(got, expect) {
+		t.Errorf("content mismatch. err=%v", err)
+	}
+
+	// unaligned read across the FILL and DONT_CARE chunks
+	p := make([]byte, 10)
+	if _, err := s.ReadAt(p, 2*4096-5); err != nil {
+		t.Fatalf("ReadAt err:%s", err)
+	}
+	if !bytes.Equal(p, expect[2*4096-5:2*4096+5]) {
+		t.Errorf("data mismatch\n given :%x\n expect:%x", p, expect[2*4096-5:2*4096+5])
+	}
+}
+
+func TestAndroidSparseTooManyBlocks(t *testing.T) {
+	// 0xffffffff + 2 blocks wraps to 1 in uint32.
+	b := newSparseImage(t, 1, []vdisk.SparseChunkHeader{
+		{ChunkType: vdisk.SparseChunkDontCare, ChunkSize: 0xffffffff, TotalSize: 12},
+		{ChunkType: vdisk.SparseChunkDontCare, ChunkSize: 2, TotalSize: 12},
+	}, nil)
+	if _, err := vdisk.NewAndroidSparse(bytes.NewReader(b)); err == nil {
+		t.Errorf("it should be error")
+	}
+}
+
+func TestAndroidSparseBrokenChunkSize(t *testing.T) {
+	type testcase struct {
+		name  string
+		chunk vdisk.SparseChunkHeader
+	}
+
+	cases := []testcase{
+		{"zero size DONT_CARE", vdisk.SparseChunkHeader{ChunkType: vdisk.SparseChunkDontCare, TotalSize: 0}},
+		{"zero size CRC32", vdisk.SparseChunkHeader{ChunkType: vdisk.SparseChunkCrc32, TotalSize: 0}},
+		{"large DONT_CARE", vdisk.SparseChunkHeader{ChunkType: vdisk.SparseChunkDontCare, TotalSize: 16}},
+		{"empty chunks to EOF", vdisk.SparseChunkHeader{ChunkType: vdisk.SparseChunkDontCare, TotalSize: 12}},
+	}
+
+	for _, v := range cases {
+		b := newSparseImage(t, 0, []vdisk.SparseChunkHeader{v.chunk}, nil)
+		// TotalChunks
+		binary.LittleEndian.PutUint32(b[20:], 0xffffffff)
+		if _, err := vdisk.NewAndroidSparse(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s:it should be error", v.name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// Split presents segments of a split raw image as one contiguous disk.
+type Split struct {
+	*io.SectionReader
+	parts   []io.ReaderAt
+	offsets []int64 // start offset of each part. The last element is the total size.
+}
+
+// NewSplit returns the concatenation of parts. sizes are the sizes of each part.
+func NewSplit(parts []io.ReaderAt, sizes []int64) (*Split, error) {
+	if len(parts) == 0 || len(parts) != len(sizes) {
+		return nil, fmt.Errorf("NewSplit:invalid number of parts. parts=%d sizes=%d", len(parts), len(sizes))
+	}
+	s := &Split{parts: parts, offsets: []int64{0}}
+	for i, v := range sizes {
+		if v < 0 {
+			return nil, fmt.Errorf("NewSplit:invalid size %d of part %d", v, i)
+		}
+		s.offsets = append(s.offsets, s.offsets[i]+v)
+	}
+	s.SectionReader = io.NewSectionReader(s, 0, s.offsets[len(parts)])
+	return s, nil
+}
+
+// ReadAt reads the concatenated disk.
+func (s *Split) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset %d", off)
+	}
+	size := s.offsets[len(s.parts)]
+	if off >= size {
+		return 0, io.EOF
+	}
+	var eof error
+	if n := size - off; int64(len(p)) > n {
+		p = p[:n]
+		eof = io.EOF
+	}
+
+	ret := 0
+	for len(p) > 0 {
+		// the part which contains off. Empty parts are skipped.
+		i := sort.Search(len(s.parts), func(i int) bool { return s.offsets[i+1] > off })
+		n := s.offsets[i+1] - off
+		if n > int64(len(p)) {
+			n = int64(len(p))
+		}
+		if _, err := s.parts[i].ReadAt(p[:n], off-s.offsets[i]); err != nil {
+			return ret, fmt.Errorf("part %d:%w", i, err)
+		}
+		ret += int(n)
+		off += n
+		p = p[n:]
+	}
+	return ret, eof
+}
+
+// nextSplitExt returns the extension of the next segment.
+// It supports numbered extensions (e.g. "001") and alphabetical ones (e.g. "aa").
+func nextSplitExt(ext string) (string, bool) {
+	if n, err := strconv.ParseUint(ext, 10, 32); err == nil {
+		s := fmt.Sprintf("%0*d", len(ext), n+1)
+		return s, len(s) == len(ext)
+	}
+	b := []byte(ext)
+	for i := len(b) - 1; i >= 0; i-- {
+		switch {
+		case b[i] >= 'a' && b[i] < 'z', b[i] >= 'A' && b[i] < 'Z':
+			b[i]++
+			return string(b), true
+		case b[i] == 'z':
+			b[i] = 'a'
+		case b[i] == 'Z':
+			b[i] = 'A'
+		default:
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// SplitPaths returns the paths of the segments of the split image whose first segment is path.
+// path must end with ".001" (or ".000", ".01" ...) or ".aa".
+// It returns only path if path is not the first segment or the next segment doesn't exist.
+func SplitPaths(path string) []string {
+	ret := []string{path}
+	ext := filepath.Ext(path)
+	if len(ext) < 3 {
+		return ret
+	}
+	ext = ext[1:]
+	base := path[:len(path)-len(ext)]
+	if n, err := strconv.ParseUint(ext, 10, 32); err == nil {
+		if n > 1 {
+			return ret
+		}
+	} else if ext != "aa" && ext != "AA" {
+		return ret
+	}
+
+	for {
+		var ok bool
+		if ext, ok = nextSplitExt(ext); !ok {
+			break
+		}
+		if _, err := os.Stat(base + ext); err != nil {
+			break
+		}
+		ret = append(ret, base+ext)
+	}
+	return ret
+}
@@ -0,0 +1,106 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSplit writes b into the files which have names and returns their paths.
+// The last file has the rest of b.
+func writeSplit(t *testing.T, dir string, b []byte, names []string, sizes []int) []string {
+	t.Helper()
+	ret := []string{}
+	for i, v := range names {
+		n := len(b)
+		if i < len(sizes) {
+			n = sizes[i]
+		}
+		p := filepath.Join(dir, v)
+		if err := ioutil.WriteFile(p, b[:n], 0644); err != nil {
+			t.Fatalf("WriteFile err:%s", err)
+		}
+		b = b[n:]
+		ret = append(ret, p)
+	}
+	return ret
+}
+
+func TestNewSplit(t *testing.T) {
+	sample := readSample(t)
+	parts := []io.ReaderAt{}
+	sizes := []int64{}
+	// an empty part and a part which is not aligned to sectors.
+	for _, v := range [][2]int{{0, 4000}, {4000, 4000}, {4000, 70000}, {70000, len(sample)}} {
+		parts = append(parts, bytes.NewReader(sample[v[0]:v[1]]))
+		sizes = append(sizes, int64(v[1]-v[0]))
+	}
+	s, err := vdisk.NewSplit(parts, sizes)
+	if err != nil {
+		t.Fatalf("NewSplit err:%s", err)
+	}
+	checkImage(t, "split", s, sample)
+
+	if _, err := vdisk.NewSplit(parts, sizes[1:]); err == nil {
+		t.Errorf("it should be error")
+	}
+}
+
+func TestSplitPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "split")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+	sample := readSample(t)
+
+	type testcase struct {
+		name   string
+		files  []string
+		first  string
+		expect int
+	}
+
+	cases := []testcase{
+		{"numbered", []string{"disk.001", "disk.002", "disk.003"}, "disk.001", 3},
+		{"alphabetical", []string{"disk.img.aa", "disk.img.ab", "disk.img.ac"}, "disk.img.aa", 3},
+		{"not first", []string{"other.001", "other.002"}, "other.002", 1},
+		{"no segment", []string{"single.img"}, "single.img", 1},
+	}
+
+	for _, v := range cases {
+		writeSplit(t, dir, sample, v.files, []int{4096, 4096})
+		ret := vdisk.SplitPaths(filepath.Join(dir, v.first))
+		if len(ret) != v.expect {
+			t.Errorf("%s:mismatch\n given :%v\n expect:%d paths", v.name, ret, v.expect)
+		}
+	}
+
+	// the backup GPT is in the last segment.
+	d, err := vdisk.Open(filepath.Join(dir, "disk.img.aa"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer d.Close()
+	checkImage(t, "open split", d, sample)
+}
@@ -0,0 +1,147 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotBuffered is returned if the area of a stream image is not kept in memory.
+var ErrNotBuffered = errors.New("not buffered")
+
+// Default sizes of the areas which Stream keeps.
+// They are enough for GPT with 4096 bytes sector and 128 entries.
+const (
+	DefaultStreamHead = 1024 * 1024
+	DefaultStreamTail = 1024 * 1024
+)
+
+// Decompressor represents a compression format of raw images.
+type Decompressor struct {
+	Format Format
+	Magic  string // signature at the beginning of the compressed file.
+	New    func(r io.Reader) (io.Reader, error)
+}
+
+// Decompressors are used by Detect and Open to read compressed raw images.
+// Other formats (e.g. zstd) can be supported by appending a Decompressor.
+var Decompressors = []Decompressor{
+	{FormatGzip, "\x1f\x8b", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+}
+
+// findDecompressor returns the Decompressor of format.
+func findDecompressor(format Format) *Decompressor {
+	for i := range Decompressors {
+		if Decompressors[i].Format == format {
+			return &Decompressors[i]
+		}
+	}
+	return nil
+}
+
+// Stream is an image which is read from io.Reader only once.
+// It keeps the first head bytes and the last tail bytes in memory,
+// so that ReadGpt can read both the primary and backup GPT with bounded memory.
+// Reading other area returns ErrNotBuffered.
+type Stream struct {
+	*io.SectionReader
+	head []byte
+	tail []byte
+	size int64
+}
+
+// NewStream reads r to the end and returns the image.
+func NewStream(r io.Reader, head, tail int64) (*Stream, error) {
+	if head < 0 || tail < 0 {
+		return nil, fmt.Errorf("NewStream:invalid size. head=%d tail=%d", head, tail)
+	}
+	s := &Stream{head: make([]byte, 0, head)}
+
+	// buf holds the last bytes. It is shrunk to tail bytes when it is full.
+	buf := make([]byte, 0, 2*tail+64*1024)
+	b := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			s.size += int64(n)
+			c := b[:n]
+			if rest := int(head) - len(s.head); rest > 0 {
+				if rest > n {
+					rest = n
+				}
+				s.head = append(s.head, c[:rest]...)
+			}
+			if cap(buf)-len(buf) < n {
+				keep := int64(len(buf))
+				if keep > tail {
+					keep = tail
+				}
+				buf = buf[:copy(buf, buf[int64(len(buf))-keep:])]
+			}
+			buf = append(buf, c...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("NewStream:%w", err)
+		}
+	}
+	if int64(len(buf)) > tail {
+		buf = buf[int64(len(buf))-tail:]
+	}
+	s.tail = buf
+
+	s.SectionReader = io.NewSectionReader(s, 0, s.size)
+	return s, nil
+}
+
+// ReadAt reads the buffered area.
+func (s *Stream) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("invalid offset %d", off)
+	}
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	var eof error
+	if n := s.size - off; int64(len(p)) > n {
+		p = p[:n]
+		eof = io.EOF
+	}
+
+	ret := 0
+	tailOff := s.size - int64(len(s.tail))
+	for len(p) > 0 {
+		var n int
+		switch {
+		case off < int64(len(s.head)):
+			n = copy(p, s.head[off:])
+		case off >= tailOff:
+			n = copy(p, s.tail[off-tailOff:])
+		default:
+			return ret, fmt.Errorf("%w. offset=0x%x", ErrNotBuffered, off)
+		}
+		ret += n
+		off += int64(n)
+		p = p[n:]
+	}
+	return ret, eof
+}
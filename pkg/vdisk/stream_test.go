@@ -0,0 +1,96 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"testing"
+)
+
+func TestNewStream(t *testing.T) {
+	sample := readSample(t)
+
+	s, err := vdisk.NewStream(bytes.NewReader(sample), vdisk.DefaultStreamHead, vdisk.DefaultStreamTail)
+	if err != nil {
+		t.Fatalf("NewStream err:%s", err)
+	}
+	checkImage(t, "whole", s, sample)
+}
+
+func TestNewStreamLarge(t *testing.T) {
+	const size = 16 * 1024 * 1024
+	g, err := gpt.NewGpt(size / 512)
+	if err != nil {
+		t.Fatalf("NewGpt err:%s", err)
+	}
+	g.Entries[0] = gpt.Entry{TypeGuid: *gpt.LinuxFilesystemGuid, UniqueGuid: gpt.Guid{1}, FirstLBA: 2048, LastLBA: size/512 - 2048}
+	if err := g.UpdateCrc32(); err != nil {
+		t.Fatalf("UpdateCrc32 err:%s", err)
+	}
+	d := make(memDisk, size)
+	if err := gpt.WriteGpt(d, g); err != nil {
+		t.Fatalf("WriteGpt err:%s", err)
+	}
+
+	// the area between the primary and the backup GPT is not kept.
+	s, err := vdisk.NewStream(bytes.NewReader(d), 64*1024, 64*1024)
+	if err != nil {
+		t.Fatalf("NewStream err:%s", err)
+	}
+	if s.Size() != size {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", s.Size(), size)
+	}
+	b := make([]byte, 512)
+	if _, err := s.ReadAt(b, size/2); !errors.Is(err, vdisk.ErrNotBuffered) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrNotBuffered)
+	}
+
+	rg, err := gpt.ReadGpt(s)
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	if rg.BackupHeader.CurrentLBA != size/512-1 || rg.BackupEntries[0] != g.Entries[0] {
+		t.Errorf("backup GPT mismatch. %+v", rg.BackupHeader)
+	}
+}
+
+func TestOpenGzip(t *testing.T) {
+	d, err := vdisk.Open("testdata/gpt_sample.img.gz")
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer d.Close()
+	if d.Format != vdisk.FormatGzip {
+		t.Errorf("format mismatch\n given :%s\n expect:%s", d.Format, vdisk.FormatGzip)
+	}
+	g, err := gpt.ReadGpt(d)
+	if err != nil {
+		t.Fatalf("ReadGpt err:%s", err)
+	}
+	if g.BackupHeader.CurrentLBA != 255 || len(g.BackupEntries) != len(g.Entries) {
+		t.Errorf("backup GPT mismatch. %+v", g.BackupHeader)
+	}
+}
+
+type memDisk []byte
+
+func (d memDisk) WriteAt(p []byte, off int64) (int, error) {
+	return copy(d[off:], p), nil
+}
@@ -0,0 +1,144 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	vdiSignature  = 0xbeda107f
+	vdiVersion1_1 = 0x00010001
+	vdiBlockFree  = 0xffffffff
+	vdiBlockZero  = 0xfffffffe
+)
+
+// Image types of VDI.
+const (
+	VdiTypeNormal = 1
+	VdiTypeFixed  = 2
+	VdiTypeUndo   = 3
+	VdiTypeDiff   = 4
+)
+
+// VdiHeader represents the pre-header and the header version 1.1 of VDI.
+type VdiHeader struct {
+	Text             [64]byte
+	Signature        uint32
+	Version          uint32
+	HeaderSize       uint32
+	Type             uint32
+	Flags            uint32
+	Comment          [256]byte
+	OffsetBlocks     uint32 // offset of the block map
+	OffsetData       uint32
+	Cylinders        uint32
+	Heads            uint32
+	Sectors          uint32
+	SectorSize       uint32
+	Unused           uint32
+	DiskSize         uint64
+	BlockSize        uint32
+	BlockExtraSize   uint32
+	Blocks           uint32
+	BlocksAllocated  uint32
+	UuidCreate       [16]byte
+	UuidModify       [16]byte
+	UuidLinkage      [16]byte
+	UuidParentModify [16]byte
+}
+
+// Vdi is a reader of VirtualBox VDI image.
+// Differencing images are not supported.
+type Vdi struct {
+	*io.SectionReader
+	Header VdiHeader
+
+	r        io.ReaderAt
+	blockMap []uint32
+}
+
+// isVdi reports whether r has the signature of VDI.
+func isVdi(r io.ReaderAt) bool {
+	b := make([]byte, 4)
+	if _, err := r.ReadAt(b, 0x40); err != nil {
+		return false
+	}
+	return binary.LittleEndian.Uint32(b) == vdiSignature
+}
+
+// NewVdi returns a reader of VDI image r.
+func NewVdi(r io.ReaderAt) (*Vdi, error) {
+	v := &Vdi{r: r}
+
+	b := make([]byte, binary.Size(v.Header))
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return nil, fmt.Errorf("NewVdi:%w", err)
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &v.Header); err != nil {
+		return nil, fmt.Errorf("NewVdi:%w", err)
+	}
+	h := &v.Header
+	if h.Signature != vdiSignature {
+		return nil, fmt.Errorf("Not VDI")
+	}
+	if h.Version != vdiVersion1_1 {
+		return nil, fmt.Errorf("NewVdi:%w. version 0x%x", ErrUnsupported, h.Version)
+	}
+	if h.Type == VdiTypeDiff {
+		return nil, fmt.Errorf("NewVdi:%w. differencing image", ErrUnsupported)
+	}
+	if h.BlockSize == 0 || h.BlockSize&(h.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("NewVdi:invalid block size %d", h.BlockSize)
+	}
+	if uint64(h.Blocks)*uint64(h.BlockSize) < h.DiskSize {
+		return nil, fmt.Errorf("NewVdi:too few blocks %d", h.Blocks)
+	}
+	if h.Blocks > 8*1024*1024 {
+		return nil, fmt.Errorf("NewVdi:too many blocks %d", h.Blocks)
+	}
+
+	m, err := readUint32s(r, int64(h.OffsetBlocks), int(h.Blocks))
+	if err != nil {
+		return nil, fmt.Errorf("NewVdi:block map:%w", err)
+	}
+	v.blockMap = m
+
+	v.SectionReader = io.NewSectionReader(v, 0, int64(h.DiskSize))
+	return v, nil
+}
+
+// readBlock reads p from the block which contains off.
+func (v *Vdi) readBlock(p []byte, off int64) error {
+	bs := int64(v.Header.BlockSize)
+	e := v.blockMap[off/bs]
+	if e == vdiBlockFree || e == vdiBlockZero {
+		zero(p)
+		return nil
+	}
+	pos := int64(v.Header.OffsetData) + int64(e)*(bs+int64(v.Header.BlockExtraSize)) + int64(v.Header.BlockExtraSize) + off%bs
+	_, err := v.r.ReadAt(p, pos)
+	return err
+}
+
+// ReadAt reads the virtual disk.
+func (v *Vdi) ReadAt(p []byte, off int64) (int, error) {
+	return readClusters(p, off, int64(v.Header.DiskSize), int64(v.Header.BlockSize), v.readBlock)
+}
@@ -0,0 +1,50 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewVdi(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "gpt_sample.vdi"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	v, err := vdisk.NewVdi(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("NewVdi err:%s", err)
+	}
+	if v.Header.Type != vdisk.VdiTypeNormal || v.Header.BlockSize != 32768 || v.Size() != 131072 {
+		t.Errorf("header mismatch. %+v", v.Header)
+	}
+
+	diff := append([]byte{}, b...)
+	binary.LittleEndian.PutUint32(diff[0x4c:], vdisk.VdiTypeDiff)
+	if _, err := vdisk.NewVdi(bytes.NewReader(diff)); !errors.Is(err, vdisk.ErrUnsupported) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrUnsupported)
+	}
+	if _, err := vdisk.NewVdi(bytes.NewReader(make([]byte, 1024))); err == nil {
+		t.Errorf("it should be error")
+	}
+}
@@ -0,0 +1,296 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package vdisk implements readers of virtual disk image formats.
+// Images are exposed as io.ReaderAt so that they can be read by the gpt package.
+package vdisk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnsupported is returned if the image uses an unsupported feature.
+var ErrUnsupported = errors.New("unsupported feature")
+
+// ErrChecksum is returned if the checksum stored in the image mismatches.
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Image represents a virtual disk.
+// ReadAt, Read and Seek access the virtual disk, not the image file.
+type Image interface {
+	io.ReaderAt
+	io.ReadSeeker
+	Size() int64 // virtual size in bytes.
+}
+
+// Format represents the format of an image file.
+type Format string
+
+// Supported formats.
+const (
+	FormatRaw           Format = "raw"
+	FormatQcow2         Format = "qcow2"
+	FormatVhd           Format = "vhd"
+	FormatVhdx          Format = "vhdx"
+	FormatVmdk          Format = "vmdk"
+	FormatVdi           Format = "vdi"
+	FormatAndroidSparse Format = "android-sparse"
+	FormatGzip          Format = "gzip"
+	FormatEwf           Format = "ewf"
+)
+
+// SectorSizer is implemented by images which know the logical sector size.
+type SectorSizer interface {
+	SectorSize() int64
+}
+
+// Detect detects the format of the image r whose size is size.
+// It returns FormatRaw if no known signature is found.
+func Detect(r io.ReaderAt, size int64) Format {
+	b := make([]byte, 8)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return FormatRaw
+	}
+	switch {
+	case string(b[:4]) == qcow2Magic:
+		return FormatQcow2
+	case string(b) == vhdxFileSignature:
+		return FormatVhdx
+	case isEwf(b):
+		return FormatEwf
+	case string(b[:4]) == vmdkMagic || string(b) == "# Disk D":
+		return FormatVmdk
+	case isAndroidSparse(r):
+		return FormatAndroidSparse
+	case isVdi(r):
+		return FormatVdi
+	case isVhd(r, size):
+		return FormatVhd
+	}
+	for _, v := range Decompressors {
+		if len(v.Magic) <= len(b) && string(b[:len(v.Magic)]) == v.Magic {
+			return v.Format
+		}
+	}
+	return FormatRaw
+}
+
+// Disk is an image file opened by Open.
+type Disk struct {
+	Image
+	Format  Format
+	closers []io.Closer
+}
+
+// SectorSize returns the logical sector size of the image.
+// It returns 512 if the image doesn't know it.
+func (d *Disk) SectorSize() int64 {
+	if s, ok := d.Image.(SectorSizer); ok {
+		return s.SectorSize()
+	}
+	return 512
+}
+
+// Close closes the image file and its backing files.
+func (d *Disk) Close() error {
+	var ret error
+	for i := len(d.closers) - 1; i >= 0; i-- {
+		if err := d.closers[i].Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}
+	d.closers = nil
+	return ret
+}
+
+// maxBackingDepth limits the chain of backing files.
+const maxBackingDepth = 16
+
+// Open opens the image file at path and detects its format.
+// Backing files are resolved relative to the directory of the image.
+// If path is the first segment of a split raw image (e.g. disk.001 or disk.img.aa),
+// the following segments are concatenated.
+// path can be HTTP(S) URL if the server supports Range requests.
+// Local files are mapped into memory if possible.
+func Open(path string) (*Disk, error) {
+	d := &Disk{}
+	img, f, err := d.open(path, "", 0)
+	if err != nil {
+		d.Close()
+		return nil, fmt.Errorf("Open:%w", err)
+	}
+	d.Image = img
+	d.Format = f
+	return d, nil
+}
+
+// openFile opens the file or URL at path and returns its size.
+func (d *Disk) openFile(path string) (io.ReaderAt, int64, error) {
+	if isUrl(path) {
+		h, err := NewHttp(nil, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		return h, h.Size(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	d.closers = append(d.closers, f)
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := st.Size()
+	if size == 0 {
+		// block devices report 0 as the size.
+		if size, err = f.Seek(0, io.SeekEnd); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	// Fall back to the file if it can't be mapped.
+	if m, err := NewMmap(f, size); err == nil {
+		d.closers = append(d.closers, m)
+		return m, size, nil
+	}
+	return f, size, nil
+}
+
+// open opens path as format. format "" means auto detection.
+func (d *Disk) open(path string, format Format, depth int) (Image, Format, error) {
+	if depth > maxBackingDepth {
+		return nil, "", fmt.Errorf("too deep backing files. %s", path)
+	}
+	f, size, err := d.openFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if format == "" {
+		format = Detect(f, size)
+	}
+	resolve := func(name string, format Format) (Image, error) {
+		if isUrl(path) {
+			u, err := url.Parse(path)
+			if err != nil {
+				return nil, err
+			}
+			ref, err := url.Parse(name)
+			if err != nil {
+				return nil, err
+			}
+			name = u.ResolveReference(ref).String()
+		} else if !filepath.IsAbs(name) {
+			name = filepath.Join(filepath.Dir(path), name)
+		}
+		img, _, err := d.open(name, format, depth+1)
+		return img, err
+	}
+
+	switch format {
+	case FormatRaw:
+		paths := SplitPaths(path)
+		if len(paths) == 1 {
+			return io.NewSectionReader(f, 0, size), format, nil
+		}
+		parts := []io.ReaderAt{f}
+		sizes := []int64{size}
+		for _, p := range paths[1:] {
+			s, n, err := d.openFile(p)
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, s)
+			sizes = append(sizes, n)
+		}
+		img, err := NewSplit(parts, sizes)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatQcow2:
+		img, err := NewQcow2(f, resolve)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatVhd:
+		img, err := NewVhd(f, size, resolve)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatVmdk:
+		img, err := NewVmdk(f, size)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatAndroidSparse:
+		img, err := NewAndroidSparse(f)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatVdi:
+		img, err := NewVdi(f)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatEwf:
+		segments := []io.ReaderAt{f}
+		for _, p := range EwfSegmentPaths(path)[1:] {
+			s, _, err := d.openFile(p)
+			if err != nil {
+				return nil, "", err
+			}
+			segments = append(segments, s)
+		}
+		img, err := NewEwf(segments)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	case FormatVhdx:
+		img, err := NewVhdx(f)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	}
+	if c := findDecompressor(format); c != nil {
+		r, err := c.New(io.NewSectionReader(f, 0, size))
+		if err != nil {
+			return nil, "", err
+		}
+		img, err := NewStream(r, DefaultStreamHead, DefaultStreamTail)
+		if err != nil {
+			return nil, "", err
+		}
+		return img, format, nil
+	}
+	return nil, "", fmt.Errorf("%w. format %s", ErrUnsupported, format)
+}
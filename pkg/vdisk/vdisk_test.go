@@ -0,0 +1,112 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const testdir = "testdata"
+
+// readSample returns gpt_sample.bin of the gpt package.
+func readSample(t *testing.T) []byte {
+	t.Helper()
+	b, err := ioutil.ReadFile(filepath.Join("..", "gpt", "testdata", "gpt_sample.bin"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	return b
+}
+
+// checkImage checks whether img has the content expect and GPT.
+func checkImage(t *testing.T, name string, img vdisk.Image, expect []byte) {
+	t.Helper()
+	if img.Size() != int64(len(expect)) {
+		t.Errorf("%s:size mismatch\n given :%d\n expect:%d", name, img.Size(), len(expect))
+	}
+	b, err := ioutil.ReadAll(img)
+	if err != nil {
+		t.Errorf("%s:ReadAll err:%s", name, err)
+	} else if !bytes.Equal(b, expect) {
+		t.Errorf("%s:content mismatch", name)
+	}
+
+	// unaligned read across the clusters
+	b = make([]byte, 5000)
+	n, err := img.ReadAt(b, 3000)
+	if err != nil || n != len(b) || !bytes.Equal(b, expect[3000:8000]) {
+		t.Errorf("%s:ReadAt mismatch. n=%d err=%v", name, n, err)
+	}
+	if _, err := img.ReadAt(b, img.Size()-10); err != io.EOF {
+		t.Errorf("%s:ReadAt at the end should be io.EOF. err=%v", name, err)
+	}
+
+	if _, err := gpt.ReadGpt(img); err != nil {
+		t.Errorf("%s:ReadGpt err:%s", name, err)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	sample := readSample(t)
+	overlay := append([]byte{}, sample...)
+	copy(overlay[65536:], bytes.Repeat([]byte{0x5a}, 4096))
+	diff := append([]byte{}, sample...)
+	copy(diff[65536:], bytes.Repeat([]byte{0x5a}, 512))
+
+	type testcase struct {
+		name   string
+		file   string
+		format vdisk.Format
+		expect []byte
+	}
+
+	cases := []testcase{
+		{"raw", filepath.Join("..", "gpt", "testdata", "gpt_sample.bin"), vdisk.FormatRaw, sample},
+		{"qcow2", filepath.Join(testdir, "gpt_sample.qcow2"), vdisk.FormatQcow2, sample},
+		{"qcow2 backing", filepath.Join(testdir, "overlay.qcow2"), vdisk.FormatQcow2, overlay},
+		{"vhd fixed", filepath.Join(testdir, "gpt_sample_fixed.vhd"), vdisk.FormatVhd, sample},
+		{"vhd dynamic", filepath.Join(testdir, "gpt_sample_dynamic.vhd"), vdisk.FormatVhd, sample},
+		{"vhd differencing", filepath.Join(testdir, "diff.vhd"), vdisk.FormatVhd, diff},
+		{"vmdk sparse", filepath.Join(testdir, "gpt_sample.vmdk"), vdisk.FormatVmdk, sample},
+		{"vmdk stream-optimized", filepath.Join(testdir, "gpt_sample_stream.vmdk"), vdisk.FormatVmdk, sample},
+		{"vdi", filepath.Join(testdir, "gpt_sample.vdi"), vdisk.FormatVdi, sample},
+		{"android sparse", filepath.Join(testdir, "gpt_sample.simg"), vdisk.FormatAndroidSparse, sample},
+		{"gzip", filepath.Join(testdir, "gpt_sample.img.gz"), vdisk.FormatGzip, sample},
+		{"ewf", filepath.Join(testdir, "gpt_sample.E01"), vdisk.FormatEwf, sample},
+	}
+
+	for _, v := range cases {
+		d, err := vdisk.Open(v.file)
+		if err != nil {
+			t.Errorf("%s:Open err:%s", v.name, err)
+			continue
+		}
+		if d.Format != v.format {
+			t.Errorf("%s:format mismatch\n given :%s\n expect:%s", v.name, d.Format, v.format)
+		}
+		checkImage(t, v.name, d, v.expect)
+		if err := d.Close(); err != nil {
+			t.Errorf("%s:Close err:%s", v.name, err)
+		}
+	}
+}
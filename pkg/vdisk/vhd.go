@@ -0,0 +1,271 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const (
+	vhdFooterCookie  = "conectix"
+	vhdDynamicCookie = "cxsparse"
+	vhdUnallocated   = 0xffffffff
+)
+
+// Disk types of VHD.
+const (
+	VhdTypeFixed        = 2
+	VhdTypeDynamic      = 3
+	VhdTypeDifferencing = 4
+)
+
+// VhdFooter represents the hard disk footer of VHD.
+// ref: Virtual Hard Disk Image Format Specification
+type VhdFooter struct {
+	Cookie             [8]byte
+	Features           uint32
+	FileFormatVersion  uint32
+	DataOffset         uint64
+	TimeStamp          uint32
+	CreatorApplication [4]byte
+	CreatorVersion     uint32
+	CreatorHostOS      uint32
+	OriginalSize       uint64
+	CurrentSize        uint64
+	DiskGeometry       uint32
+	DiskType           uint32
+	Checksum           uint32
+	UniqueId           [16]byte
+	SavedState         uint8
+	Reserved           [427]byte
+}
+
+// VhdDynamicHeader represents the dynamic disk header of VHD.
+type VhdDynamicHeader struct {
+	Cookie            [8]byte
+	DataOffset        uint64
+	TableOffset       uint64
+	HeaderVersion     uint32
+	MaxTableEntries   uint32
+	BlockSize         uint32
+	Checksum          uint32
+	ParentUniqueId    [16]byte
+	ParentTimeStamp   uint32
+	Reserved          uint32
+	ParentUnicodeName [256]uint16
+	ParentLocators    [8][24]byte
+	Reserved2         [256]byte
+}
+
+// vhdChecksum returns the one's complement of the sum of b except the checksum at off.
+func vhdChecksum(b []byte, off int) uint32 {
+	sum := uint32(0)
+	for i, v := range b {
+		if i >= off && i < off+4 {
+			continue
+		}
+		sum += uint32(v)
+	}
+	return ^sum
+}
+
+// Vhd is a reader of VHD image.
+type Vhd struct {
+	*io.SectionReader
+	Footer     VhdFooter
+	Dynamic    *VhdDynamicHeader // nil if fixed disk.
+	ParentName string            // the parent of differencing disk.
+
+	r          io.ReaderAt
+	parent     Image
+	bat        []uint32
+	bitmapSize int64
+
+	mu        sync.Mutex
+	bitmapBlk uint32 // block of the cached sector bitmap.
+	bitmap    []byte
+}
+
+// readVhdFooter reads the footer at off.
+func readVhdFooter(r io.ReaderAt, off int64) (*VhdFooter, error) {
+	b := make([]byte, 512)
+	if n, err := r.ReadAt(b, off); err != nil && !(err == io.EOF && n >= 511) {
+		return nil, err
+	}
+	f := &VhdFooter{}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, f); err != nil {
+		return nil, err
+	}
+	if string(f.Cookie[:]) != vhdFooterCookie {
+		return nil, fmt.Errorf("Not VHD")
+	}
+	if vhdChecksum(b, 64) != f.Checksum {
+		return nil, fmt.Errorf("invalid footer checksum")
+	}
+	return f, nil
+}
+
+// isVhd reports whether r whose size is size is VHD.
+func isVhd(r io.ReaderAt, size int64) bool {
+	b := make([]byte, 8)
+	if size < 512 {
+		return false
+	}
+	if _, err := r.ReadAt(b, size-512); err != nil {
+		return false
+	}
+	if string(b) == vhdFooterCookie {
+		return true
+	}
+	// some old implementations write 511 bytes footer.
+	_, err := r.ReadAt(b, size-511)
+	return err == nil && string(b) == vhdFooterCookie
+}
+
+// NewVhd returns a reader of VHD image r whose size is size.
+// resolve is called to open the parent of differencing disk.
+func NewVhd(r io.ReaderAt, size int64, resolve BackingResolver) (*Vhd, error) {
+	v := &Vhd{r: r}
+
+	// The copy of the footer is at the beginning of dynamic disks.
+	var f *VhdFooter
+	var err error
+	for _, off := range []int64{size - 512, size - 511, 0} {
+		if f, err = readVhdFooter(r, off); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NewVhd:%w", err)
+	}
+	v.Footer = *f
+
+	switch f.DiskType {
+	case VhdTypeFixed:
+		if int64(f.CurrentSize) > size {
+			return nil, fmt.Errorf("NewVhd:image is truncated")
+		}
+		v.SectionReader = io.NewSectionReader(r, 0, int64(f.CurrentSize))
+		return v, nil
+	case VhdTypeDynamic, VhdTypeDifferencing:
+	default:
+		return nil, fmt.Errorf("NewVhd:%w. disk type %d", ErrUnsupported, f.DiskType)
+	}
+
+	b := make([]byte, 1024)
+	if _, err := r.ReadAt(b, int64(f.DataOffset)); err != nil {
+		return nil, fmt.Errorf("NewVhd:dynamic header:%w", err)
+	}
+	h := &VhdDynamicHeader{}
+	if err := binary.Read(bytes.NewReader(b), binary.BigEndian, h); err != nil {
+		return nil, fmt.Errorf("NewVhd:%w", err)
+	}
+	if string(h.Cookie[:]) != vhdDynamicCookie {
+		return nil, fmt.Errorf("NewVhd:invalid dynamic header cookie")
+	}
+	if vhdChecksum(b, 36) != h.Checksum {
+		return nil, fmt.Errorf("NewVhd:invalid dynamic header checksum")
+	}
+	if h.BlockSize < 512 || h.BlockSize&(h.BlockSize-1) != 0 {
+		return nil, fmt.Errorf("NewVhd:invalid block size %d", h.BlockSize)
+	}
+	if uint64(h.MaxTableEntries)*uint64(h.BlockSize) < f.CurrentSize {
+		return nil, fmt.Errorf("NewVhd:too few table entries %d", h.MaxTableEntries)
+	}
+	v.Dynamic = h
+	v.bitmapSize = (int64(h.BlockSize)/512/8 + 511) / 512 * 512
+
+	// entries beyond CurrentSize are never used.
+	n := (f.CurrentSize + uint64(h.BlockSize) - 1) / uint64(h.BlockSize)
+	if h.TableOffset > uint64(size) || n*4 > uint64(size)-h.TableOffset {
+		return nil, fmt.Errorf("NewVhd:BAT is truncated")
+	}
+	bat := make([]byte, n*4)
+	if _, err := r.ReadAt(bat, int64(h.TableOffset)); err != nil {
+		return nil, fmt.Errorf("NewVhd:BAT:%w", err)
+	}
+	v.bat = make([]uint32, n)
+	for i := range v.bat {
+		v.bat[i] = binary.BigEndian.Uint32(bat[i*4:])
+	}
+
+	if f.DiskType == VhdTypeDifferencing {
+		v.ParentName = strings.TrimRight(string(utf16.Decode(h.ParentUnicodeName[:])), "\x00")
+		if resolve == nil {
+			return nil, fmt.Errorf("NewVhd:no resolver for parent %s", v.ParentName)
+		}
+		p, err := resolve(v.ParentName, FormatVhd)
+		if err != nil {
+			return nil, fmt.Errorf("NewVhd:parent %s:%w", v.ParentName, err)
+		}
+		v.parent = p
+	}
+
+	v.SectionReader = io.NewSectionReader(v, 0, int64(f.CurrentSize))
+	return v, nil
+}
+
+// readSector reads p from the sector which contains off.
+func (v *Vhd) readSector(p []byte, off int64) error {
+	bs := int64(v.Dynamic.BlockSize)
+	blk := v.bat[off/bs]
+	if blk == vhdUnallocated {
+		return readBacking(v.parent, p, off)
+	}
+
+	// The bit of the sector bitmap is set if the sector is in the block.
+	in := off % bs
+	bit := in / 512
+	bm, err := v.sectorBitmap(blk)
+	if err != nil {
+		return err
+	}
+	if bm[bit/8]&(0x80>>uint(bit%8)) == 0 {
+		return readBacking(v.parent, p, off)
+	}
+	_, err = v.r.ReadAt(p, int64(blk)*512+v.bitmapSize+in)
+	return err
+}
+
+// sectorBitmap returns the sector bitmap of the block at the sector blk.
+func (v *Vhd) sectorBitmap(blk uint32) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.bitmap != nil && v.bitmapBlk == blk {
+		return v.bitmap, nil
+	}
+	b := make([]byte, v.bitmapSize)
+	if _, err := v.r.ReadAt(b, int64(blk)*512); err != nil {
+		return nil, err
+	}
+	v.bitmapBlk, v.bitmap = blk, b
+	return b, nil
+}
+
+// ReadAt reads the virtual disk.
+func (v *Vhd) ReadAt(p []byte, off int64) (int, error) {
+	if v.Dynamic == nil {
+		return v.SectionReader.ReadAt(p, off)
+	}
+	return readClusters(p, off, int64(v.Footer.CurrentSize), 512, v.readSector)
+}
@@ -0,0 +1,101 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewVhd(t *testing.T) {
+	type testcase struct {
+		name     string
+		file     string
+		diskType uint32
+	}
+
+	cases := []testcase{
+		{"fixed", "gpt_sample_fixed.vhd", vdisk.VhdTypeFixed},
+		{"dynamic", "gpt_sample_dynamic.vhd", vdisk.VhdTypeDynamic},
+	}
+
+	for _, v := range cases {
+		f, err := os.Open(filepath.Join(testdir, v.file))
+		if err != nil {
+			t.Fatalf("Open err:%s", err)
+		}
+		st, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Stat err:%s", err)
+		}
+		h, err := vdisk.NewVhd(f, st.Size(), nil)
+		if err != nil {
+			t.Errorf("%s:NewVhd err:%s", v.name, err)
+		} else if h.Footer.DiskType != v.diskType || h.Size() != 131072 {
+			t.Errorf("%s:mismatch. type=%d size=%d", v.name, h.Footer.DiskType, h.Size())
+		}
+		f.Close()
+	}
+
+	// differencing disk needs the resolver.
+	f, err := os.Open(filepath.Join(testdir, "diff.vhd"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat err:%s", err)
+	}
+	if _, err := vdisk.NewVhd(f, st.Size(), nil); err == nil {
+		t.Errorf("it should be error without resolver")
+	}
+}
+
+func TestNewVhdMaxTableEntries(t *testing.T) {
+	b, err := ioutil.ReadFile(filepath.Join(testdir, "gpt_sample_dynamic.vhd"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+	h, err := vdisk.NewVhd(bytes.NewReader(b), int64(len(b)), nil)
+	if err != nil {
+		t.Fatalf("NewVhd err:%s", err)
+	}
+
+	// huge MaxTableEntries. Only entries of CurrentSize are read.
+	d := b[h.Footer.DataOffset : h.Footer.DataOffset+1024]
+	binary.BigEndian.PutUint32(d[28:], 0xffffffff)
+	binary.BigEndian.PutUint32(d[36:], 0)
+	sum := uint32(0)
+	for _, v := range d {
+		sum += uint32(v)
+	}
+	binary.BigEndian.PutUint32(d[36:], ^sum)
+
+	h, err = vdisk.NewVhd(bytes.NewReader(b), int64(len(b)), nil)
+	if err != nil {
+		t.Fatalf("NewVhd err:%s", err)
+	}
+	if h.Size() != 131072 {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", h.Size(), 131072)
+	}
+}
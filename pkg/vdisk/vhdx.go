@@ -0,0 +1,313 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	vhdxFileSignature     = "vhdxfile"
+	vhdxHeaderSignature   = "head"
+	vhdxRegionSignature   = "regi"
+	vhdxMetadataSignature = "metadata"
+	vhdxMB                = 1024 * 1024
+	vhdxMaxMetadataSize   = 32 * vhdxMB
+	vhdxMaxVirtualSize    = uint64(64) << 40 // 64TiB
+)
+
+// Region and metadata GUIDs of VHDX.
+var (
+	vhdxBatGuid               = mustGuid("2DC27766-F623-4200-9D64-115E9BFD4A08")
+	vhdxMetadataGuid          = mustGuid("8B7CA206-4790-4B9A-B8FE-575F050F886E")
+	vhdxFileParametersGuid    = mustGuid("CAA16737-FA36-4D43-B3B6-33F0AA44E76B")
+	vhdxVirtualDiskSizeGuid   = mustGuid("2FA54224-CD1B-4876-B211-5DBED83BF4B8")
+	vhdxLogicalSectorGuid     = mustGuid("8141BF1D-A96F-4709-BA47-F233A8FAAB5F")
+	vhdxPhysicalSectorGuid    = mustGuid("CDA348C7-445D-4471-9CC9-E9885251C556")
+	vhdxFileParametersParent  = uint32(1) << 1
+	vhdxPayloadFullyPresent   = uint64(6)
+	vhdxPayloadPartialPresent = uint64(7)
+	vhdxBatStateMask          = uint64(7)
+)
+
+func mustGuid(s string) gpt.Guid {
+	g, err := gpt.NewGuidFromString(s)
+	if err != nil {
+		panic(err)
+	}
+	return *g
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// VhdxHeader represents the header of VHDX.
+// ref: [MS-VHDX] 2.2.2.1
+type VhdxHeader struct {
+	Signature      [4]byte
+	Checksum       uint32
+	SequenceNumber uint64
+	FileWriteGuid  gpt.Guid
+	DataWriteGuid  gpt.Guid
+	LogGuid        gpt.Guid
+	LogVersion     uint16
+	Version        uint16
+	LogLength      uint32
+	LogOffset      uint64
+}
+
+// VhdxRegionEntry represents an entry of the region table of VHDX.
+type VhdxRegionEntry struct {
+	Guid       gpt.Guid
+	FileOffset uint64
+	Length     uint32
+	Required   uint32
+}
+
+// vhdxMetadataEntry represents an entry of the metadata table of VHDX.
+type vhdxMetadataEntry struct {
+	ItemId   gpt.Guid
+	Offset   uint32
+	Length   uint32
+	Flags    uint32
+	Reserved uint32
+}
+
+// Vhdx is a reader of VHDX image.
+// Differencing disks and images which need log replay are not supported.
+type Vhdx struct {
+	*io.SectionReader
+	Header             VhdxHeader
+	Regions            []VhdxRegionEntry
+	BlockSize          uint32
+	VirtualSize        uint64
+	LogicalSectorSize  uint32
+	PhysicalSectorSize uint32
+
+	r          io.ReaderAt
+	bat        []uint64
+	chunkRatio uint64
+}
+
+// readVhdxHeader reads and verifies the header at off.
+func readVhdxHeader(r io.ReaderAt, off int64) (*VhdxHeader, error) {
+	b := make([]byte, 4096)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	h := &VhdxHeader{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, h); err != nil {
+		return nil, err
+	}
+	if string(h.Signature[:]) != vhdxHeaderSignature {
+		return nil, fmt.Errorf("invalid header signature")
+	}
+	binary.LittleEndian.PutUint32(b[4:], 0)
+	if crc32.Checksum(b, crc32c) != h.Checksum {
+		return nil, fmt.Errorf("invalid header checksum")
+	}
+	return h, nil
+}
+
+// readVhdxRegions reads and verifies the region table at off.
+func readVhdxRegions(r io.ReaderAt, off int64) ([]VhdxRegionEntry, error) {
+	b := make([]byte, 64*1024)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	if string(b[:4]) != vhdxRegionSignature {
+		return nil, fmt.Errorf("invalid region table signature")
+	}
+	c := binary.LittleEndian.Uint32(b[4:])
+	binary.LittleEndian.PutUint32(b[4:], 0)
+	if crc32.Checksum(b, crc32c) != c {
+		return nil, fmt.Errorf("invalid region table checksum")
+	}
+	n := binary.LittleEndian.Uint32(b[8:])
+	if n > 2047 {
+		return nil, fmt.Errorf("too many regions %d", n)
+	}
+	ret := make([]VhdxRegionEntry, n)
+	if err := binary.Read(bytes.NewReader(b[16:]), binary.LittleEndian, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// NewVhdx returns a reader of VHDX image r.
+func NewVhdx(r io.ReaderAt) (*Vhdx, error) {
+	v := &Vhdx{r: r}
+
+	sig := make([]byte, 8)
+	if _, err := r.ReadAt(sig, 0); err != nil || string(sig) != vhdxFileSignature {
+		return nil, fmt.Errorf("Not VHDX")
+	}
+
+	// The current header is the valid one which has the larger sequence number.
+	var cur *VhdxHeader
+	for _, off := range []int64{64 * 1024, 128 * 1024} {
+		h, err := readVhdxHeader(r, off)
+		if err == nil && (cur == nil || h.SequenceNumber > cur.SequenceNumber) {
+			cur = h
+		}
+	}
+	if cur == nil {
+		return nil, fmt.Errorf("NewVhdx:no valid header")
+	}
+	if !cur.LogGuid.Equal(gpt.Guid{}) {
+		return nil, fmt.Errorf("NewVhdx:%w. log replay", ErrUnsupported)
+	}
+	v.Header = *cur
+
+	var err error
+	for _, off := range []int64{192 * 1024, 256 * 1024} {
+		if v.Regions, err = readVhdxRegions(r, off); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NewVhdx:%w", err)
+	}
+
+	var batRegion, metaRegion *VhdxRegionEntry
+	for i, e := range v.Regions {
+		switch {
+		case e.Guid.Equal(vhdxBatGuid):
+			batRegion = &v.Regions[i]
+		case e.Guid.Equal(vhdxMetadataGuid):
+			metaRegion = &v.Regions[i]
+		case e.Required&1 != 0:
+			return nil, fmt.Errorf("NewVhdx:%w. required region %s", ErrUnsupported, e.Guid)
+		}
+	}
+	if batRegion == nil || metaRegion == nil {
+		return nil, fmt.Errorf("NewVhdx:BAT or metadata region not found")
+	}
+
+	if err := v.readMetadata(*metaRegion); err != nil {
+		return nil, fmt.Errorf("NewVhdx:%w", err)
+	}
+
+	// BAT has a sector bitmap entry after every chunkRatio payload entries.
+	v.chunkRatio = (uint64(1) << 23) * uint64(v.LogicalSectorSize) / uint64(v.BlockSize)
+	blocks := (v.VirtualSize + uint64(v.BlockSize) - 1) / uint64(v.BlockSize)
+	n := blocks + (blocks-1)/v.chunkRatio
+	if n*8 > uint64(batRegion.Length) {
+		return nil, fmt.Errorf("NewVhdx:too small BAT region")
+	}
+	b := make([]byte, n*8)
+	if _, err := r.ReadAt(b, int64(batRegion.FileOffset)); err != nil {
+		return nil, fmt.Errorf("NewVhdx:BAT:%w", err)
+	}
+	v.bat = make([]uint64, n)
+	for i := range v.bat {
+		v.bat[i] = binary.LittleEndian.Uint64(b[i*8:])
+	}
+
+	v.SectionReader = io.NewSectionReader(v, 0, int64(v.VirtualSize))
+	return v, nil
+}
+
+// readMetadata reads the metadata region.
+func (v *Vhdx) readMetadata(region VhdxRegionEntry) error {
+	if region.Length > vhdxMaxMetadataSize {
+		return fmt.Errorf("too large metadata region %d", region.Length)
+	}
+	b := make([]byte, region.Length)
+	if _, err := v.r.ReadAt(b, int64(region.FileOffset)); err != nil {
+		return fmt.Errorf("metadata:%w", err)
+	}
+	if len(b) < 64*1024 || string(b[:8]) != vhdxMetadataSignature {
+		return fmt.Errorf("invalid metadata signature")
+	}
+	n := int(binary.LittleEndian.Uint16(b[10:]))
+	if n > 2047 {
+		return fmt.Errorf("too many metadata entries %d", n)
+	}
+	es := make([]vhdxMetadataEntry, n)
+	if err := binary.Read(bytes.NewReader(b[32:]), binary.LittleEndian, es); err != nil {
+		return err
+	}
+
+	item := func(g gpt.Guid, size int) []byte {
+		for _, e := range es {
+			if e.ItemId.Equal(g) && int(e.Length) >= size && int64(e.Offset)+int64(e.Length) <= int64(len(b)) {
+				return b[e.Offset : e.Offset+e.Length]
+			}
+		}
+		return nil
+	}
+	fp := item(vhdxFileParametersGuid, 8)
+	size := item(vhdxVirtualDiskSizeGuid, 8)
+	logical := item(vhdxLogicalSectorGuid, 4)
+	if fp == nil || size == nil || logical == nil {
+		return fmt.Errorf("required metadata not found")
+	}
+	v.BlockSize = binary.LittleEndian.Uint32(fp)
+	if binary.LittleEndian.Uint32(fp[4:])&vhdxFileParametersParent != 0 {
+		return fmt.Errorf("%w. differencing disk", ErrUnsupported)
+	}
+	v.VirtualSize = binary.LittleEndian.Uint64(size)
+	v.LogicalSectorSize = binary.LittleEndian.Uint32(logical)
+	v.PhysicalSectorSize = v.LogicalSectorSize
+	if p := item(vhdxPhysicalSectorGuid, 4); p != nil {
+		v.PhysicalSectorSize = binary.LittleEndian.Uint32(p)
+	}
+
+	if v.BlockSize < vhdxMB || v.BlockSize > 256*vhdxMB || v.BlockSize&(v.BlockSize-1) != 0 {
+		return fmt.Errorf("invalid block size %d", v.BlockSize)
+	}
+	if v.LogicalSectorSize != 512 && v.LogicalSectorSize != 4096 {
+		return fmt.Errorf("invalid logical sector size %d", v.LogicalSectorSize)
+	}
+	if v.VirtualSize == 0 || v.VirtualSize > vhdxMaxVirtualSize || v.VirtualSize%uint64(v.LogicalSectorSize) != 0 {
+		return fmt.Errorf("invalid virtual disk size %d", v.VirtualSize)
+	}
+	return nil
+}
+
+// SectorSize returns the logical sector size.
+func (v *Vhdx) SectorSize() int64 {
+	return int64(v.LogicalSectorSize)
+}
+
+// readBlock reads p from the block which contains off.
+func (v *Vhdx) readBlock(p []byte, off int64) error {
+	i := uint64(off) / uint64(v.BlockSize)
+	e := v.bat[i+i/v.chunkRatio]
+
+	switch e & vhdxBatStateMask {
+	case vhdxPayloadFullyPresent:
+		fileOff := int64(e &^ (vhdxMB - 1))
+		_, err := v.r.ReadAt(p, fileOff+off%int64(v.BlockSize))
+		return err
+	case vhdxPayloadPartialPresent:
+		return fmt.Errorf("%w. partially present block", ErrUnsupported)
+	}
+	// not present, undefined, zero or unmapped
+	zero(p)
+	return nil
+}
+
+// ReadAt reads the virtual disk.
+func (v *Vhdx) ReadAt(p []byte, off int64) (int, error) {
+	return readClusters(p, off, int64(v.VirtualSize), int64(v.BlockSize), v.readBlock)
+}
@@ -0,0 +1,139 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"github.com/nokute78/go-gpt/pkg/gpt"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// gunzipTestdata decompresses the file name of testdata into dir.
+// Large images which are mostly zero are stored compressed.
+func gunzipTestdata(t *testing.T, dir string, name string) string {
+	t.Helper()
+	f, err := os.Open(filepath.Join(testdir, name+".gz"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("gzip.NewReader err:%s", err)
+	}
+	ret := filepath.Join(dir, name)
+	w, err := os.Create(ret)
+	if err != nil {
+		t.Fatalf("Create err:%s", err)
+	}
+	defer w.Close()
+	if _, err := io.Copy(w, zr); err != nil {
+		t.Fatalf("Copy err:%s", err)
+	}
+	return ret
+}
+
+func TestNewVhdx(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vhdx")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	d, err := vdisk.Open(gunzipTestdata(t, dir, "gpt4k.vhdx"))
+	if err != nil {
+		t.Fatalf("Open err:%s", err)
+	}
+	defer d.Close()
+
+	if d.Format != vdisk.FormatVhdx {
+		t.Errorf("format mismatch\n given :%s\n expect:%s", d.Format, vdisk.FormatVhdx)
+	}
+	if d.SectorSize() != 4096 {
+		t.Errorf("sector size mismatch\n given :%d\n expect:%d", d.SectorSize(), 4096)
+	}
+	if d.Size() != 4*1024*1024 {
+		t.Errorf("size mismatch\n given :%d\n expect:%d", d.Size(), 4*1024*1024)
+	}
+
+	g, err := gpt.ReadGptWithSectorSize(d, d.SectorSize())
+	if err != nil {
+		t.Fatalf("ReadGptWithSectorSize err:%s", err)
+	}
+	e := g.Entries[0]
+	if e.ReadName() != "root" || e.FirstLBA != 256 || e.LastLBA != 767 {
+		t.Errorf("entry mismatch. name=%s first=%d last=%d", e.ReadName(), e.FirstLBA, e.LastLBA)
+	}
+
+	// not present and zero blocks
+	b := make([]byte, 2*1024*1024)
+	if _, err := d.ReadAt(b, 1024*1024); err != nil {
+		t.Fatalf("ReadAt err:%s", err)
+	}
+	if !bytes.Equal(b, make([]byte, len(b))) {
+		t.Errorf("blocks should be zero")
+	}
+}
+
+func TestNewVhdxInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vhdx")
+	if err != nil {
+		t.Fatalf("TempDir err:%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	img, err := ioutil.ReadFile(gunzipTestdata(t, dir, "gpt4k.vhdx"))
+	if err != nil {
+		t.Fatalf("ReadFile err:%s", err)
+	}
+
+	type testcase struct {
+		name   string
+		modify func(b []byte)
+	}
+
+	const regionTable = 192 * 1024
+	const metadata = 2*1024*1024 + 64*1024
+	cases := []testcase{
+		{"zero virtual size", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[metadata+8:], 0)
+		}},
+		{"too large metadata region", func(b []byte) {
+			binary.LittleEndian.PutUint32(b[regionTable+48+24:], 0xffffffff)
+			binary.LittleEndian.PutUint32(b[regionTable+4:], 0)
+			c := crc32.Checksum(b[regionTable:regionTable+64*1024], crc32.MakeTable(crc32.Castagnoli))
+			binary.LittleEndian.PutUint32(b[regionTable+4:], c)
+			copy(b[regionTable+64*1024:], b[regionTable:regionTable+64*1024])
+		}},
+	}
+
+	for _, v := range cases {
+		b := append([]byte{}, img...)
+		v.modify(b)
+		if _, err := vdisk.NewVhdx(bytes.NewReader(b)); err == nil {
+			t.Errorf("%s:it should be error", v.name)
+		}
+	}
+}
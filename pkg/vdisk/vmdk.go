@@ -0,0 +1,278 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	vmdkMagic           = "KDMV"
+	vmdkGdAtEnd         = 0xffffffffffffffff
+	vmdkMaxGtCache      = 64
+	vmdkGrainZero       = 1 // grain table entry of the zeroed grain.
+	vmdkCompressDeflate = 1
+)
+
+// Flags of VmdkHeader.
+const (
+	VmdkFlagNewLineTest     = uint32(1) << 0
+	VmdkFlagRedundantGt     = uint32(1) << 1
+	VmdkFlagCompressedGrain = uint32(1) << 16
+	VmdkFlagMarkers         = uint32(1) << 17
+)
+
+// VmdkHeader represents the header of hosted sparse extent.
+// ref: Virtual Disk Format 5.0
+type VmdkHeader struct {
+	Magic              [4]byte
+	Version            uint32
+	Flags              uint32
+	Capacity           uint64 // in sectors
+	GrainSize          uint64 // in sectors
+	DescriptorOffset   uint64
+	DescriptorSize     uint64
+	NumGTEsPerGT       uint32
+	RgdOffset          uint64
+	GdOffset           uint64
+	Overhead           uint64
+	UncleanShutdown    uint8
+	SingleEndLineChar  uint8
+	NonEndLineChar     uint8
+	DoubleEndLineChar1 uint8
+	DoubleEndLineChar2 uint8
+	CompressAlgorithm  uint16
+	Pad                [433]byte
+}
+
+// Vmdk is a reader of monolithic sparse and stream-optimized VMDK.
+type Vmdk struct {
+	*io.SectionReader
+	Header     VmdkHeader
+	Descriptor string
+	CreateType string // e.g. "monolithicSparse", "streamOptimized"
+
+	r         io.ReaderAt
+	grainSize int64
+	gd        []uint32
+
+	mu        sync.Mutex
+	gtCache   map[uint32][]uint32
+	lastGrain uint32 // sector of the last decompressed grain.
+	grainBuf  []byte
+}
+
+// readVmdkHeader reads the header at off.
+func readVmdkHeader(r io.ReaderAt, off int64) (*VmdkHeader, error) {
+	b := make([]byte, 512)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	h := &VmdkHeader{}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, h); err != nil {
+		return nil, err
+	}
+	if string(h.Magic[:]) != vmdkMagic {
+		return nil, fmt.Errorf("Not VMDK")
+	}
+	return h, nil
+}
+
+// NewVmdk returns a reader of VMDK image r whose size is size.
+// Only monolithic sparse and stream-optimized images are supported.
+func NewVmdk(r io.ReaderAt, size int64) (*Vmdk, error) {
+	v := &Vmdk{r: r, gtCache: map[uint32][]uint32{}}
+
+	h, err := readVmdkHeader(r, 0)
+	if err != nil {
+		if strings.HasPrefix(readString(r, 0, 64), "# Disk DescriptorFile") {
+			return nil, fmt.Errorf("NewVmdk:%w. descriptor file with separate extents", ErrUnsupported)
+		}
+		return nil, fmt.Errorf("NewVmdk:%w", err)
+	}
+	// The footer of stream-optimized image has the location of grain directory.
+	// footer marker, footer and end-of-stream marker are at the end.
+	if h.GdOffset == vmdkGdAtEnd {
+		if h, err = readVmdkHeader(r, size-1024); err != nil {
+			return nil, fmt.Errorf("NewVmdk:footer:%w", err)
+		}
+	}
+	v.Header = *h
+
+	if h.Version < 1 || h.Version > 3 {
+		return nil, fmt.Errorf("NewVmdk:%w. version %d", ErrUnsupported, h.Version)
+	}
+	if h.GrainSize < 1 || h.GrainSize&(h.GrainSize-1) != 0 || h.GrainSize > 2048 {
+		return nil, fmt.Errorf("NewVmdk:invalid grain size %d", h.GrainSize)
+	}
+	if h.NumGTEsPerGT == 0 || h.NumGTEsPerGT > 4096 {
+		return nil, fmt.Errorf("NewVmdk:invalid number of grain table entries %d", h.NumGTEsPerGT)
+	}
+	if h.Flags&VmdkFlagCompressedGrain != 0 && h.CompressAlgorithm != vmdkCompressDeflate {
+		return nil, fmt.Errorf("NewVmdk:%w. compress algorithm %d", ErrUnsupported, h.CompressAlgorithm)
+	}
+	v.grainSize = int64(h.GrainSize) * 512
+
+	if h.DescriptorOffset != 0 && h.DescriptorSize != 0 && h.DescriptorSize < 2048 {
+		v.Descriptor = strings.TrimRight(readString(r, int64(h.DescriptorOffset)*512, int(h.DescriptorSize)*512), "\x00")
+		v.CreateType = descriptorValue(v.Descriptor, "createType")
+	}
+
+	// grain directory
+	grains := (h.Capacity + h.GrainSize - 1) / h.GrainSize
+	n := (grains + uint64(h.NumGTEsPerGT) - 1) / uint64(h.NumGTEsPerGT)
+	if n*4 > 32*1024*1024 {
+		return nil, fmt.Errorf("NewVmdk:too large grain directory. %d entries", n)
+	}
+	gd, err := readUint32s(r, int64(h.GdOffset)*512, int(n))
+	if err != nil {
+		return nil, fmt.Errorf("NewVmdk:grain directory:%w", err)
+	}
+	v.gd = gd
+
+	v.SectionReader = io.NewSectionReader(v, 0, int64(h.Capacity)*512)
+	return v, nil
+}
+
+// readString reads n bytes at off as string. It returns "" if error.
+func readString(r io.ReaderAt, off int64, n int) string {
+	b := make([]byte, n)
+	m, err := r.ReadAt(b, off)
+	if err != nil && err != io.EOF {
+		return ""
+	}
+	return string(b[:m])
+}
+
+// descriptorValue returns the value of key in the descriptor.
+func descriptorValue(desc, key string) string {
+	for _, l := range strings.Split(desc, "\n") {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == key {
+			return strings.Trim(strings.TrimSpace(kv[1]), "\"")
+		}
+	}
+	return ""
+}
+
+// readUint32s reads n uint32 at off.
+func readUint32s(r io.ReaderAt, off int64, n int) ([]uint32, error) {
+	b := make([]byte, n*4)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	ret := make([]uint32, n)
+	for i := range ret {
+		ret[i] = binary.LittleEndian.Uint32(b[i*4:])
+	}
+	return ret, nil
+}
+
+// grainTable returns the grain table at the sector sec.
+func (v *Vmdk) grainTable(sec uint32) ([]uint32, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if t, ok := v.gtCache[sec]; ok {
+		return t, nil
+	}
+	t, err := readUint32s(v.r, int64(sec)*512, int(v.Header.NumGTEsPerGT))
+	if err != nil {
+		return nil, fmt.Errorf("grain table:%w", err)
+	}
+	if len(v.gtCache) >= vmdkMaxGtCache {
+		v.gtCache = map[uint32][]uint32{}
+	}
+	v.gtCache[sec] = t
+	return t, nil
+}
+
+// readCompressed decompresses the grain at the sector sec.
+// The grain starts with the marker which has LBA and the size of compressed data.
+func (v *Vmdk) readCompressed(sec uint32) ([]byte, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.grainBuf != nil && v.lastGrain == sec {
+		return v.grainBuf, nil
+	}
+
+	m := make([]byte, 12)
+	if _, err := v.r.ReadAt(m, int64(sec)*512); err != nil {
+		return nil, err
+	}
+	n := binary.LittleEndian.Uint32(m[8:])
+	if int64(n) > 2*v.grainSize+1024 {
+		return nil, fmt.Errorf("too large compressed grain %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := v.r.ReadAt(b, int64(sec)*512+12); err != nil {
+		return nil, err
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("compressed grain at sector %d:%w", sec, err)
+	}
+	ret := make([]byte, v.grainSize)
+	// the last grain may be shorter than the grain size.
+	if _, err := io.ReadFull(zr, ret); err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("compressed grain at sector %d:%w", sec, err)
+	}
+	v.lastGrain, v.grainBuf = sec, ret
+	return ret, nil
+}
+
+// readGrain reads p from the grain which contains off.
+func (v *Vmdk) readGrain(p []byte, off int64) error {
+	g := uint64(off / v.grainSize)
+	in := off % v.grainSize
+	n := uint64(v.Header.NumGTEsPerGT)
+
+	e := uint32(0)
+	if i := g / n; i < uint64(len(v.gd)) && v.gd[i] != 0 {
+		t, err := v.grainTable(v.gd[i])
+		if err != nil {
+			return err
+		}
+		e = t[g%n]
+	}
+
+	switch {
+	case e == 0 || e == vmdkGrainZero:
+		zero(p)
+	case v.Header.Flags&VmdkFlagCompressedGrain != 0:
+		b, err := v.readCompressed(e)
+		if err != nil {
+			return err
+		}
+		copy(p, b[in:])
+	default:
+		if _, err := v.r.ReadAt(p, int64(e)*512+in); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadAt reads the virtual disk.
+func (v *Vmdk) ReadAt(p []byte, off int64) (int, error) {
+	return readClusters(p, off, int64(v.Header.Capacity)*512, v.grainSize, v.readGrain)
+}
@@ -0,0 +1,63 @@
+/*
+   Copyright 2021 Takahiro Yamashita
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package vdisk_test
+
+import (
+	"bytes"
+	"errors"
+	"github.com/nokute78/go-gpt/pkg/vdisk"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewVmdk(t *testing.T) {
+	type testcase struct {
+		name       string
+		file       string
+		createType string
+		flags      uint32
+	}
+
+	cases := []testcase{
+		{"sparse", "gpt_sample.vmdk", "monolithicSparse", vdisk.VmdkFlagNewLineTest | vdisk.VmdkFlagRedundantGt},
+		{"stream-optimized", "gpt_sample_stream.vmdk", "streamOptimized", vdisk.VmdkFlagNewLineTest | vdisk.VmdkFlagCompressedGrain | vdisk.VmdkFlagMarkers},
+	}
+
+	for _, v := range cases {
+		f, err := os.Open(filepath.Join(testdir, v.file))
+		if err != nil {
+			t.Fatalf("Open err:%s", err)
+		}
+		st, err := f.Stat()
+		if err != nil {
+			t.Fatalf("Stat err:%s", err)
+		}
+		d, err := vdisk.NewVmdk(f, st.Size())
+		if err != nil {
+			t.Errorf("%s:NewVmdk err:%s", v.name, err)
+		} else if d.CreateType != v.createType || d.Header.Flags != v.flags || d.Size() != 131072 {
+			t.Errorf("%s:mismatch. createType=%s flags=0x%x size=%d", v.name, d.CreateType, d.Header.Flags, d.Size())
+		}
+		f.Close()
+	}
+
+	desc := []byte("# Disk DescriptorFile\nversion=1\ncreateType=\"monolithicFlat\"\n")
+	if _, err := vdisk.NewVmdk(bytes.NewReader(desc), int64(len(desc))); !errors.Is(err, vdisk.ErrUnsupported) {
+		t.Errorf("error mismatch\n given :%v\n expect:%s", err, vdisk.ErrUnsupported)
+	}
+}